@@ -0,0 +1,129 @@
+// Package api has the types sent and received by the hyper-cas API. It has
+// no dependencies, so services that embed the client don't get the ones of
+// the server.
+package api
+
+import "time"
+
+// Types of the events emitted when labels or distributions change
+const (
+	EventLabelUpdated        = "label.updated"
+	EventLabelDeleted        = "label.deleted"
+	EventLabelDomainsUpdated = "label.domains_updated"
+	EventLabelReverted       = "label.reverted"
+	EventDistroCreated       = "distro.created"
+)
+
+// Event emitted whenever labels or distributions change. OldHash is the
+// distribution a label pointed to before it was updated or deleted and is
+// empty for new labels.
+type Event struct {
+	Type    string `json:"type"`
+	Label   string `json:"label,omitempty"`
+	Hash    string `json:"hash,omitempty"`
+	OldHash string `json:"oldHash,omitempty"`
+	// Revision of the label after label.updated events
+	Revision int64 `json:"revision,omitempty"`
+	// Reverted is set on the changes made to revert labels after the proxy
+	// failed to load their configuration
+	Reverted bool      `json:"reverted,omitempty"`
+	Time     time.Time `json:"time"`
+}
+
+// Sort orders supported when listing labels and distributions
+const (
+	SortByName     = "name"
+	SortByModified = "modified"
+)
+
+// ListOptions filter and paginate labels and distributions. Entries are
+// sorted by name or by modification time (most recent first) and at most
+// Limit entries are returned after Cursor. A zero Limit returns all of them.
+type ListOptions struct {
+	Prefix string
+	Cursor string
+	Limit  int
+	SortBy string
+}
+
+// LabelInfo describes a label and the distribution it points to. The
+// revision of a label increases every time it is updated.
+type LabelInfo struct {
+	Name      string    `json:"name"`
+	Hash      string    `json:"hash"`
+	Revision  int64     `json:"revision"`
+	UpdatedAt time.Time `json:"updatedAt"`
+
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Domains     []string          `json:"domains,omitempty"`
+}
+
+// LabelList is a page of labels
+type LabelList struct {
+	Labels     []*LabelInfo `json:"labels"`
+	NextCursor string       `json:"nextCursor,omitempty"`
+}
+
+// LabelDomains are the custom domains of a label
+type LabelDomains struct {
+	Label   string   `json:"label"`
+	Domains []string `json:"domains"`
+}
+
+// DistroInfo describes a distribution and the files in it
+type DistroInfo struct {
+	Hash       string    `json:"hash"`
+	FileCount  int       `json:"fileCount"`
+	TotalBytes int64     `json:"totalBytes"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// DistroList is a page of distributions
+type DistroList struct {
+	Distros    []*DistroInfo `json:"distros"`
+	NextCursor string        `json:"nextCursor,omitempty"`
+}
+
+// Types of the entries of a distribution
+const (
+	DistroEntryFile = "file"
+	DistroEntryDir  = "dir"
+)
+
+// DistroEntry is a file or directory inside a distribution
+type DistroEntry struct {
+	Name      string `json:"name"`
+	Type      string `json:"type"`
+	Hash      string `json:"hash,omitempty"`
+	Size      int64  `json:"size"`
+	FileCount int    `json:"fileCount,omitempty"`
+}
+
+// DistroDir lists a directory inside a distribution
+type DistroDir struct {
+	Distro  string         `json:"distro"`
+	Path    string         `json:"path"`
+	Entries []*DistroEntry `json:"entries"`
+}
+
+// Statuses of a webhook delivery
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// Delivery of an event to a webhook endpoint
+type Delivery struct {
+	ID             string     `json:"id"`
+	Endpoint       string     `json:"endpoint"`
+	URL            string     `json:"url"`
+	Event          Event      `json:"event"`
+	Status         string     `json:"status"`
+	Attempts       int        `json:"attempts"`
+	LastStatusCode int        `json:"lastStatusCode,omitempty"`
+	LastError      string     `json:"lastError,omitempty"`
+	CreatedAt      time.Time  `json:"createdAt"`
+	NextAttemptAt  time.Time  `json:"nextAttemptAt"`
+	CompletedAt    *time.Time `json:"completedAt,omitempty"`
+}
@@ -0,0 +1,290 @@
+// Package client is a Go client for the hyper-cas API.
+//
+//	c, err := client.New("https://hyper-cas.internal", client.WithToken(token))
+//	hash, err := c.PutFile(ctx, strings.NewReader("contents"))
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+
+	"github.com/gojektech/heimdall"
+)
+
+// RequestIDHeader identifies requests in hyper-cas logs
+const RequestIDHeader = "X-Request-Id"
+
+// Doer sends HTTP requests. *http.Client and heimdall clients implement it.
+type Doer interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// Authenticator adds credentials to requests sent to hyper-cas
+type Authenticator interface {
+	Authenticate(req *http.Request) error
+}
+
+// BearerToken authenticates requests with an API token
+type BearerToken string
+
+// Authenticate sets the Authorization header of the request
+func (t BearerToken) Authenticate(req *http.Request) error {
+	if t != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", string(t)))
+	}
+	return nil
+}
+
+// Client for the hyper-cas API. It is safe for concurrent use.
+type Client struct {
+	baseURL *url.URL
+	doer    Doer
+	auth    Authenticator
+	retries int
+	backoff heimdall.Backoff
+
+	timeout             time.Duration
+	tlsConfig           *tls.Config
+	transport           http.RoundTripper
+	maxIdleConnsPerHost int
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithDoer sends requests with doer instead of the default HTTP client.
+// Timeout, TLS and transport options are ignored when it is set.
+func WithDoer(doer Doer) Option {
+	return func(c *Client) { c.doer = doer }
+}
+
+// WithTransport sends requests with the round tripper, e.g. to dial
+// hyper-cas through a proxy
+func WithTransport(transport http.RoundTripper) Option {
+	return func(c *Client) { c.transport = transport }
+}
+
+// WithTimeout of each request, including reading the response body
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) { c.timeout = timeout }
+}
+
+// WithTLSConfig used to connect to hyper-cas
+func WithTLSConfig(config *tls.Config) Option {
+	return func(c *Client) { c.tlsConfig = config }
+}
+
+// WithMaxIdleConnsPerHost kept open by the default transport
+func WithMaxIdleConnsPerHost(count int) Option {
+	return func(c *Client) { c.maxIdleConnsPerHost = count }
+}
+
+// WithAuth adds credentials to every request
+func WithAuth(auth Authenticator) Option {
+	return func(c *Client) { c.auth = auth }
+}
+
+// WithToken authenticates every request with the API token
+func WithToken(token string) Option {
+	return WithAuth(BearerToken(token))
+}
+
+// WithRetries retries requests that fail to be sent or that get a 5xx
+// response up to count times, waiting according to backoff between them.
+// A nil backoff keeps the default constant backoff of a few milliseconds.
+func WithRetries(count int, backoff heimdall.Backoff) Option {
+	return func(c *Client) {
+		c.retries = count
+		if backoff != nil {
+			c.backoff = backoff
+		}
+	}
+}
+
+// New client for the hyper-cas API in baseURL
+func New(baseURL string, options ...Option) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid hyper-cas url %s: %v", baseURL, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid hyper-cas url %s: scheme must be http or https", baseURL)
+	}
+	c := &Client{
+		baseURL:             u,
+		auth:                BearerToken(""),
+		backoff:             heimdall.NewConstantBackoff(2*time.Millisecond, 5*time.Millisecond),
+		maxIdleConnsPerHost: http.DefaultMaxIdleConnsPerHost,
+	}
+	for _, option := range options {
+		option(c)
+	}
+	if c.doer == nil {
+		c.doer = c.newHTTPClient()
+	}
+	return c, nil
+}
+
+func (c *Client) newHTTPClient() *http.Client {
+	transport := c.transport
+	if transport == nil {
+		defaultTransport := http.DefaultTransport.(*http.Transport).Clone()
+		defaultTransport.MaxIdleConnsPerHost = c.maxIdleConnsPerHost
+		if c.tlsConfig != nil {
+			defaultTransport.TLSClientConfig = c.tlsConfig
+		}
+		transport = defaultTransport
+	}
+	return &http.Client{Timeout: c.timeout, Transport: transport}
+}
+
+type requestIDKey struct{}
+
+// WithRequestID sends requests made with the context with the request ID,
+// so they can be found in hyper-cas logs
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, requestID)
+}
+
+// RequestID sent with requests made with the context
+func RequestID(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+	return requestID
+}
+
+func (c *Client) url(route string, query url.Values) string {
+	u := *c.baseURL
+	u.Path = path.Join(u.Path, route)
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// do sends the request, retrying it when possible. Bodies that can't be
+// rewound (anything but an io.ReadSeeker) are never retried.
+func (c *Client) do(ctx context.Context, method, route string, query url.Values, body io.Reader, header http.Header) (*http.Response, error) {
+	seeker, canRewind := body.(io.ReadSeeker)
+	retries := c.retries
+	if body != nil && !canRewind {
+		retries = 0
+	}
+
+	var lastErr error
+	for attempt := 0; attempt <= retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(c.backoff.Next(attempt)):
+			}
+			if canRewind {
+				_, err := seeker.Seek(0, io.SeekStart)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		res, err := c.send(ctx, method, route, query, body, header)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			lastErr = err
+			continue
+		}
+		if res.StatusCode >= 500 && attempt < retries {
+			lastErr = newAPIError(method, route, res)
+			continue
+		}
+		return res, nil
+	}
+	return nil, lastErr
+}
+
+func (c *Client) send(ctx context.Context, method, route string, query url.Values, body io.Reader, header http.Header) (*http.Response, error) {
+	var reqBody io.Reader
+	if body != nil {
+		// Keeps the client from closing the body, so it can be rewound
+		reqBody = ioutil.NopCloser(body)
+	}
+	req, err := http.NewRequest(method, c.url(route, query), reqBody)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if seeker, ok := body.(io.Seeker); ok {
+		req.ContentLength, err = remainingSize(seeker)
+		if err != nil {
+			return nil, err
+		}
+		if req.ContentLength == 0 {
+			req.Body = http.NoBody
+		}
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if requestID := RequestID(ctx); requestID != "" {
+		req.Header.Set(RequestIDHeader, requestID)
+	}
+	err = c.auth.Authenticate(req)
+	if err != nil {
+		return nil, err
+	}
+	return c.doer.Do(req)
+}
+
+func remainingSize(seeker io.Seeker) (int64, error) {
+	current, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	end, err := seeker.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	_, err = seeker.Seek(current, io.SeekStart)
+	return end - current, err
+}
+
+// call sends the request and reads the response body, returning an error
+// for responses with status codes other than 2xx
+func (c *Client) call(ctx context.Context, method, route string, query url.Values, body io.Reader, header http.Header) ([]byte, error) {
+	res, err := c.do(ctx, method, route, query, body, header)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return nil, newAPIError(method, route, res)
+	}
+	return ioutil.ReadAll(res.Body)
+}
+
+// exists sends a HEAD request, returning whether the resource exists
+func (c *Client) exists(ctx context.Context, route string) (bool, error) {
+	_, err := c.call(ctx, "HEAD", route, nil, nil, nil)
+	if IsNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Health returns an error if hyper-cas is not running
+func (c *Client) Health(ctx context.Context) error {
+	_, err := c.call(ctx, "GET", "/healthcheck", nil, nil, nil)
+	return err
+}
+
+// Ready returns an error if hyper-cas is not ready to serve requests
+func (c *Client) Ready(ctx context.Context) error {
+	_, err := c.call(ctx, "GET", "/readiness", nil, nil, nil)
+	return err
+}
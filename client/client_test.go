@@ -0,0 +1,224 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+	"github.com/vtex/hyper-cas/api"
+	"github.com/vtex/hyper-cas/serve"
+	"github.com/vtex/hyper-cas/storage"
+	"github.com/vtex/hyper-cas/utils"
+)
+
+func TestMain(m *testing.M) {
+	utils.SetTestStorage()
+	viper.Set("file.enableLocks", true)
+	viper.Set("file.lockTimeoutMs", 100)
+	os.Exit(m.Run())
+}
+
+func newTestClient(t *testing.T, options ...Option) *Client {
+	app, err := serve.NewApp(200, storage.FileSystem)
+	assert.NoError(t, err)
+	ln := fasthttputil.NewInmemoryListener()
+	go fasthttp.Serve(ln, app.Handler())
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+	}
+	c, err := New("http://hyper-cas", append([]Option{WithTransport(transport)}, options...)...)
+	assert.NoError(t, err)
+	return c
+}
+
+func TestClientFiles(t *testing.T) {
+	c := newTestClient(t)
+	ctx := context.Background()
+
+	hash, err := c.PutFile(ctx, strings.NewReader("client file"))
+	assert.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("%x", utils.Hash("client file")), hash)
+
+	has, err := c.HasFile(ctx, hash)
+	assert.NoError(t, err)
+	assert.True(t, has)
+
+	body, err := c.GetFile(ctx, hash)
+	assert.NoError(t, err)
+	contents, err := ioutil.ReadAll(body)
+	body.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, "client file", string(contents))
+
+	other := fmt.Sprintf("%x", utils.Hash("not stored by the client"))
+	missing, err := c.MissingFiles(ctx, []string{hash, other})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{other}, missing)
+
+	_, err = c.GetFile(ctx, other)
+	assert.True(t, errors.Is(err, ErrNotFound))
+}
+
+func TestClientPutFileWithWrongHash(t *testing.T) {
+	c := newTestClient(t)
+
+	err := c.PutFileWithHash(context.Background(), fmt.Sprintf("%x", utils.Hash("other")), strings.NewReader("contents"))
+
+	assert.True(t, errors.Is(err, ErrHashMismatch), "%v", err)
+	assert.False(t, errors.Is(err, ErrConflict))
+	var apiErr *APIError
+	assert.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, 422, apiErr.StatusCode)
+	assert.Equal(t, "hash_mismatch", apiErr.Code)
+	assert.Contains(t, apiErr.Message, "The file contents hash to")
+	assert.NotEmpty(t, apiErr.RequestID)
+}
+
+func TestClientDistrosAndLabels(t *testing.T) {
+	c := newTestClient(t)
+	ctx := WithRequestID(context.Background(), "client-test")
+	fileHash, err := c.PutFile(ctx, strings.NewReader("client distro file"))
+	assert.NoError(t, err)
+
+	distro, err := c.PutDistro(ctx, map[string]string{"index.html": fileHash})
+	assert.NoError(t, err)
+	items, err := c.GetDistro(ctx, distro)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{fmt.Sprintf("index.html:%s", fileHash)}, items)
+	has, err := c.HasDistro(ctx, distro)
+	assert.NoError(t, err)
+	assert.True(t, has)
+
+	assert.NoError(t, c.SetLabel(ctx, "client-label", distro))
+	hash, err := c.GetLabel(ctx, "client-label")
+	assert.NoError(t, err)
+	assert.Equal(t, distro, hash)
+	labels, err := c.ListLabels(ctx, api.ListOptions{Prefix: "client-"})
+	assert.NoError(t, err)
+	assert.Len(t, labels.Labels, 1)
+	assert.Equal(t, distro, labels.Labels[0].Hash)
+
+	file, err := c.GetDistroFile(ctx, "client-label", "/index.html")
+	assert.NoError(t, err)
+	contents, err := ioutil.ReadAll(file)
+	file.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, "client distro file", string(contents))
+	dir, err := c.ListDistroDir(ctx, distro, "")
+	assert.NoError(t, err)
+	assert.Len(t, dir.Entries, 1)
+	assert.Equal(t, "index.html", dir.Entries[0].Name)
+
+	assert.NoError(t, c.SetLabelWithAnnotations(ctx, "client-label", distro, map[string]string{"team": "web"}))
+	labels, err = c.ListLabels(ctx, api.ListOptions{Prefix: "client-"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"team": "web"}, labels.Labels[0].Annotations)
+	domain := fmt.Sprintf("client-%s.example.com", strings.ToLower(utils.RandString(8)))
+	domains, err := c.SetLabelDomains(ctx, "client-label", []string{strings.ToUpper(domain)})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{domain}, domains)
+	domains, err = c.GetLabelDomains(ctx, "client-label")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{domain}, domains)
+
+	assert.NoError(t, c.DeleteLabel(ctx, "client-label"))
+	has, err = c.HasLabel(ctx, "client-label")
+	assert.NoError(t, err)
+	assert.False(t, has)
+	_, err = c.GetLabel(ctx, "client-label")
+	assert.True(t, IsNotFound(err))
+}
+
+func TestClientWatchLabels(t *testing.T) {
+	c := newTestClient(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	prefix := "client-watch-" + strings.ToLower(utils.RandString(8))
+	fileHash, err := c.PutFile(ctx, strings.NewReader("client watch"))
+	assert.NoError(t, err)
+	distro, err := c.PutDistro(ctx, map[string]string{"index.html": fileHash})
+	assert.NoError(t, err)
+	assert.NoError(t, c.SetLabel(ctx, prefix+"-a", distro))
+
+	events := make(chan string, 10)
+	done := make(chan error, 1)
+	go func() {
+		done <- c.WatchLabels(ctx, prefix, func(event string, info *api.LabelInfo) error {
+			events <- fmt.Sprintf("%s %s", event, info.Name)
+			return nil
+		})
+	}()
+	assert.Equal(t, api.EventLabelUpdated+" "+prefix+"-a", <-events)
+	assert.NoError(t, c.DeleteLabel(ctx, prefix+"-a"))
+	select {
+	case event := <-events:
+		assert.Equal(t, api.EventLabelDeleted+" "+prefix+"-a", event)
+	case <-time.After(2 * time.Second):
+		t.Fatal("The watch did not get the deleted label.")
+	}
+
+	cancel()
+	assert.NoError(t, <-done)
+}
+
+func TestClientWebhookDeliveries(t *testing.T) {
+	c := newTestClient(t)
+
+	deliveries, err := c.WebhookDeliveries(context.Background(), api.DeliveryFailed, 10)
+
+	assert.NoError(t, err)
+	for _, delivery := range deliveries {
+		assert.Equal(t, api.DeliveryFailed, delivery.Status)
+	}
+	_, err = c.WebhookDeliveries(context.Background(), "unknown", 0)
+	assert.True(t, errors.Is(err, ErrInvalidInput), "%v", err)
+}
+
+func TestClientAuthErrors(t *testing.T) {
+	viper.Set("auth.enabled", true)
+	defer viper.Set("auth.enabled", false)
+	c := newTestClient(t, WithToken("invalid"))
+
+	_, err := c.PutFile(context.Background(), strings.NewReader("contents"))
+
+	assert.True(t, errors.Is(err, ErrUnauthorized), "%v", err)
+}
+
+func TestClientRetries(t *testing.T) {
+	var requests int32
+	var requestID string
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		requestID = r.Header.Get(RequestIDHeader)
+		if atomic.AddInt32(&requests, 1) < 3 {
+			w.WriteHeader(503)
+			return
+		}
+		fmt.Fprintf(w, "%x", utils.Hash(string(body)))
+	}))
+	defer stub.Close()
+	c, err := New(stub.URL, WithRetries(2, nil))
+	assert.NoError(t, err)
+
+	hash, err := c.PutFile(WithRequestID(context.Background(), "retried"), strings.NewReader("retried contents"))
+
+	assert.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("%x", utils.Hash("retried contents")), hash)
+	assert.Equal(t, int32(3), requests)
+	assert.Equal(t, "retried", requestID)
+}
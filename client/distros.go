@@ -0,0 +1,114 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/vtex/hyper-cas/api"
+)
+
+// PutDistro with the files in it (path to hash), returning its hash
+func (c *Client) PutDistro(ctx context.Context, files map[string]string) (string, error) {
+	paths := make([]string, 0, len(files))
+	for path := range files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	var sb strings.Builder
+	for _, path := range paths {
+		sb.WriteString(path)
+		sb.WriteString(":")
+		sb.WriteString(files[path])
+		sb.WriteString("\n")
+	}
+	hash, err := c.call(ctx, "PUT", "/distro", nil, strings.NewReader(sb.String()), nil)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// GetDistro returns the files in the distribution as `path:hash` items
+func (c *Client) GetDistro(ctx context.Context, hash string) ([]string, error) {
+	body, err := c.call(ctx, "GET", fmt.Sprintf("/distro/%s", hash), nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	var items []string
+	err = json.Unmarshal(body, &items)
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// HasDistro returns whether the distribution is in hyper-cas
+func (c *Client) HasDistro(ctx context.Context, hash string) (bool, error) {
+	return c.exists(ctx, fmt.Sprintf("/distro/%s", hash))
+}
+
+// ListDistros returns a page of distributions
+func (c *Client) ListDistros(ctx context.Context, options api.ListOptions) (*api.DistroList, error) {
+	body, err := c.call(ctx, "GET", "/distros", listQuery(options), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	var list api.DistroList
+	err = json.Unmarshal(body, &list)
+	if err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+// GetDistroFile streams the file in path inside the distribution, which is
+// either a distribution hash or a label. The caller must close it.
+func (c *Client) GetDistroFile(ctx context.Context, distro, path string) (io.ReadCloser, error) {
+	route := fmt.Sprintf("/distro/%s/files/%s", distro, strings.TrimPrefix(path, "/"))
+	res, err := c.do(ctx, "GET", route, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != 200 {
+		return nil, newAPIError("GET", route, res)
+	}
+	return res.Body, nil
+}
+
+// ListDistroDir lists the directory in dir inside the distribution, which
+// is either a distribution hash or a label. An empty dir is the root.
+func (c *Client) ListDistroDir(ctx context.Context, distro, dir string) (*api.DistroDir, error) {
+	body, err := c.call(ctx, "GET", fmt.Sprintf("/distro/%s/ls/%s", distro, strings.Trim(dir, "/")), nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	var list api.DistroDir
+	err = json.Unmarshal(body, &list)
+	if err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+func listQuery(options api.ListOptions) url.Values {
+	query := url.Values{}
+	if options.Prefix != "" {
+		query.Set("prefix", options.Prefix)
+	}
+	if options.Cursor != "" {
+		query.Set("cursor", options.Cursor)
+	}
+	if options.SortBy != "" {
+		query.Set("sort", options.SortBy)
+	}
+	if options.Limit > 0 {
+		query.Set("limit", strconv.Itoa(options.Limit))
+	}
+	return query
+}
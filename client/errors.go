@@ -0,0 +1,109 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// Errors returned for the status codes of hyper-cas responses. Use
+// errors.Is to check for them.
+var (
+	ErrInvalidInput = errors.New("invalid input")
+	ErrNotFound     = errors.New("not found")
+	ErrConflict     = errors.New("conflict")
+	ErrHashMismatch = errors.New("hash mismatch")
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrForbidden    = errors.New("forbidden")
+	ErrLocked       = errors.New("locked")
+	ErrUnavailable  = errors.New("unavailable")
+)
+
+// maxErrorBodySize read from error responses
+const maxErrorBodySize = 4096
+
+// APIError is returned when hyper-cas answers with an unexpected status code.
+// Code and Message are set when the response has a JSON error body.
+type APIError struct {
+	Method     string
+	Route      string
+	StatusCode int
+	Code       string
+	Message    string
+	Body       string
+	RequestID  string
+}
+
+// errorBody is the JSON body of hyper-cas error responses
+type errorBody struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"requestId"`
+}
+
+func newAPIError(method, route string, res *http.Response) *APIError {
+	body, _ := ioutil.ReadAll(io.LimitReader(res.Body, maxErrorBodySize))
+	res.Body.Close()
+	apiErr := &APIError{
+		Method:     method,
+		Route:      route,
+		StatusCode: res.StatusCode,
+		Body:       strings.TrimSpace(string(body)),
+		RequestID:  res.Header.Get(RequestIDHeader),
+	}
+	var parsed errorBody
+	if json.Unmarshal(body, &parsed) == nil && parsed.Code != "" {
+		apiErr.Code = parsed.Code
+		apiErr.Message = parsed.Message
+		if parsed.RequestID != "" {
+			apiErr.RequestID = parsed.RequestID
+		}
+	}
+	return apiErr
+}
+
+func (e *APIError) Error() string {
+	message := fmt.Sprintf("%s %s failed with status code %d", e.Method, e.Route, e.StatusCode)
+	switch {
+	case e.Code != "":
+		message = fmt.Sprintf("%s (%s): %s", message, e.Code, e.Message)
+	case e.Body != "":
+		message = fmt.Sprintf("%s: %s", message, e.Body)
+	}
+	if e.RequestID != "" {
+		message = fmt.Sprintf("%s (request id: %s)", message, e.RequestID)
+	}
+	return message
+}
+
+// Unwrap the error matching the status code, if any
+func (e *APIError) Unwrap() error {
+	switch e.StatusCode {
+	case 400:
+		return ErrInvalidInput
+	case 401:
+		return ErrUnauthorized
+	case 403:
+		return ErrForbidden
+	case 404:
+		return ErrNotFound
+	case 409:
+		return ErrConflict
+	case 422:
+		return ErrHashMismatch
+	case 423:
+		return ErrLocked
+	case 503:
+		return ErrUnavailable
+	}
+	return nil
+}
+
+// IsNotFound returns whether err is an ErrNotFound
+func IsNotFound(err error) bool {
+	return errors.Is(err, ErrNotFound)
+}
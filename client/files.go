@@ -0,0 +1,75 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// PutFile stores the contents read from body, returning their hash
+func (c *Client) PutFile(ctx context.Context, body io.Reader) (string, error) {
+	hash, err := c.call(ctx, "PUT", "/file", nil, body, nil)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// PutFileWithHash stores the contents read from body if they hash to hash.
+// Returns ErrHashMismatch if they don't.
+func (c *Client) PutFileWithHash(ctx context.Context, hash string, body io.Reader) error {
+	_, err := c.call(ctx, "PUT", fmt.Sprintf("/file/%s", hash), nil, body, nil)
+	return err
+}
+
+// GetFile streams the contents of the file. The caller must close it.
+func (c *Client) GetFile(ctx context.Context, hash string) (io.ReadCloser, error) {
+	route := fmt.Sprintf("/file/%s", hash)
+	res, err := c.do(ctx, "GET", route, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != 200 {
+		return nil, newAPIError("GET", route, res)
+	}
+	return res.Body, nil
+}
+
+// HasFile returns whether the file is in hyper-cas
+func (c *Client) HasFile(ctx context.Context, hash string) (bool, error) {
+	return c.exists(ctx, fmt.Sprintf("/file/%s", hash))
+}
+
+// MissingFiles returns the hashes that are not in hyper-cas yet
+func (c *Client) MissingFiles(ctx context.Context, hashes []string) ([]string, error) {
+	body, err := c.call(ctx, "POST", "/files/missing", nil, strings.NewReader(strings.Join(hashes, "\n")), nil)
+	if err != nil {
+		return nil, err
+	}
+	var missing []string
+	err = json.Unmarshal(body, &missing)
+	if err != nil {
+		return nil, err
+	}
+	return missing, nil
+}
+
+// PutArchive stores every file in a tar archive (optionally gzipped),
+// returning the hash of each entry
+func (c *Client) PutArchive(ctx context.Context, archive io.Reader) (map[string]string, error) {
+	header := http.Header{}
+	header.Set("Content-Type", "application/x-tar")
+	body, err := c.call(ctx, "PUT", "/files/archive", nil, archive, header)
+	if err != nil {
+		return nil, err
+	}
+	var hashes map[string]string
+	err = json.Unmarshal(body, &hashes)
+	if err != nil {
+		return nil, err
+	}
+	return hashes, nil
+}
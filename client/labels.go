@@ -0,0 +1,184 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vtex/hyper-cas/api"
+)
+
+// SetLabel to the distribution with the hash
+func (c *Client) SetLabel(ctx context.Context, label, hash string) error {
+	return c.SetLabelWithAnnotations(ctx, label, hash, nil)
+}
+
+// SetLabelWithAnnotations sets the label to the distribution with the hash,
+// replacing the annotations of the label
+func (c *Client) SetLabelWithAnnotations(ctx context.Context, label, hash string, annotations map[string]string) error {
+	body, err := json.Marshal(map[string]interface{}{"label": label, "hash": hash, "annotations": annotations})
+	if err != nil {
+		return err
+	}
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	_, err = c.call(ctx, "PUT", "/label", nil, bytes.NewReader(body), header)
+	return err
+}
+
+// GetLabel returns the hash of the distribution the label points to
+func (c *Client) GetLabel(ctx context.Context, label string) (string, error) {
+	hash, err := c.call(ctx, "GET", fmt.Sprintf("/label/%s", label), nil, nil, nil)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// HasLabel returns whether the label exists
+func (c *Client) HasLabel(ctx context.Context, label string) (bool, error) {
+	return c.exists(ctx, fmt.Sprintf("/label/%s", label))
+}
+
+// DeleteLabel and its site configuration
+func (c *Client) DeleteLabel(ctx context.Context, label string) error {
+	_, err := c.call(ctx, "DELETE", fmt.Sprintf("/label/%s", label), nil, nil, nil)
+	return err
+}
+
+// ListLabels returns a page of labels
+func (c *Client) ListLabels(ctx context.Context, options api.ListOptions) (*api.LabelList, error) {
+	body, err := c.call(ctx, "GET", "/labels", listQuery(options), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	var list api.LabelList
+	err = json.Unmarshal(body, &list)
+	if err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+// WatchLabel waits up to timeout for the revision of the label to differ
+// from revision (0 if the label does not exist yet), returning the label.
+// Returns nil if the label did not change in time and ErrNotFound if it
+// does not exist.
+func (c *Client) WatchLabel(ctx context.Context, label string, revision int64, timeout time.Duration) (*api.LabelInfo, error) {
+	query := url.Values{}
+	query.Set("revision", strconv.FormatInt(revision, 10))
+	query.Set("timeoutMs", strconv.FormatInt(timeout.Milliseconds(), 10))
+	route := fmt.Sprintf("/label/%s/watch", label)
+	res, err := c.do(ctx, "GET", route, query, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case 200:
+	case 304:
+		return nil, nil
+	default:
+		return nil, newAPIError("GET", route, res)
+	}
+	var info api.LabelInfo
+	err = json.NewDecoder(res.Body).Decode(&info)
+	if err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// WatchLabels calls handler with the current state of the labels with the
+// prefix (every label if empty) and then with every change to them, until
+// ctx is done, handler returns an error or the server ends the watch. The
+// event is api.EventLabelUpdated or api.EventLabelDeleted. The timeout of the
+// client must be longer than the watch or zero.
+func (c *Client) WatchLabels(ctx context.Context, prefix string, handler func(event string, info *api.LabelInfo) error) error {
+	query := url.Values{}
+	if prefix != "" {
+		query.Set("prefix", prefix)
+	}
+	header := http.Header{}
+	header.Set("Accept", "text/event-stream")
+	res, err := c.do(ctx, "GET", "/labels/watch", query, nil, header)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		return newAPIError("GET", "/labels/watch", res)
+	}
+
+	var event, data string
+	scanner := bufio.NewScanner(res.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if event == "" {
+				continue
+			}
+			var info api.LabelInfo
+			err = json.Unmarshal([]byte(data), &info)
+			if err != nil {
+				return err
+			}
+			err = handler(event, &info)
+			if err != nil {
+				return err
+			}
+			event, data = "", ""
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+	return scanner.Err()
+}
+
+// GetLabelDomains returns the custom domains of the label
+func (c *Client) GetLabelDomains(ctx context.Context, label string) ([]string, error) {
+	body, err := c.call(ctx, "GET", fmt.Sprintf("/label/%s/domains", label), nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	var domains api.LabelDomains
+	err = json.Unmarshal(body, &domains)
+	if err != nil {
+		return nil, err
+	}
+	return domains.Domains, nil
+}
+
+// SetLabelDomains replaces the custom domains of the label, returning them
+// normalized. Returns ErrConflict if a domain belongs to another label.
+func (c *Client) SetLabelDomains(ctx context.Context, label string, domains []string) ([]string, error) {
+	body, err := json.Marshal(&api.LabelDomains{Domains: domains})
+	if err != nil {
+		return nil, err
+	}
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	body, err = c.call(ctx, "PUT", fmt.Sprintf("/label/%s/domains", label), nil, bytes.NewReader(body), header)
+	if err != nil {
+		return nil, err
+	}
+	var updated api.LabelDomains
+	err = json.Unmarshal(body, &updated)
+	if err != nil {
+		return nil, err
+	}
+	return updated.Domains, nil
+}
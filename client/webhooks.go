@@ -0,0 +1,33 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"strconv"
+
+	"github.com/vtex/hyper-cas/api"
+)
+
+// WebhookDeliveries returns the most recent webhook deliveries with the
+// status (any status if empty), up to limit (the server default if zero).
+// Requires a token with the admin scope.
+func (c *Client) WebhookDeliveries(ctx context.Context, status string, limit int) ([]*api.Delivery, error) {
+	query := url.Values{}
+	if status != "" {
+		query.Set("status", status)
+	}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	body, err := c.call(ctx, "GET", "/webhooks/deliveries", query, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	var deliveries []*api.Delivery
+	err = json.Unmarshal(body, &deliveries)
+	if err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
@@ -1,17 +1,25 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"os"
+	"time"
 
 	"github.com/spf13/cobra"
-	"github.com/vtex/hyper-cas/synchronizer"
+	"github.com/vtex/hyper-cas/client"
 )
 
 var labelName string
 var labelHash string
 var labelRetries int
 var labelURL string
+var labelToken string
+var labelCACert string
+var labelClientCert string
+var labelClientKey string
 
 // labelCmd represents the label command
 var labelCmd = &cobra.Command{
@@ -19,22 +27,26 @@ var labelCmd = &cobra.Command{
 	Short: "Updates a label to a given distribution in hyper-cas",
 	Long:  `set-label will set the specified label to the specified tree hash in hyper-cas`,
 	Run: func(cmd *cobra.Command, args []string) {
-		var err error
-		s := synchronizer.NewSync("", labelURL, 0, 1, 5000, 300000)
-		retries := 0
+		c, err := newClient(labelURL, labelToken, labelCACert, labelClientCert, labelClientKey, client.WithTimeout(5*time.Second))
+		if err != nil {
+			log.Fatalln(err)
+		}
+		ctx := context.Background()
 		for i := 0; i <= labelRetries; i++ {
-			hasDistro := s.HasDistro(labelHash)
-			if !hasDistro {
+			var hasDistro bool
+			hasDistro, err = c.HasDistro(ctx, labelHash)
+			if err == nil && !hasDistro {
 				log.Fatalf("Distribution %s was not found\n", labelHash)
 			}
-			err = s.SetLabel(labelName, labelHash)
 			if err == nil {
+				err = c.SetLabel(ctx, labelName, labelHash)
+			}
+			if err == nil || errors.Is(err, client.ErrUnauthorized) || errors.Is(err, client.ErrForbidden) {
 				break
 			}
-			retries++
 		}
-		if retries > labelRetries {
-			log.Fatalf("Distribution %s could not be set to label %s: %v\n", labelHash, labelName, err)
+		if err != nil {
+			exitWithError(fmt.Sprintf("Distribution %s could not be set to label %s.", labelHash, labelName), err)
 		}
 		fmt.Println("Label set successfully.")
 	},
@@ -46,4 +58,8 @@ func init() {
 	labelCmd.Flags().StringVarP(&labelName, "name", "n", "", "Label to set the hash of the distribution to")
 	labelCmd.Flags().StringVarP(&labelHash, "hash", "a", "", "Distribution hash to set the label to")
 	labelCmd.Flags().IntVarP(&labelRetries, "retries", "r", 3, "Number of times to retry setting the label")
+	labelCmd.Flags().StringVar(&labelCACert, "ca-cert", "", "PEM file with the CA certificates used to verify the hyper-cas API certificate")
+	labelCmd.Flags().StringVar(&labelClientCert, "client-cert", "", "PEM file with the client certificate presented to the hyper-cas API")
+	labelCmd.Flags().StringVar(&labelClientKey, "client-key", "", "PEM file with the key of the client certificate")
+	labelCmd.Flags().StringVarP(&labelToken, "token", "k", os.Getenv(tokenEnvVar), fmt.Sprintf("API token to authenticate with hyper-cas (defaults to $%s)", tokenEnvVar))
 }
@@ -1,16 +1,52 @@
 package cmd
 
 import (
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
 
 	"github.com/spf13/cobra"
+	"github.com/vtex/hyper-cas/client"
 	"github.com/vtex/hyper-cas/utils"
 	"go.uber.org/zap"
 
 	"github.com/spf13/viper"
 )
 
+// tokenEnvVar holds the API token used by client commands when --token is not set
+const tokenEnvVar = "HYPER_CAS_TOKEN"
+
+// newClient for the hyper-cas API authenticated with token. The CA and client
+// certificate files are only used when set.
+func newClient(apiURL, token, caFile, certFile, keyFile string, options ...client.Option) (*client.Client, error) {
+	options = append(options, client.WithToken(token))
+	if caFile != "" || certFile != "" || keyFile != "" {
+		tlsConfig, err := utils.NewClientTLSConfig(caFile, certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to load TLS certificates: %v", err)
+		}
+		options = append(options, client.WithTLSConfig(tlsConfig))
+	}
+	return client.New(apiURL, options...)
+}
+
+// exitWithError prints err, with the code, message and request ID of
+// hyper-cas error responses, and exits with a non-zero status
+func exitWithError(message string, err error) {
+	fmt.Fprintf(os.Stderr, "Error: %s\n", message)
+	var apiErr *client.APIError
+	if errors.As(err, &apiErr) && apiErr.Code != "" {
+		fmt.Fprintf(os.Stderr, "  %s %s failed with status code %d (%s): %s\n", apiErr.Method, apiErr.Route, apiErr.StatusCode, apiErr.Code, apiErr.Message)
+		if apiErr.RequestID != "" {
+			fmt.Fprintf(os.Stderr, "  Request ID: %s\n", apiErr.RequestID)
+		}
+	} else {
+		fmt.Fprintf(os.Stderr, "  %v\n", err)
+	}
+	os.Exit(1)
+}
+
 var rootDebug bool
 var cfgFile string
 
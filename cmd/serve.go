@@ -11,6 +11,7 @@ import (
 )
 
 var servePort int
+var serveGRPCPort int
 var profile bool
 
 // serveCmd represents the serve command
@@ -22,7 +23,6 @@ distributions.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		storageType := storage.FileSystem
 		app, err := serve.NewApp(servePort, storageType)
-		app.EnableProfileRoutes(profile)
 		if err != nil {
 			utils.LogError(
 				"Starting hyper-cas storage API failed",
@@ -30,6 +30,8 @@ distributions.`,
 			)
 			os.Exit(1)
 		}
+		app.EnableProfileRoutes(profile)
+		app.EnableGRPC(serveGRPCPort)
 		app.ListenAndServe()
 	},
 }
@@ -46,5 +48,6 @@ func init() {
 	// Cobra supports local flags which will only run when this command
 	// is called directly, e.g.:
 	serveCmd.Flags().IntVarP(&servePort, "port", "p", 2485, "Port to run hyper-cas API in")
+	serveCmd.Flags().IntVar(&serveGRPCPort, "grpc-port", 0, "Port to run hyper-cas gRPC API in (disabled if 0)")
 	serveCmd.Flags().BoolVar(&profile, "profile", false, "Enable pprof debug routes")
 }
@@ -1,13 +1,16 @@
 package cmd
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
+	"github.com/vtex/hyper-cas/client"
 	"github.com/vtex/hyper-cas/synchronizer"
 )
 
@@ -19,6 +22,12 @@ var syncRequestRetries int
 var syncMaxConcurrentRequests int
 var syncHTTPTimeoutMs int
 var syncDistroHTTPTimeoutMs int
+var syncToken string
+var syncArchiveBatchSize int
+var syncArchiveMaxFileSize int
+var syncCACert string
+var syncClientCert string
+var syncClientKey string
 
 func folderExists(path string) bool {
 	info, err := os.Stat(path)
@@ -45,25 +54,36 @@ var syncCmd = &cobra.Command{
 		if !folderExists(folder) {
 			panic(fmt.Sprintf("Folder %s does not exist!", folder))
 		}
-		s := synchronizer.NewSync(
-			folder,
-			syncURL,
-			syncRequestRetries,
-			syncMaxConcurrentRequests,
-			syncHTTPTimeoutMs,
-			syncDistroHTTPTimeoutMs,
+		filesClient, err := newClient(
+			syncURL, syncToken, syncCACert, syncClientCert, syncClientKey,
+			client.WithRetries(syncRequestRetries, nil),
+			client.WithTimeout(time.Duration(syncHTTPTimeoutMs)*time.Millisecond),
+			client.WithMaxIdleConnsPerHost(syncMaxConcurrentRequests),
 		)
+		if err != nil {
+			panic(err)
+		}
+		metadataClient, err := newClient(
+			syncURL, syncToken, syncCACert, syncClientCert, syncClientKey,
+			client.WithRetries(syncRequestRetries, nil),
+			client.WithTimeout(time.Duration(syncDistroHTTPTimeoutMs)*time.Millisecond),
+		)
+		if err != nil {
+			panic(err)
+		}
+		s := synchronizer.NewSync(folder, filesClient, metadataClient, syncMaxConcurrentRequests)
+		s.SetArchiveBatching(syncArchiveBatchSize, syncArchiveMaxFileSize)
 		var result map[string]interface{}
 		retries := 0
 		for i := 0; i <= syncRetries; i++ {
-			result, err = s.Run(syncLabel)
+			result, err = s.Run(context.Background(), syncLabel)
 			if err == nil {
 				break
 			}
 			retries++
 		}
 		if result == nil {
-			panic(fmt.Errorf("Failed to synchronize folder: %v", err))
+			exitWithError("Failed to synchronize folder.", err)
 		}
 		result["retries"] = retries
 		if syncJSON {
@@ -88,6 +108,12 @@ func init() {
 	syncCmd.Flags().IntVarP(&syncMaxConcurrentRequests, "max-concurrent", "m", 50, "Maximum number of concurrent requests to hyper-cas")
 	syncCmd.Flags().IntVarP(&syncHTTPTimeoutMs, "timeout", "t", 5000, "Number of milliseconds to timeout per request to hyper-cas")
 	syncCmd.Flags().IntVarP(&syncDistroHTTPTimeoutMs, "distro-timeout", "o", 300000, "Number of milliseconds to timeout when writing the distro to hyper-cas")
+	syncCmd.Flags().IntVar(&syncArchiveBatchSize, "archive-batch-size", 0, "Maximum number of bytes in each archive of small files uploaded to hyper-cas (0 disables archives)")
+	syncCmd.Flags().IntVar(&syncArchiveMaxFileSize, "archive-max-file-size", 64*1024, "Files up to this number of bytes are packed into archives when archives are enabled")
+	syncCmd.Flags().StringVar(&syncCACert, "ca-cert", "", "PEM file with the CA certificates used to verify the hyper-cas API certificate")
+	syncCmd.Flags().StringVar(&syncClientCert, "client-cert", "", "PEM file with the client certificate presented to the hyper-cas API")
+	syncCmd.Flags().StringVar(&syncClientKey, "client-key", "", "PEM file with the key of the client certificate")
+	syncCmd.Flags().StringVarP(&syncToken, "token", "k", os.Getenv(tokenEnvVar), fmt.Sprintf("API token to authenticate with hyper-cas (defaults to $%s)", tokenEnvVar))
 }
 
 func printResult(result map[string]interface{}) {
@@ -110,9 +136,10 @@ func printResult(result map[string]interface{}) {
 		fmt.Printf("* Updated label %s => %s.\n", labelName, label["hash"])
 	}
 	fmt.Printf(
-		"Completed synchronizing %v files with %v retries in %vms.\n",
+		"Completed synchronizing %v files with %v retries in %vms (request id: %s).\n",
 		len(updatedFiles),
 		result["retries"],
 		result["duration"],
+		result["requestId"],
 	)
 }
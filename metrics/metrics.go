@@ -0,0 +1,182 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "hypercas"
+
+var (
+	// RequestsTotal served by the API by route and status code
+	RequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "Number of requests served by the API.",
+		},
+		[]string{"method", "route", "status"},
+	)
+
+	// RequestDuration of requests served by the API by route
+	RequestDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Duration of requests served by the API.",
+			Buckets:   prometheus.DefBuckets,
+		},
+		[]string{"method", "route"},
+	)
+
+	// GRPCRequestsTotal served by the gRPC API by method and status code
+	GRPCRequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "grpc_requests_total",
+			Help:      "Number of requests served by the gRPC API.",
+		},
+		[]string{"method", "code"},
+	)
+
+	// GRPCRequestDuration of requests served by the gRPC API by method
+	GRPCRequestDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "grpc_request_duration_seconds",
+			Help:      "Duration of requests served by the gRPC API.",
+			Buckets:   prometheus.DefBuckets,
+		},
+		[]string{"method"},
+	)
+
+	// BytesStored in the CAS
+	BytesStored = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "stored_bytes_total",
+		Help:      "Number of bytes of files stored in the CAS.",
+	})
+
+	// BytesServed from the CAS
+	BytesServed = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "served_bytes_total",
+		Help:      "Number of bytes of files served from the CAS.",
+	})
+
+	// FilesStored in the CAS
+	FilesStored = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "stored_files_total",
+		Help:      "Number of files uploaded to the CAS.",
+	})
+
+	// DuplicateFilesStored are uploads of files that were already in the CAS
+	DuplicateFilesStored = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "stored_duplicate_files_total",
+		Help:      "Number of uploaded files that were already in the CAS.",
+	})
+
+	// DedupHits are lookups of files that were already in the CAS
+	DedupHits = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "dedup_hits_total",
+		Help:      "Number of file lookups that found the file already in the CAS.",
+	})
+
+	// DistroMaterializationDuration is the time taken to create a distribution in storage
+	DistroMaterializationDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "distro_materialization_duration_seconds",
+		Help:      "Duration of the materialization of distributions in storage.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 15),
+	})
+
+	// LabelUpdates in storage
+	LabelUpdates = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "label_updates_total",
+		Help:      "Number of label updates.",
+	})
+
+	// LockWaitDuration is the time spent waiting for file locks
+	LockWaitDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "lock_wait_duration_seconds",
+		Help:      "Time spent waiting to acquire file locks.",
+		Buckets:   prometheus.ExponentialBuckets(0.0001, 2, 16),
+	})
+
+	// LockTimeouts when acquiring file locks
+	LockTimeouts = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "lock_timeouts_total",
+		Help:      "Number of times a file lock could not be acquired in time.",
+	})
+
+	// BlobCount in storage, refreshed periodically
+	BlobCount = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "storage_blobs",
+		Help:      "Number of files in storage.",
+	})
+
+	// BlobBytes on disk, refreshed periodically
+	BlobBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "storage_blob_bytes",
+		Help:      "Number of bytes of the files in storage.",
+	})
+
+	// WebhookDeliveries attempted by result (delivered, retried or failed)
+	WebhookDeliveries = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "webhook_deliveries_total",
+			Help:      "Number of webhook delivery attempts.",
+		},
+		[]string{"result"},
+	)
+
+	// ProxyReloads after label changes by result (reloaded, rolled_back or
+	// failed)
+	ProxyReloads = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "proxy_reloads_total",
+			Help:      "Number of proxy reloads after label changes.",
+		},
+		[]string{"result"},
+	)
+
+	// ProxyReloadDuration of the configuration test and reload of the proxy
+	ProxyReloadDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "proxy_reload_duration_seconds",
+		Help:      "Duration of the configuration test and reload of the proxy.",
+		Buckets:   prometheus.DefBuckets,
+	})
+)
+
+func init() {
+	prometheus.MustRegister(
+		RequestsTotal,
+		RequestDuration,
+		GRPCRequestsTotal,
+		GRPCRequestDuration,
+		BytesStored,
+		BytesServed,
+		FilesStored,
+		DuplicateFilesStored,
+		DedupHits,
+		DistroMaterializationDuration,
+		LabelUpdates,
+		LockWaitDuration,
+		LockTimeouts,
+		BlobCount,
+		BlobBytes,
+		WebhookDeliveries,
+		ProxyReloads,
+		ProxyReloadDuration,
+	)
+}
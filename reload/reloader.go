@@ -0,0 +1,505 @@
+package reload
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/vtex/hyper-cas/metrics"
+	"github.com/vtex/hyper-cas/storage"
+	"github.com/vtex/hyper-cas/utils"
+	"go.uber.org/zap"
+)
+
+// Results of applying label changes to the proxy
+const (
+	ResultReloaded   = "reloaded"
+	ResultRolledBack = "rolled_back"
+	ResultFailed     = "failed"
+)
+
+// Reloader reloads the proxy serving the sites after label changes. Changes
+// are coalesced until no label changes for `reload.debounceMs`, or for at
+// most `reload.maxDelayMs`, so a burst of updates causes a single reload.
+//
+// The site configuration of every label is kept in a snapshot of what the
+// proxy last loaded successfully. When the configuration test fails, the
+// changed labels are reverted to the distributions, annotations and domains
+// the proxy loaded, their files are restored from it and the proxy is not
+// reloaded. A label.reverted event is emitted for each reverted label.
+// Failed reloads are retried after `reload.retryMs`.
+type Reloader struct {
+	storage      storage.Storage
+	command      string
+	pidFile      string
+	url          string
+	testCommand  string
+	snapshotPath string
+	client       *http.Client
+	timeout      time.Duration
+	debounce     time.Duration
+	maxDelay     time.Duration
+	retry        time.Duration
+
+	lock        sync.Mutex
+	pending     map[string]struct{}
+	firstChange time.Time
+	lastChange  time.Time
+	retryAt     time.Time
+
+	applyLock sync.Mutex
+	wake      chan struct{}
+	stop      chan struct{}
+	done      chan struct{}
+
+	// Labels as the proxy loaded them, guarded by applyLock
+	loaded map[string]*storage.LabelInfo
+
+	listenersLock sync.RWMutex
+	listeners     []storage.Listener
+}
+
+// NewReloader with the hook in the configuration
+func NewReloader(st storage.Storage) (*Reloader, error) {
+	viper.SetDefault("reload.debounceMs", 1000)
+	viper.SetDefault("reload.maxDelayMs", 10000)
+	viper.SetDefault("reload.timeoutMs", 30000)
+	viper.SetDefault("reload.retryMs", 10000)
+	viper.SetDefault("reload.snapshotPath", path.Join(viper.GetString("storage.rootPath"), "reload"))
+
+	timeout := time.Duration(viper.GetInt("reload.timeoutMs")) * time.Millisecond
+	r := &Reloader{
+		storage:      st,
+		command:      viper.GetString("reload.command"),
+		pidFile:      viper.GetString("reload.pidFile"),
+		url:          viper.GetString("reload.url"),
+		testCommand:  viper.GetString("reload.testCommand"),
+		snapshotPath: viper.GetString("reload.snapshotPath"),
+		client:       &http.Client{Timeout: timeout},
+		timeout:      timeout,
+		debounce:     time.Duration(viper.GetInt("reload.debounceMs")) * time.Millisecond,
+		maxDelay:     time.Duration(viper.GetInt("reload.maxDelayMs")) * time.Millisecond,
+		retry:        time.Duration(viper.GetInt("reload.retryMs")) * time.Millisecond,
+		pending:      map[string]struct{}{},
+		loaded:       map[string]*storage.LabelInfo{},
+		wake:         make(chan struct{}, 1),
+	}
+
+	hooks := 0
+	for _, hook := range []string{r.command, r.pidFile, r.url} {
+		if hook != "" {
+			hooks++
+		}
+	}
+	if hooks > 1 {
+		return nil, fmt.Errorf("only one of reload.command, reload.pidFile and reload.url can be set")
+	}
+	if hooks == 0 && r.testCommand != "" {
+		return nil, fmt.Errorf("reload.testCommand requires reload.command, reload.pidFile or reload.url")
+	}
+	if r.Enabled() {
+		err := os.MkdirAll(r.snapshotPath, os.ModePerm)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// Enabled returns whether a reload hook is configured
+func (r *Reloader) Enabled() bool {
+	return r.command != "" || r.pidFile != "" || r.url != ""
+}
+
+// Subscribe listener to the label.reverted events of rollbacks
+func (r *Reloader) Subscribe(listener storage.Listener) {
+	r.listenersLock.Lock()
+	defer r.listenersLock.Unlock()
+	r.listeners = append(r.listeners, listener)
+}
+
+func (r *Reloader) emit(event storage.Event) {
+	event.Time = time.Now().UTC()
+	r.listenersLock.RLock()
+	defer r.listenersLock.RUnlock()
+	for _, listener := range r.listeners {
+		listener(event)
+	}
+}
+
+// HandleEvent queues a reload for label changes. Changes reverting labels
+// are skipped, since their configuration is restored to the one the proxy
+// loaded.
+func (r *Reloader) HandleEvent(event storage.Event) {
+	if !r.Enabled() || event.Reverted || (event.Type != storage.EventLabelUpdated && event.Type != storage.EventLabelDeleted && event.Type != storage.EventLabelDomainsUpdated) {
+		return
+	}
+	r.lock.Lock()
+	now := time.Now()
+	if len(r.pending) == 0 {
+		r.firstChange = now
+	}
+	r.lastChange = now
+	r.pending[event.Label] = struct{}{}
+	r.lock.Unlock()
+	r.notify()
+}
+
+func (r *Reloader) notify() {
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Start snapshotting the current site configuration, which is assumed to be
+// loaded by the proxy, and applying label changes
+func (r *Reloader) Start() error {
+	if !r.Enabled() {
+		return nil
+	}
+	err := r.snapshotAll()
+	if err != nil {
+		return err
+	}
+	r.stop = make(chan struct{})
+	r.done = make(chan struct{})
+	go func() {
+		defer close(r.done)
+		for {
+			select {
+			case <-r.stop:
+				return
+			case <-r.wake:
+			}
+			if !r.wait() {
+				return
+			}
+			r.Flush()
+		}
+	}()
+	return nil
+}
+
+// Stop applying label changes in the background, applying the pending ones
+// right away
+func (r *Reloader) Stop() {
+	if r.stop == nil {
+		return
+	}
+	close(r.stop)
+	<-r.done
+	r.stop = nil
+	r.Flush()
+}
+
+// wait until the pending changes are due, returning false if stopped
+func (r *Reloader) wait() bool {
+	for {
+		r.lock.Lock()
+		if len(r.pending) == 0 {
+			r.lock.Unlock()
+			return true
+		}
+		delay := r.debounce - time.Since(r.lastChange)
+		if maxDelay := r.maxDelay - time.Since(r.firstChange); maxDelay < delay {
+			delay = maxDelay
+		}
+		if retry := time.Until(r.retryAt); retry > delay {
+			delay = retry
+		}
+		r.lock.Unlock()
+		if delay <= 0 {
+			return true
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-r.stop:
+			timer.Stop()
+			return false
+		case <-timer.C:
+		}
+	}
+}
+
+// Flush applies the pending label changes right away, returning the result
+// or an empty string when there were no changes
+func (r *Reloader) Flush() string {
+	r.applyLock.Lock()
+	defer r.applyLock.Unlock()
+
+	r.lock.Lock()
+	labels := []string{}
+	for label := range r.pending {
+		labels = append(labels, label)
+	}
+	r.pending = map[string]struct{}{}
+	r.lock.Unlock()
+	if len(labels) == 0 {
+		return ""
+	}
+	sort.Strings(labels)
+	return r.apply(labels)
+}
+
+func (r *Reloader) apply(labels []string) string {
+	start := time.Now()
+	logger := utils.LoggerWith(zap.Strings("labels", labels))
+	confPaths := r.confPaths(labels)
+
+	err := r.test()
+	if err != nil {
+		logger.Error("Site configuration test failed. Rolling back label changes...", zap.Error(err))
+		r.revert(labels, logger)
+		r.rollback(confPaths, logger)
+		if err := r.test(); err != nil {
+			logger.Error("Site configuration test still fails after rolling back.", zap.Error(err))
+		}
+		metrics.ProxyReloads.WithLabelValues(ResultRolledBack).Inc()
+		return ResultRolledBack
+	}
+
+	err = r.reload()
+	if err != nil {
+		logger.Error("Failed to reload proxy.", zap.Error(err))
+		metrics.ProxyReloads.WithLabelValues(ResultFailed).Inc()
+		// Applied again later, so their snapshots are taken once the proxy
+		// loads them
+		r.lock.Lock()
+		now := time.Now()
+		if len(r.pending) == 0 {
+			r.firstChange = now
+		}
+		r.lastChange = now
+		r.retryAt = now.Add(r.retry)
+		for _, label := range labels {
+			r.pending[label] = struct{}{}
+		}
+		r.lock.Unlock()
+		r.notify()
+		return ResultFailed
+	}
+	for _, confPath := range confPaths {
+		if err := r.snapshot(confPath); err != nil {
+			logger.Error("Failed to snapshot site configuration.", zap.String("path", confPath), zap.Error(err))
+		}
+	}
+	for _, label := range labels {
+		if info, err := r.storage.GetLabelInfo(label); err == nil {
+			r.loaded[label] = info
+		} else {
+			delete(r.loaded, label)
+		}
+	}
+	metrics.ProxyReloadDuration.Observe(time.Since(start).Seconds())
+	metrics.ProxyReloads.WithLabelValues(ResultReloaded).Inc()
+	logger.Info("Proxy reloaded.", zap.Duration("duration", time.Since(start)))
+	return ResultReloaded
+}
+
+// confPaths of the labels, without repetitions
+func (r *Reloader) confPaths(labels []string) []string {
+	seen := map[string]struct{}{}
+	confPaths := []string{}
+	for _, label := range labels {
+		confPath := r.storage.SiteConfPath(label)
+		if _, ok := seen[confPath]; ok {
+			continue
+		}
+		seen[confPath] = struct{}{}
+		confPaths = append(confPaths, confPath)
+	}
+	return confPaths
+}
+
+func (r *Reloader) test() error {
+	if r.testCommand == "" {
+		return nil
+	}
+	return r.run(r.testCommand)
+}
+
+func (r *Reloader) reload() error {
+	switch {
+	case r.command != "":
+		return r.run(r.command)
+	case r.pidFile != "":
+		return r.signal()
+	default:
+		return r.post()
+	}
+}
+
+func (r *Reloader) run(command string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+	output, err := exec.CommandContext(ctx, "sh", "-c", command).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %w: %s", command, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+func (r *Reloader) signal() error {
+	dat, err := ioutil.ReadFile(r.pidFile)
+	if err != nil {
+		return err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(dat)))
+	if err != nil {
+		return fmt.Errorf("invalid pid in %s: %w", r.pidFile, err)
+	}
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	return process.Signal(syscall.SIGHUP)
+}
+
+func (r *Reloader) post() error {
+	res, err := r.client.Post(r.url, "application/json", nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("%s answered with status code %d", r.url, res.StatusCode)
+	}
+	return nil
+}
+
+func (r *Reloader) snapshotFile(confPath string) string {
+	return path.Join(r.snapshotPath, url.PathEscape(confPath))
+}
+
+// snapshot the site configuration in confPath, which the proxy loaded
+func (r *Reloader) snapshot(confPath string) error {
+	dat, err := ioutil.ReadFile(confPath)
+	if os.IsNotExist(err) {
+		err = os.Remove(r.snapshotFile(confPath))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return utils.WriteFileAtomically(r.snapshotFile(confPath), dat)
+}
+
+func (r *Reloader) snapshotAll() error {
+	labels := []string{}
+	options := storage.ListOptions{Limit: 1000}
+	for {
+		list, err := r.storage.ListLabels(options)
+		if err != nil {
+			return err
+		}
+		for _, label := range list.Labels {
+			labels = append(labels, label.Name)
+			r.loaded[label.Name] = label
+		}
+		if list.NextCursor == "" {
+			break
+		}
+		options.Cursor = list.NextCursor
+	}
+	for _, confPath := range r.confPaths(labels) {
+		err := r.snapshot(confPath)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// revert the labels to the distributions, annotations and domains the proxy
+// loaded, deleting the ones it never loaded
+func (r *Reloader) revert(labels []string, logger *zap.Logger) {
+	for _, label := range labels {
+		current, err := r.storage.GetLabelInfo(label)
+		loaded, ok := r.loaded[label]
+		switch {
+		case ok && (err != nil || !sameLabel(current, loaded)):
+			err = r.storage.RevertLabel(label, loaded)
+		case !ok && err == nil:
+			err = r.storage.RevertLabel(label, nil)
+		default:
+			continue
+		}
+		if err != nil {
+			logger.Error("Failed to revert label.", zap.String("label", label), zap.Error(err))
+			continue
+		}
+		event := storage.Event{Type: storage.EventLabelReverted, Label: label}
+		if loaded != nil {
+			event.Hash = loaded.Hash
+		}
+		if current != nil {
+			event.OldHash = current.Hash
+		}
+		logger.Warn("Label reverted.", zap.String("label", label), zap.String("hash", event.Hash), zap.String("revertedHash", event.OldHash))
+		r.emit(event)
+	}
+}
+
+// sameLabel returns whether the labels have the same site configuration
+func sameLabel(a, b *storage.LabelInfo) bool {
+	return a.Hash == b.Hash && reflect.DeepEqual(nonNil(a.Annotations), nonNil(b.Annotations)) && strings.Join(a.Domains, " ") == strings.Join(b.Domains, " ")
+}
+
+func nonNil(annotations map[string]string) map[string]string {
+	if annotations == nil {
+		return map[string]string{}
+	}
+	return annotations
+}
+
+// rollback the site configuration in confPaths to their snapshots, removing
+// the ones the proxy never loaded
+func (r *Reloader) rollback(confPaths []string, logger *zap.Logger) {
+	for _, confPath := range confPaths {
+		err := r.restore(confPath)
+		if err != nil {
+			logger.Error("Failed to roll back site configuration.", zap.String("path", confPath), zap.Error(err))
+			continue
+		}
+		logger.Warn("Site configuration rolled back.", zap.String("path", confPath))
+	}
+}
+
+func (r *Reloader) restore(confPath string) error {
+	unlock, err := utils.Lock(confPath)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	dat, err := ioutil.ReadFile(r.snapshotFile(confPath))
+	if os.IsNotExist(err) {
+		err = os.Remove(confPath)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return utils.WriteFileAtomically(confPath, dat)
+}
@@ -0,0 +1,217 @@
+package reload
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/vtex/hyper-cas/sitebuilder"
+	"github.com/vtex/hyper-cas/storage"
+	"github.com/vtex/hyper-cas/utils"
+)
+
+func newTestStorage(t *testing.T) *storage.FSStorage {
+	dir, err := ioutil.TempDir("", "hyper-cas-reload")
+	assert.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	viper.Set("storage.rootPath", path.Join(dir, "storage"))
+	viper.Set("storage.sitesPath", path.Join(dir, "sites"))
+	viper.Set("reload.snapshotPath", path.Join(dir, "reload"))
+	viper.Set("reload.debounceMs", 60000)
+	for _, key := range []string{"reload.command", "reload.testCommand", "reload.pidFile", "reload.url"} {
+		key := key
+		t.Cleanup(func() { viper.Set(key, "") })
+	}
+
+	builder, err := sitebuilder.NewNginxSiteBuilder()
+	assert.NoError(t, err)
+	st, err := storage.NewFSStorage(builder)
+	assert.NoError(t, err)
+	return st
+}
+
+func newTestReloader(t *testing.T, st *storage.FSStorage) *Reloader {
+	r, err := NewReloader(st)
+	assert.NoError(t, err)
+	st.Subscribe(r.HandleEvent)
+	assert.NoError(t, r.Start())
+	t.Cleanup(r.Stop)
+	return r
+}
+
+// distro stored for text, which labels can point to
+func distro(t *testing.T, st *storage.FSStorage, text string) string {
+	hash := fmt.Sprintf("%x", utils.Hash(text))
+	if !st.HasDistro(hash) {
+		assert.NoError(t, st.StoreDistro(hash, []string{}))
+	}
+	return hash
+}
+
+func countLines(t *testing.T, filePath string) int {
+	dat, err := ioutil.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return 0
+	}
+	assert.NoError(t, err)
+	return strings.Count(string(dat), "\n")
+}
+
+func TestReloaderCoalescesChanges(t *testing.T) {
+	st := newTestStorage(t)
+	reloads := path.Join(viper.GetString("storage.rootPath"), "reloads")
+	viper.Set("reload.command", fmt.Sprintf("echo reloaded >> %s", reloads))
+	viper.Set("reload.debounceMs", 100)
+	newTestReloader(t, st)
+
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, st.StoreLabel(fmt.Sprintf("label-%d", i), distro(t, st, fmt.Sprintf("distro-%d", i))))
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for countLines(t, reloads) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, 1, countLines(t, reloads))
+}
+
+func TestReloaderRollsBackWhenTestFails(t *testing.T) {
+	st := newTestStorage(t)
+	sitesPath := viper.GetString("storage.sitesPath")
+	viper.Set("reload.command", "true")
+	viper.Set("reload.testCommand", fmt.Sprintf("! grep -q broken %s/*.conf", sitesPath))
+	assert.NoError(t, st.StoreLabel("stable", distro(t, st, "first")))
+	r := newTestReloader(t, st)
+	assert.NoError(t, st.StoreLabel("good", distro(t, st, "first")))
+	assert.Equal(t, ResultReloaded, r.Flush())
+	goodConf, err := ioutil.ReadFile(path.Join(sitesPath, "good.conf"))
+	assert.NoError(t, err)
+
+	assert.NoError(t, st.StoreLabel("good", distro(t, st, "second")))
+	assert.NoError(t, st.StoreLabel("broken", distro(t, st, "second")))
+	assert.NoError(t, st.DeleteLabel("stable"))
+
+	assert.Equal(t, ResultRolledBack, r.Flush())
+	dat, err := ioutil.ReadFile(path.Join(sitesPath, "good.conf"))
+	assert.NoError(t, err)
+	assert.Equal(t, string(goodConf), string(dat))
+	assert.False(t, utils.FileExists(path.Join(sitesPath, "broken.conf")))
+	assert.True(t, utils.FileExists(path.Join(sitesPath, "stable.conf")))
+	assert.Equal(t, "", r.Flush())
+
+	good, err := st.GetLabel("good")
+	assert.NoError(t, err)
+	assert.Equal(t, distro(t, st, "first"), good)
+	stable, err := st.GetLabel("stable")
+	assert.NoError(t, err)
+	assert.Equal(t, distro(t, st, "first"), stable)
+	assert.False(t, st.HasLabel("broken"))
+}
+
+func TestReloaderRevertsAnnotationsAndDomains(t *testing.T) {
+	st := newTestStorage(t)
+	broken := path.Join(viper.GetString("storage.rootPath"), "broken")
+	viper.Set("reload.command", "true")
+	viper.Set("reload.testCommand", fmt.Sprintf("test ! -e %s", broken))
+	r := newTestReloader(t, st)
+	events := []storage.Event{}
+	r.Subscribe(func(event storage.Event) { events = append(events, event) })
+	hash := distro(t, st, "first")
+	assert.NoError(t, st.StoreLabelWithAnnotations("annotated", hash, map[string]string{"team": "web"}))
+	_, err := st.SetLabelDomains("annotated", []string{"annotated.example.com"})
+	assert.NoError(t, err)
+	assert.Equal(t, ResultReloaded, r.Flush())
+
+	assert.NoError(t, st.StoreLabelWithAnnotations("annotated", distro(t, st, "second"), map[string]string{"team": "other"}))
+	_, err = st.SetLabelDomains("annotated", []string{"other.example.com"})
+	assert.NoError(t, err)
+	assert.NoError(t, ioutil.WriteFile(broken, []byte{}, 0644))
+	assert.Equal(t, ResultRolledBack, r.Flush())
+	// The changes reverting the label don't need a reload
+	assert.Equal(t, "", r.Flush())
+
+	info, err := st.GetLabelInfo("annotated")
+	assert.NoError(t, err)
+	assert.Equal(t, hash, info.Hash)
+	assert.Equal(t, map[string]string{"team": "web"}, info.Annotations)
+	assert.Equal(t, []string{"annotated.example.com"}, info.Domains)
+	assert.Len(t, events, 1)
+	assert.Equal(t, storage.EventLabelReverted, events[0].Type)
+	assert.Equal(t, "annotated", events[0].Label)
+	assert.Equal(t, hash, events[0].Hash)
+	assert.Equal(t, distro(t, st, "second"), events[0].OldHash)
+}
+
+func TestReloaderCallsURL(t *testing.T) {
+	st := newTestStorage(t)
+	var calls, status int32 = 0, 200
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(int(atomic.LoadInt32(&status)))
+	}))
+	defer server.Close()
+	viper.Set("reload.url", server.URL)
+	r := newTestReloader(t, st)
+
+	assert.NoError(t, st.StoreLabel("called", distro(t, st, "first")))
+	assert.Equal(t, ResultReloaded, r.Flush())
+	atomic.StoreInt32(&status, 500)
+	assert.NoError(t, st.StoreLabel("called", distro(t, st, "second")))
+	assert.Equal(t, ResultFailed, r.Flush())
+
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestReloaderRetriesFailedReloads(t *testing.T) {
+	st := newTestStorage(t)
+	var calls, status int32 = 0, 500
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(int(atomic.LoadInt32(&status)))
+	}))
+	defer server.Close()
+	viper.Set("reload.url", server.URL)
+	viper.Set("reload.debounceMs", 10)
+	viper.Set("reload.retryMs", 100)
+	t.Cleanup(func() { viper.Set("reload.retryMs", nil) })
+	r := newTestReloader(t, st)
+
+	waitForCalls := func(count int32) {
+		deadline := time.Now().Add(5 * time.Second)
+		for atomic.LoadInt32(&calls) < count && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		assert.Equal(t, count, atomic.LoadInt32(&calls))
+	}
+
+	assert.NoError(t, st.StoreLabel("retried", distro(t, st, "first")))
+	waitForCalls(1)
+	atomic.StoreInt32(&status, 200)
+	waitForCalls(2)
+	assert.Equal(t, "", r.Flush())
+}
+
+func TestNewReloaderWithInvalidHooks(t *testing.T) {
+	st := newTestStorage(t)
+
+	viper.Set("reload.command", "true")
+	viper.Set("reload.url", "http://localhost")
+	_, err := NewReloader(st)
+	assert.Error(t, err)
+
+	viper.Set("reload.command", "")
+	viper.Set("reload.url", "")
+	viper.Set("reload.testCommand", "true")
+	_, err = NewReloader(st)
+	assert.Error(t, err)
+}
@@ -0,0 +1,636 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+
+package rpc
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion7
+
+// HyperCasClient is the client API for HyperCas service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type HyperCasClient interface {
+	// PutFile stores the contents sent in a stream of chunks, returning their
+	// hash. If the first message has a hash, the contents must hash to it.
+	PutFile(ctx context.Context, opts ...grpc.CallOption) (HyperCas_PutFileClient, error)
+	// GetFile streams the contents of a file in chunks.
+	GetFile(ctx context.Context, in *GetFileRequest, opts ...grpc.CallOption) (HyperCas_GetFileClient, error)
+	HasFile(ctx context.Context, in *HasFileRequest, opts ...grpc.CallOption) (*HasResponse, error)
+	// MissingFiles returns the hashes that are not stored yet.
+	MissingFiles(ctx context.Context, in *MissingFilesRequest, opts ...grpc.CallOption) (*MissingFilesResponse, error)
+	// PutDistro stores a distribution, returning the hash of its tree.
+	PutDistro(ctx context.Context, in *PutDistroRequest, opts ...grpc.CallOption) (*PutDistroResponse, error)
+	GetDistro(ctx context.Context, in *GetDistroRequest, opts ...grpc.CallOption) (*GetDistroResponse, error)
+	HasDistro(ctx context.Context, in *HasDistroRequest, opts ...grpc.CallOption) (*HasResponse, error)
+	ListDistros(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (*ListDistrosResponse, error)
+	// SetLabel points a label to a distribution.
+	SetLabel(ctx context.Context, in *SetLabelRequest, opts ...grpc.CallOption) (*Label, error)
+	GetLabel(ctx context.Context, in *GetLabelRequest, opts ...grpc.CallOption) (*Label, error)
+	// DeleteLabel and its site configuration. Distributions are kept.
+	DeleteLabel(ctx context.Context, in *DeleteLabelRequest, opts ...grpc.CallOption) (*DeleteLabelResponse, error)
+	ListLabels(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (*ListLabelsResponse, error)
+	// WatchLabels streams the current state of the labels followed by every
+	// change to them.
+	WatchLabels(ctx context.Context, in *WatchLabelsRequest, opts ...grpc.CallOption) (HyperCas_WatchLabelsClient, error)
+}
+
+type hyperCasClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewHyperCasClient(cc grpc.ClientConnInterface) HyperCasClient {
+	return &hyperCasClient{cc}
+}
+
+func (c *hyperCasClient) PutFile(ctx context.Context, opts ...grpc.CallOption) (HyperCas_PutFileClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_HyperCas_serviceDesc.Streams[0], "/hypercas.v1.HyperCas/PutFile", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &hyperCasPutFileClient{stream}
+	return x, nil
+}
+
+type HyperCas_PutFileClient interface {
+	Send(*PutFileRequest) error
+	CloseAndRecv() (*PutFileResponse, error)
+	grpc.ClientStream
+}
+
+type hyperCasPutFileClient struct {
+	grpc.ClientStream
+}
+
+func (x *hyperCasPutFileClient) Send(m *PutFileRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *hyperCasPutFileClient) CloseAndRecv() (*PutFileResponse, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(PutFileResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *hyperCasClient) GetFile(ctx context.Context, in *GetFileRequest, opts ...grpc.CallOption) (HyperCas_GetFileClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_HyperCas_serviceDesc.Streams[1], "/hypercas.v1.HyperCas/GetFile", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &hyperCasGetFileClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type HyperCas_GetFileClient interface {
+	Recv() (*FileChunk, error)
+	grpc.ClientStream
+}
+
+type hyperCasGetFileClient struct {
+	grpc.ClientStream
+}
+
+func (x *hyperCasGetFileClient) Recv() (*FileChunk, error) {
+	m := new(FileChunk)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *hyperCasClient) HasFile(ctx context.Context, in *HasFileRequest, opts ...grpc.CallOption) (*HasResponse, error) {
+	out := new(HasResponse)
+	err := c.cc.Invoke(ctx, "/hypercas.v1.HyperCas/HasFile", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *hyperCasClient) MissingFiles(ctx context.Context, in *MissingFilesRequest, opts ...grpc.CallOption) (*MissingFilesResponse, error) {
+	out := new(MissingFilesResponse)
+	err := c.cc.Invoke(ctx, "/hypercas.v1.HyperCas/MissingFiles", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *hyperCasClient) PutDistro(ctx context.Context, in *PutDistroRequest, opts ...grpc.CallOption) (*PutDistroResponse, error) {
+	out := new(PutDistroResponse)
+	err := c.cc.Invoke(ctx, "/hypercas.v1.HyperCas/PutDistro", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *hyperCasClient) GetDistro(ctx context.Context, in *GetDistroRequest, opts ...grpc.CallOption) (*GetDistroResponse, error) {
+	out := new(GetDistroResponse)
+	err := c.cc.Invoke(ctx, "/hypercas.v1.HyperCas/GetDistro", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *hyperCasClient) HasDistro(ctx context.Context, in *HasDistroRequest, opts ...grpc.CallOption) (*HasResponse, error) {
+	out := new(HasResponse)
+	err := c.cc.Invoke(ctx, "/hypercas.v1.HyperCas/HasDistro", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *hyperCasClient) ListDistros(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (*ListDistrosResponse, error) {
+	out := new(ListDistrosResponse)
+	err := c.cc.Invoke(ctx, "/hypercas.v1.HyperCas/ListDistros", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *hyperCasClient) SetLabel(ctx context.Context, in *SetLabelRequest, opts ...grpc.CallOption) (*Label, error) {
+	out := new(Label)
+	err := c.cc.Invoke(ctx, "/hypercas.v1.HyperCas/SetLabel", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *hyperCasClient) GetLabel(ctx context.Context, in *GetLabelRequest, opts ...grpc.CallOption) (*Label, error) {
+	out := new(Label)
+	err := c.cc.Invoke(ctx, "/hypercas.v1.HyperCas/GetLabel", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *hyperCasClient) DeleteLabel(ctx context.Context, in *DeleteLabelRequest, opts ...grpc.CallOption) (*DeleteLabelResponse, error) {
+	out := new(DeleteLabelResponse)
+	err := c.cc.Invoke(ctx, "/hypercas.v1.HyperCas/DeleteLabel", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *hyperCasClient) ListLabels(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (*ListLabelsResponse, error) {
+	out := new(ListLabelsResponse)
+	err := c.cc.Invoke(ctx, "/hypercas.v1.HyperCas/ListLabels", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *hyperCasClient) WatchLabels(ctx context.Context, in *WatchLabelsRequest, opts ...grpc.CallOption) (HyperCas_WatchLabelsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_HyperCas_serviceDesc.Streams[2], "/hypercas.v1.HyperCas/WatchLabels", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &hyperCasWatchLabelsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type HyperCas_WatchLabelsClient interface {
+	Recv() (*LabelEvent, error)
+	grpc.ClientStream
+}
+
+type hyperCasWatchLabelsClient struct {
+	grpc.ClientStream
+}
+
+func (x *hyperCasWatchLabelsClient) Recv() (*LabelEvent, error) {
+	m := new(LabelEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// HyperCasServer is the server API for HyperCas service.
+// All implementations must embed UnimplementedHyperCasServer
+// for forward compatibility
+type HyperCasServer interface {
+	// PutFile stores the contents sent in a stream of chunks, returning their
+	// hash. If the first message has a hash, the contents must hash to it.
+	PutFile(HyperCas_PutFileServer) error
+	// GetFile streams the contents of a file in chunks.
+	GetFile(*GetFileRequest, HyperCas_GetFileServer) error
+	HasFile(context.Context, *HasFileRequest) (*HasResponse, error)
+	// MissingFiles returns the hashes that are not stored yet.
+	MissingFiles(context.Context, *MissingFilesRequest) (*MissingFilesResponse, error)
+	// PutDistro stores a distribution, returning the hash of its tree.
+	PutDistro(context.Context, *PutDistroRequest) (*PutDistroResponse, error)
+	GetDistro(context.Context, *GetDistroRequest) (*GetDistroResponse, error)
+	HasDistro(context.Context, *HasDistroRequest) (*HasResponse, error)
+	ListDistros(context.Context, *ListRequest) (*ListDistrosResponse, error)
+	// SetLabel points a label to a distribution.
+	SetLabel(context.Context, *SetLabelRequest) (*Label, error)
+	GetLabel(context.Context, *GetLabelRequest) (*Label, error)
+	// DeleteLabel and its site configuration. Distributions are kept.
+	DeleteLabel(context.Context, *DeleteLabelRequest) (*DeleteLabelResponse, error)
+	ListLabels(context.Context, *ListRequest) (*ListLabelsResponse, error)
+	// WatchLabels streams the current state of the labels followed by every
+	// change to them.
+	WatchLabels(*WatchLabelsRequest, HyperCas_WatchLabelsServer) error
+	mustEmbedUnimplementedHyperCasServer()
+}
+
+// UnimplementedHyperCasServer must be embedded to have forward compatible implementations.
+type UnimplementedHyperCasServer struct {
+}
+
+func (UnimplementedHyperCasServer) PutFile(HyperCas_PutFileServer) error {
+	return status.Errorf(codes.Unimplemented, "method PutFile not implemented")
+}
+func (UnimplementedHyperCasServer) GetFile(*GetFileRequest, HyperCas_GetFileServer) error {
+	return status.Errorf(codes.Unimplemented, "method GetFile not implemented")
+}
+func (UnimplementedHyperCasServer) HasFile(context.Context, *HasFileRequest) (*HasResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method HasFile not implemented")
+}
+func (UnimplementedHyperCasServer) MissingFiles(context.Context, *MissingFilesRequest) (*MissingFilesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method MissingFiles not implemented")
+}
+func (UnimplementedHyperCasServer) PutDistro(context.Context, *PutDistroRequest) (*PutDistroResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PutDistro not implemented")
+}
+func (UnimplementedHyperCasServer) GetDistro(context.Context, *GetDistroRequest) (*GetDistroResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetDistro not implemented")
+}
+func (UnimplementedHyperCasServer) HasDistro(context.Context, *HasDistroRequest) (*HasResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method HasDistro not implemented")
+}
+func (UnimplementedHyperCasServer) ListDistros(context.Context, *ListRequest) (*ListDistrosResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListDistros not implemented")
+}
+func (UnimplementedHyperCasServer) SetLabel(context.Context, *SetLabelRequest) (*Label, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetLabel not implemented")
+}
+func (UnimplementedHyperCasServer) GetLabel(context.Context, *GetLabelRequest) (*Label, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetLabel not implemented")
+}
+func (UnimplementedHyperCasServer) DeleteLabel(context.Context, *DeleteLabelRequest) (*DeleteLabelResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteLabel not implemented")
+}
+func (UnimplementedHyperCasServer) ListLabels(context.Context, *ListRequest) (*ListLabelsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListLabels not implemented")
+}
+func (UnimplementedHyperCasServer) WatchLabels(*WatchLabelsRequest, HyperCas_WatchLabelsServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchLabels not implemented")
+}
+func (UnimplementedHyperCasServer) mustEmbedUnimplementedHyperCasServer() {}
+
+// UnsafeHyperCasServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to HyperCasServer will
+// result in compilation errors.
+type UnsafeHyperCasServer interface {
+	mustEmbedUnimplementedHyperCasServer()
+}
+
+func RegisterHyperCasServer(s grpc.ServiceRegistrar, srv HyperCasServer) {
+	s.RegisterService(&_HyperCas_serviceDesc, srv)
+}
+
+func _HyperCas_PutFile_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(HyperCasServer).PutFile(&hyperCasPutFileServer{stream})
+}
+
+type HyperCas_PutFileServer interface {
+	SendAndClose(*PutFileResponse) error
+	Recv() (*PutFileRequest, error)
+	grpc.ServerStream
+}
+
+type hyperCasPutFileServer struct {
+	grpc.ServerStream
+}
+
+func (x *hyperCasPutFileServer) SendAndClose(m *PutFileResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *hyperCasPutFileServer) Recv() (*PutFileRequest, error) {
+	m := new(PutFileRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _HyperCas_GetFile_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(GetFileRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(HyperCasServer).GetFile(m, &hyperCasGetFileServer{stream})
+}
+
+type HyperCas_GetFileServer interface {
+	Send(*FileChunk) error
+	grpc.ServerStream
+}
+
+type hyperCasGetFileServer struct {
+	grpc.ServerStream
+}
+
+func (x *hyperCasGetFileServer) Send(m *FileChunk) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _HyperCas_HasFile_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(HasFileRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HyperCasServer).HasFile(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/hypercas.v1.HyperCas/HasFile",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HyperCasServer).HasFile(ctx, req.(*HasFileRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _HyperCas_MissingFiles_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MissingFilesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HyperCasServer).MissingFiles(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/hypercas.v1.HyperCas/MissingFiles",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HyperCasServer).MissingFiles(ctx, req.(*MissingFilesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _HyperCas_PutDistro_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PutDistroRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HyperCasServer).PutDistro(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/hypercas.v1.HyperCas/PutDistro",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HyperCasServer).PutDistro(ctx, req.(*PutDistroRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _HyperCas_GetDistro_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetDistroRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HyperCasServer).GetDistro(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/hypercas.v1.HyperCas/GetDistro",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HyperCasServer).GetDistro(ctx, req.(*GetDistroRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _HyperCas_HasDistro_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(HasDistroRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HyperCasServer).HasDistro(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/hypercas.v1.HyperCas/HasDistro",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HyperCasServer).HasDistro(ctx, req.(*HasDistroRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _HyperCas_ListDistros_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HyperCasServer).ListDistros(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/hypercas.v1.HyperCas/ListDistros",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HyperCasServer).ListDistros(ctx, req.(*ListRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _HyperCas_SetLabel_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetLabelRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HyperCasServer).SetLabel(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/hypercas.v1.HyperCas/SetLabel",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HyperCasServer).SetLabel(ctx, req.(*SetLabelRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _HyperCas_GetLabel_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetLabelRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HyperCasServer).GetLabel(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/hypercas.v1.HyperCas/GetLabel",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HyperCasServer).GetLabel(ctx, req.(*GetLabelRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _HyperCas_DeleteLabel_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteLabelRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HyperCasServer).DeleteLabel(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/hypercas.v1.HyperCas/DeleteLabel",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HyperCasServer).DeleteLabel(ctx, req.(*DeleteLabelRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _HyperCas_ListLabels_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HyperCasServer).ListLabels(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/hypercas.v1.HyperCas/ListLabels",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HyperCasServer).ListLabels(ctx, req.(*ListRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _HyperCas_WatchLabels_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchLabelsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(HyperCasServer).WatchLabels(m, &hyperCasWatchLabelsServer{stream})
+}
+
+type HyperCas_WatchLabelsServer interface {
+	Send(*LabelEvent) error
+	grpc.ServerStream
+}
+
+type hyperCasWatchLabelsServer struct {
+	grpc.ServerStream
+}
+
+func (x *hyperCasWatchLabelsServer) Send(m *LabelEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+var _HyperCas_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "hypercas.v1.HyperCas",
+	HandlerType: (*HyperCasServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "HasFile",
+			Handler:    _HyperCas_HasFile_Handler,
+		},
+		{
+			MethodName: "MissingFiles",
+			Handler:    _HyperCas_MissingFiles_Handler,
+		},
+		{
+			MethodName: "PutDistro",
+			Handler:    _HyperCas_PutDistro_Handler,
+		},
+		{
+			MethodName: "GetDistro",
+			Handler:    _HyperCas_GetDistro_Handler,
+		},
+		{
+			MethodName: "HasDistro",
+			Handler:    _HyperCas_HasDistro_Handler,
+		},
+		{
+			MethodName: "ListDistros",
+			Handler:    _HyperCas_ListDistros_Handler,
+		},
+		{
+			MethodName: "SetLabel",
+			Handler:    _HyperCas_SetLabel_Handler,
+		},
+		{
+			MethodName: "GetLabel",
+			Handler:    _HyperCas_GetLabel_Handler,
+		},
+		{
+			MethodName: "DeleteLabel",
+			Handler:    _HyperCas_DeleteLabel_Handler,
+		},
+		{
+			MethodName: "ListLabels",
+			Handler:    _HyperCas_ListLabels_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "PutFile",
+			Handler:       _HyperCas_PutFile_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "GetFile",
+			Handler:       _HyperCas_GetFile_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "WatchLabels",
+			Handler:       _HyperCas_WatchLabels_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "rpc/hypercas.proto",
+}
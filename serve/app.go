@@ -1,24 +1,44 @@
 package serve
 
 import (
+	"bytes"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"net"
 	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	router "github.com/fasthttp/router"
 	"github.com/spf13/viper"
 	"github.com/valyala/fasthttp"
 	"github.com/valyala/fasthttp/pprofhandler"
+	"github.com/vtex/hyper-cas/reload"
 	"github.com/vtex/hyper-cas/sitebuilder"
 	"github.com/vtex/hyper-cas/storage"
 	"github.com/vtex/hyper-cas/utils"
+	"github.com/vtex/hyper-cas/webhooks"
 	"go.uber.org/zap"
+	"google.golang.org/grpc"
 )
 
 type App struct {
 	Port        int
+	GRPCPort    int
 	Storage     storage.Storage
 	SiteBuilder sitebuilder.SiteBuilder
 	profile     bool
+	auth        *Authenticator
+	webhooks    *webhooks.Dispatcher
+	reloader    *reload.Reloader
+	watches     *watchHub
+	draining    int32
+
+	metricsStop chan struct{}
+	metricsDone chan struct{}
 }
 
 func getStorage(storageType storage.StorageType, siteBuilder sitebuilder.SiteBuilder) (storage.Storage, error) {
@@ -31,13 +51,42 @@ func getStorage(storageType storage.StorageType, siteBuilder sitebuilder.SiteBui
 }
 
 func getSiteBuilder() (sitebuilder.SiteBuilder, error) {
-	siteBuilder, err := sitebuilder.NewNginxSiteBuilder()
-	return siteBuilder, err
+	viper.SetDefault("storage.siteBuilder", sitebuilder.Nginx)
+	builderType := viper.GetString("storage.siteBuilder")
+	switch builderType {
+	case sitebuilder.Nginx:
+		viper.SetDefault("nginx.mode", sitebuilder.NginxModeServer)
+		switch mode := viper.GetString("nginx.mode"); mode {
+		case sitebuilder.NginxModeServer:
+			return sitebuilder.NewNginxSiteBuilder()
+		case sitebuilder.NginxModeMap:
+			return sitebuilder.NewNginxMapSiteBuilder()
+		default:
+			return nil, fmt.Errorf("Unknown nginx.mode %q (expected %q or %q)", mode, sitebuilder.NginxModeServer, sitebuilder.NginxModeMap)
+		}
+	case sitebuilder.Caddy:
+		return sitebuilder.NewCaddySiteBuilder()
+	case sitebuilder.Traefik:
+		return sitebuilder.NewTraefikSiteBuilder()
+	case sitebuilder.Envoy:
+		return sitebuilder.NewEnvoySiteBuilder()
+	}
+
+	return nil, fmt.Errorf(
+		"No site builder could be found for type %q (expected one of %q)",
+		builderType,
+		[]string{sitebuilder.Nginx, sitebuilder.Caddy, sitebuilder.Traefik, sitebuilder.Envoy},
+	)
 }
 
 func NewApp(port int, storageType storage.StorageType) (*App, error) {
 	viper.SetDefault("serve.maxRequestBodySize", 4*1024*1024*1024)
+	viper.SetDefault("serve.maxArchiveSize", 256*1024*1024)
+	viper.SetDefault("serve.maxArchiveFilesSize", 1024*1024*1024)
 	viper.SetDefault("serve.TCPKeepaliveEnabled", true)
+	viper.SetDefault("serve.watchTimeoutMs", 30000)
+	viper.SetDefault("serve.watchPollIntervalMs", 5000)
+	viper.SetDefault("serve.watchKeepaliveMs", 15000)
 
 	siteBuilder, err := getSiteBuilder()
 	if err != nil {
@@ -51,43 +100,116 @@ func NewApp(port int, storageType storage.StorageType) (*App, error) {
 		return nil, err
 	}
 
-	return &App{Port: port, Storage: storage, SiteBuilder: siteBuilder, profile: false}, nil
+	auth, err := NewAuthenticator()
+	if err != nil {
+		utils.LogError("Could not load API tokens.", zap.Error(err))
+		return nil, err
+	}
+
+	dispatcher, err := webhooks.NewDispatcher()
+	if err != nil {
+		utils.LogError("Could not load webhooks.", zap.Error(err))
+		return nil, err
+	}
+	storage.Subscribe(dispatcher.HandleEvent)
+	reloader, err := reload.NewReloader(storage)
+	if err != nil {
+		utils.LogError("Could not load reload hook.", zap.Error(err))
+		return nil, err
+	}
+	storage.Subscribe(reloader.HandleEvent)
+	reloader.Subscribe(dispatcher.HandleEvent)
+	watches := newWatchHub(storage.LabelRevisions, watchDuration("serve.watchPollIntervalMs"))
+	storage.Subscribe(watches.handleEvent)
+
+	return &App{
+		Port:        port,
+		Storage:     storage,
+		SiteBuilder: siteBuilder,
+		profile:     false,
+		auth:        auth,
+		webhooks:    dispatcher,
+		reloader:    reloader,
+		watches:     watches,
+	}, nil
 }
 
 func (app *App) EnableProfileRoutes(enabled bool) {
 	app.profile = enabled
 }
 
+// EnableGRPC serves the gRPC API in port, besides the REST API
+func (app *App) EnableGRPC(port int) {
+	app.GRPCPort = port
+}
+
+// StartDraining flags the app as shutting down, failing readiness checks
+func (app *App) StartDraining() {
+	atomic.StoreInt32(&app.draining, 1)
+}
+
+// IsDraining returns whether the app is shutting down
+func (app *App) IsDraining() bool {
+	return atomic.LoadInt32(&app.draining) == 1
+}
+
+// HandleError answers requests that fail in handler with a JSON error body
+// and the status code matching the kind of error
 func (app *App) HandleError(handler func(ctx *fasthttp.RequestCtx) error) func(ctx *fasthttp.RequestCtx) {
 	return func(ctx *fasthttp.RequestCtx) {
 		err := handler(ctx)
 		if err != nil {
-			ctx.SetBodyString(fmt.Sprintf("Error: %v\n", err))
-			ctx.SetStatusCode(500)
+			status, code := errorStatus(err)
+			if status >= 500 {
+				Logger(ctx).Error("Request failed.", zap.Int("status", status), zap.Error(err))
+			} else {
+				Logger(ctx).Info("Request rejected.", zap.Int("status", status), zap.Error(err))
+			}
+			writeError(ctx, status, code, errorMessage(err, status))
 		}
 	}
 }
 
 func (app *App) GetRouter() *router.Router {
 	router := router.New()
+	router.SaveMatchedRoutePath = true
 	healthcheckHandler := NewHealthcheckHandler(app)
 	fileHandler := NewFileHandler(app)
 	distroHandler := NewDistroHandler(app)
 	labelHandler := NewLabelHandler(app)
+	webhookHandler := NewWebhookHandler(app)
 
 	router.GET("/healthcheck", app.HandleError(healthcheckHandler.handleGet))
+	router.GET("/readiness", app.HandleError(healthcheckHandler.handleReadiness))
+	router.GET("/metrics", metricsHandler())
 
-	router.PUT("/file", app.HandleError(fileHandler.handlePut))
+	router.PUT("/file", app.HandleError(app.Authorize(ScopeFileWrite, fileHandler.handlePut)))
+	router.PUT("/file/{hash}", app.HandleError(app.Authorize(ScopeFileWrite, fileHandler.handlePutWithHash)))
 	router.GET("/file/{hash}", app.HandleError(fileHandler.handleGet))
 	router.HEAD("/file/{hash}", app.HandleError(fileHandler.handleHead))
+	router.POST("/files/missing", app.HandleError(fileHandler.handleMissing))
+	router.PUT("/files/archive", app.HandleError(app.Authorize(ScopeFileWrite, fileHandler.handlePutArchive)))
 
-	router.PUT("/distro", app.HandleError(distroHandler.handlePut))
+	router.PUT("/distro", app.HandleError(app.Authorize(ScopeDistroWrite, distroHandler.handlePut)))
 	router.GET("/distro/{distro}", app.HandleError(distroHandler.handleGet))
 	router.HEAD("/distro/{distro}", app.HandleError(distroHandler.handleHead))
+	router.GET("/distro/{distro}/files/{path:*}", app.HandleError(distroHandler.handleGetFile))
+	router.HEAD("/distro/{distro}/files/{path:*}", app.HandleError(distroHandler.handleHeadFile))
+	router.GET("/distro/{distro}/ls", app.HandleError(distroHandler.handleListDir))
+	router.GET("/distro/{distro}/ls/{dir:*}", app.HandleError(distroHandler.handleListDir))
+	router.GET("/distros", app.HandleError(distroHandler.handleList))
 
-	router.PUT("/label", app.HandleError(labelHandler.handlePut))
+	router.PUT("/label", app.HandleError(app.Authorize(ScopeLabelWrite, labelHandler.handlePut)))
 	router.GET("/label/{label}", app.HandleError(labelHandler.handleGet))
 	router.HEAD("/label/{label}", app.HandleError(labelHandler.handleHead))
+	router.DELETE("/label/{label}", app.HandleError(app.Authorize(ScopeLabelWrite, labelHandler.handleDelete)))
+	router.GET("/label/{label}/watch", app.HandleError(labelHandler.handleWatch))
+	router.GET("/label/{label}/domains", app.HandleError(labelHandler.handleGetDomains))
+	router.PUT("/label/{label}/domains", app.HandleError(app.Authorize(ScopeLabelWrite, labelHandler.handlePutDomains)))
+	router.GET("/labels", app.HandleError(labelHandler.handleList))
+	router.GET("/labels/watch", app.HandleError(labelHandler.handleWatchAll))
+
+	router.GET("/webhooks/deliveries", app.HandleError(app.Authorize(ScopeAdmin, webhookHandler.handleList)))
 
 	if app.profile {
 		utils.LogDebug("Profiling routes enabled.")
@@ -98,26 +220,163 @@ func (app *App) GetRouter() *router.Router {
 	return router
 }
 
-func (app *App) ListenAndServe() {
-	router := app.GetRouter()
-	logger := utils.LoggerWith(
-		zap.String("ip", "0.0.0.0"),
-		zap.Int("port", app.Port),
-	)
-	logger.Info("hyper-cas API running successfully.")
-	s := &fasthttp.Server{
-		Handler: router.Handler,
-		Name:    "hyper-cas",
+// Handler for all the API routes
+func (app *App) Handler() fasthttp.RequestHandler {
+	return app.WithRequestID(app.Instrument(app.GetRouter().Handler))
+}
+
+func (app *App) newServer() *fasthttp.Server {
+	return &fasthttp.Server{
+		Handler:        app.Handler(),
+		HeaderReceived: app.requestConfig,
+		ErrorHandler:   app.handleReadError,
+		Name:           "hyper-cas",
 
 		MaxRequestBodySize: viper.GetInt("serve.maxRequestBodySize"),
 		DisableKeepalive:   false,
 		TCPKeepalive:       viper.GetBool("serve.TCPKeepaliveEnabled"),
+		IdleTimeout:        time.Duration(viper.GetInt("serve.idleTimeoutMs")) * time.Millisecond,
+	}
+}
+
+// requestConfig limits the body of archive uploads to serve.maxArchiveSize
+// before it is read, since archives are kept in memory while their files
+// are stored
+func (app *App) requestConfig(header *fasthttp.RequestHeader) fasthttp.RequestConfig {
+	uri := header.RequestURI()
+	if i := bytes.IndexByte(uri, '?'); i >= 0 {
+		uri = uri[:i]
 	}
-	err := s.ListenAndServe(
-		fmt.Sprintf(":%d", app.Port),
+	maxSize := viper.GetInt("serve.maxArchiveSize")
+	if header.IsPut() && string(uri) == "/files/archive" && maxSize < viper.GetInt("serve.maxRequestBodySize") {
+		return fasthttp.RequestConfig{MaxRequestBodySize: maxSize}
+	}
+	return fasthttp.RequestConfig{}
+}
+
+// handleReadError answers requests that could not be read, such as the ones
+// with a body larger than allowed
+func (app *App) handleReadError(ctx *fasthttp.RequestCtx, err error) {
+	app.WithRequestID(func(ctx *fasthttp.RequestCtx) {
+		Logger(ctx).Info("Request could not be read.", zap.Error(err))
+		if errors.Is(err, fasthttp.ErrBodyTooLarge) {
+			writeError(ctx, 413, CodePayloadTooLarge, "The request body is too large.")
+			return
+		}
+		writeError(ctx, 400, CodeInvalidInput, "The request could not be read.")
+	})(ctx)
+}
+
+func (app *App) ListenAndServe() {
+	viper.SetDefault("serve.idleTimeoutMs", 10000)
+	viper.SetDefault("serve.drainDelayMs", 0)
+	viper.SetDefault("serve.shutdownTimeoutMs", 120000)
+
+	app.StartStorageMetrics()
+	app.webhooks.Start()
+	err := app.reloader.Start()
+	if err != nil {
+		utils.LogError("Could not start reload hook.", zap.Error(err))
+		os.Exit(1)
+	}
+	logger := utils.LoggerWith(
+		zap.String("ip", "0.0.0.0"),
+		zap.Int("port", app.Port),
 	)
+	s := app.newServer()
+	ln, err := net.Listen("tcp4", fmt.Sprintf(":%d", app.Port))
 	if err != nil {
 		logger.Error("Running hyper-cas API failed.", zap.Error(err))
 		os.Exit(1)
 	}
+	var tlsConfig *tls.Config
+	if tlsEnabled() {
+		reloader, err := newCertReloader()
+		if err != nil {
+			logger.Error("Loading TLS certificates failed.", zap.Error(err))
+			os.Exit(1)
+		}
+		tlsConfig = reloader.TLSConfig()
+		ln = tls.NewListener(ln, tlsConfig)
+		logger = logger.With(zap.Bool("tls", true))
+	}
+	logger.Info("hyper-cas API running successfully.")
+
+	errs := make(chan error, 2)
+	go func() {
+		errs <- s.Serve(ln)
+	}()
+
+	var grpcServer *grpc.Server
+	if app.GRPCPort > 0 {
+		grpcLn, err := net.Listen("tcp4", fmt.Sprintf(":%d", app.GRPCPort))
+		if err != nil {
+			logger.Error("Running hyper-cas gRPC API failed.", zap.Int("grpcPort", app.GRPCPort), zap.Error(err))
+			os.Exit(1)
+		}
+		grpcServer = app.NewGRPC(tlsConfig)
+		go func() {
+			errs <- grpcServer.Serve(grpcLn)
+		}()
+		logger.Info("hyper-cas gRPC API running successfully.", zap.Int("grpcPort", app.GRPCPort))
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	select {
+	case err = <-errs:
+		// One of the servers stopped, so the other one and the background
+		// work are stopped as well
+		if err != nil {
+			logger.Error("Running hyper-cas API failed.", zap.Error(err))
+		}
+		app.shutdown(s, grpcServer, "server stopped")
+		if err != nil {
+			os.Exit(1)
+		}
+	case sig := <-signals:
+		app.shutdown(s, grpcServer, sig.String())
+	}
+}
+
+// shutdown fails readiness checks, stops accepting connections and waits for
+// active requests to finish for up to `serve.shutdownTimeoutMs`
+func (app *App) shutdown(s *fasthttp.Server, grpcServer *grpc.Server, reason string) {
+	drainDelay := time.Duration(viper.GetInt("serve.drainDelayMs")) * time.Millisecond
+	timeout := time.Duration(viper.GetInt("serve.shutdownTimeoutMs")) * time.Millisecond
+	logger := utils.LoggerWith(
+		zap.String("reason", reason),
+		zap.Duration("drainDelay", drainDelay),
+		zap.Duration("timeout", timeout),
+	)
+
+	app.StartDraining()
+	logger.Info("Draining hyper-cas API.")
+	time.Sleep(drainDelay)
+	app.watches.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		if grpcServer != nil {
+			grpcServer.GracefulStop()
+		}
+		done <- s.Shutdown()
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			logger.Error("Failed to shut down hyper-cas API gracefully.", zap.Error(err))
+		}
+	case <-time.After(timeout):
+		logger.Warn("Timed out waiting for active requests to finish.")
+		if grpcServer != nil {
+			grpcServer.Stop()
+		}
+	}
+
+	app.StopStorageMetrics()
+	app.webhooks.Stop()
+	app.reloader.Stop()
+	released := utils.ReleaseLocks()
+	logger.Info("hyper-cas API stopped.", zap.Int("releasedLocks", released))
 }
@@ -0,0 +1,191 @@
+package serve
+
+import (
+	"crypto/subtle"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/valyala/fasthttp"
+	"github.com/vtex/hyper-cas/utils"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v2"
+)
+
+// Scopes that can be granted to an API token.
+const (
+	ScopeFileWrite   = "file:write"
+	ScopeDistroWrite = "distro:write"
+	ScopeLabelWrite  = "label:write"
+	ScopeAdmin       = "admin"
+)
+
+const tokenUserValue = "hyper-cas-token"
+
+// Token grants its bearer the specified scopes. Label scopes can be limited
+// to a label glob with the `label:write:<glob>` syntax.
+type Token struct {
+	Name   string   `mapstructure:"name" yaml:"name"`
+	Token  string   `mapstructure:"token" yaml:"token"`
+	Scopes []string `mapstructure:"scopes" yaml:"scopes"`
+}
+
+// HasScope returns whether the token was granted the scope for any resource
+func (t *Token) HasScope(scope string) bool {
+	for _, granted := range t.Scopes {
+		if granted == ScopeAdmin || granted == scope || strings.HasPrefix(granted, scope+":") {
+			return true
+		}
+	}
+	return false
+}
+
+// Allows returns whether the token was granted the scope for the given resource
+func (t *Token) Allows(scope, resource string) bool {
+	for _, granted := range t.Scopes {
+		if granted == ScopeAdmin || granted == scope {
+			return true
+		}
+		if !strings.HasPrefix(granted, scope+":") {
+			continue
+		}
+		glob := strings.TrimPrefix(granted, scope+":")
+		if matched, err := path.Match(glob, resource); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticator validates bearer tokens defined in config (`auth.tokens`)
+// or in the tokens file kept in storage (`auth.tokensFile`).
+type Authenticator struct {
+	enabled      bool
+	configTokens []Token
+	tokensFile   string
+
+	lock          sync.Mutex
+	fileTokens    []Token
+	fileUpdatedAt time.Time
+}
+
+// NewAuthenticator with the tokens in the configuration
+func NewAuthenticator() (*Authenticator, error) {
+	viper.SetDefault("auth.enabled", false)
+	viper.SetDefault("auth.tokensFile", path.Join(viper.GetString("storage.rootPath"), "tokens.yaml"))
+
+	var tokens []Token
+	err := viper.UnmarshalKey("auth.tokens", &tokens)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Authenticator{
+		enabled:      viper.GetBool("auth.enabled"),
+		configTokens: tokens,
+		tokensFile:   viper.GetString("auth.tokensFile"),
+	}, nil
+}
+
+// Enabled returns whether requests must be authenticated
+func (a *Authenticator) Enabled() bool {
+	return a.enabled
+}
+
+func (a *Authenticator) loadFileTokens() []Token {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	info, err := os.Stat(a.tokensFile)
+	if err != nil {
+		// Tokens removed from storage must stop working right away, and a
+		// file put back later must be read again whatever its mtime
+		if !os.IsNotExist(err) {
+			utils.LogError("Failed to stat tokens file.", zap.String("tokensFile", a.tokensFile), zap.Error(err))
+		}
+		a.fileTokens = nil
+		a.fileUpdatedAt = time.Time{}
+		return nil
+	}
+	if !info.ModTime().After(a.fileUpdatedAt) {
+		return a.fileTokens
+	}
+
+	dat, err := ioutil.ReadFile(a.tokensFile)
+	if err != nil {
+		utils.LogError("Failed to read tokens file.", zap.String("tokensFile", a.tokensFile), zap.Error(err))
+		return a.fileTokens
+	}
+	var tokens []Token
+	err = yaml.Unmarshal(dat, &tokens)
+	if err != nil {
+		utils.LogError("Failed to parse tokens file.", zap.String("tokensFile", a.tokensFile), zap.Error(err))
+		return a.fileTokens
+	}
+	a.fileTokens = tokens
+	a.fileUpdatedAt = info.ModTime()
+	return a.fileTokens
+}
+
+// Authenticate the token sent in the Authorization header of the request
+func (a *Authenticator) Authenticate(ctx *fasthttp.RequestCtx) *Token {
+	return a.AuthenticateHeader(string(ctx.Request.Header.Peek("Authorization")))
+}
+
+// AuthenticateHeader returns the token in an Authorization header value
+// (`Bearer <token>`), or nil if it is not valid
+func (a *Authenticator) AuthenticateHeader(header string) *Token {
+	if !strings.HasPrefix(header, "Bearer ") {
+		return nil
+	}
+	value := []byte(strings.TrimSpace(strings.TrimPrefix(header, "Bearer ")))
+	if len(value) == 0 {
+		return nil
+	}
+
+	tokens := append([]Token{}, a.configTokens...)
+	tokens = append(tokens, a.loadFileTokens()...)
+	for i := range tokens {
+		if subtle.ConstantTimeCompare([]byte(tokens[i].Token), value) == 1 {
+			return &tokens[i]
+		}
+	}
+	return nil
+}
+
+// Authorize requests to handler to tokens that have the specified scope
+func (app *App) Authorize(scope string, handler func(ctx *fasthttp.RequestCtx) error) func(ctx *fasthttp.RequestCtx) error {
+	return func(ctx *fasthttp.RequestCtx) error {
+		if !app.auth.Enabled() {
+			return handler(ctx)
+		}
+		token := app.auth.Authenticate(ctx)
+		if token == nil {
+			Logger(ctx).Info("Request without a valid token rejected.", zap.ByteString("path", ctx.Path()))
+			ctx.Response.Header.Set("WWW-Authenticate", `Bearer realm="hyper-cas"`)
+			return newHTTPError(401, CodeUnauthorized, "A valid token is required.")
+		}
+		if !token.HasScope(scope) {
+			Logger(ctx).Info("Token does not have the required scope.", zap.String("token", token.Name), zap.String("scope", scope))
+			return newHTTPError(403, CodeForbidden, "The token does not have the %s scope.", scope)
+		}
+		ctx.SetUserValue(tokenUserValue, token)
+		return handler(ctx)
+	}
+}
+
+// IsAllowed returns whether the token in the request was granted scope for resource
+func (app *App) IsAllowed(ctx *fasthttp.RequestCtx, scope, resource string) bool {
+	if !app.auth.Enabled() {
+		return true
+	}
+	token, ok := ctx.UserValue(tokenUserValue).(*Token)
+	if !ok {
+		return false
+	}
+	return token.Allows(scope, resource)
+}
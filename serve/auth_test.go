@@ -0,0 +1,171 @@
+package serve
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/vtex/hyper-cas/storage"
+	"github.com/vtex/hyper-cas/utils"
+)
+
+func newAuthApp(t *testing.T) *App {
+	viper.Set("auth.enabled", true)
+	viper.Set("auth.tokens", []map[string]interface{}{
+		{"name": "ci", "token": "ci-token", "scopes": []string{ScopeFileWrite, ScopeDistroWrite, "label:write:preview-*"}},
+		{"name": "ops", "token": "ops-token", "scopes": []string{ScopeAdmin}},
+	})
+	defer func() {
+		viper.Set("auth.enabled", false)
+		viper.Set("auth.tokens", nil)
+	}()
+	app, err := NewApp(200, storage.FileSystem)
+	assert.NoError(t, err)
+	return app
+}
+
+func bearer(token string) map[string]string {
+	return map[string]string{"Authorization": fmt.Sprintf("Bearer %s", token)}
+}
+
+func labelForm(label string) string {
+	form := url.Values{}
+	form.Add("label", label)
+	form.Add("hash", fmt.Sprintf("%x", utils.Hash("qwe")))
+	return form.Encode()
+}
+
+func TestAuthRejectsMissingToken(t *testing.T) {
+	app := newAuthApp(t)
+
+	res, status, _, err := utils.DoRequest(app, "PUT", "/file", "some content")
+
+	assert.NoError(t, err)
+	assert.Equal(t, 401, status)
+	assert.Equal(t, `Bearer realm="hyper-cas"`, res.Header.Get("WWW-Authenticate"))
+}
+
+func TestAuthRejectsInvalidToken(t *testing.T) {
+	app := newAuthApp(t)
+
+	_, status, _, err := utils.DoRequestWithHeaders(app, "PUT", "/file", "some content", bearer("invalid"))
+
+	assert.NoError(t, err)
+	assert.Equal(t, 401, status)
+}
+
+func TestAuthAllowsScopedToken(t *testing.T) {
+	app := newAuthApp(t)
+
+	_, status, body, err := utils.DoRequestWithHeaders(app, "PUT", "/file", "some content", bearer("ci-token"))
+
+	assert.NoError(t, err)
+	assert.Equal(t, 200, status)
+	assert.Equal(t, fmt.Sprintf("%x", utils.Hash("some content")), body)
+}
+
+func TestAuthLabelGlob(t *testing.T) {
+	app := newAuthApp(t)
+	storeDistro(t, app, fmt.Sprintf("%x", utils.Hash("qwe")))
+
+	_, status, _, err := utils.DoRequestWithHeaders(app, "PUT", "/label", labelForm("preview-123"), bearer("ci-token"))
+	assert.NoError(t, err)
+	assert.Equal(t, 200, status)
+
+	_, status, _, err = utils.DoRequestWithHeaders(app, "PUT", "/label", labelForm("master"), bearer("ci-token"))
+	assert.NoError(t, err)
+	assert.Equal(t, 403, status)
+
+	_, status, _, err = utils.DoRequestWithHeaders(app, "PUT", "/label", labelForm("master"), bearer("ops-token"))
+	assert.NoError(t, err)
+	assert.Equal(t, 200, status)
+}
+
+func TestAuthLabelGlobRejectsInvalidLabels(t *testing.T) {
+	app := newAuthApp(t)
+	storeDistro(t, app, fmt.Sprintf("%x", utils.Hash("qwe")))
+
+	// The glob matches any character, so labels must be checked before they
+	// reach the site configuration
+	for _, label := range []string{
+		"preview-x; } server { server_name prod.example.com; root html; } server { server_name x",
+		"preview-x\nserver_name prod.example.com;",
+		"preview-\"quoted\"",
+		"preview-UPPER",
+	} {
+		_, status, body, err := utils.DoRequestWithHeaders(app, "PUT", "/label", labelForm(label), bearer("ci-token"))
+		assert.NoError(t, err)
+		assert.Equal(t, 400, status, label)
+		assertErrorBody(t, body, CodeInvalidInput)
+		assert.False(t, app.Storage.HasLabel(label))
+	}
+
+	form := url.Values{}
+	form.Add("label", "preview-hash")
+	for _, hash := range []string{"../../etc", fmt.Sprintf("%x; root /", utils.Hash("qwe")), fmt.Sprintf("%x", utils.Hash("not stored"))} {
+		form.Set("hash", hash)
+		_, status, body, err := utils.DoRequestWithHeaders(app, "PUT", "/label", form.Encode(), bearer("ci-token"))
+		assert.NoError(t, err)
+		assert.Equal(t, 400, status, hash)
+		assertErrorBody(t, body, CodeInvalidInput)
+	}
+	assert.False(t, app.Storage.HasLabel("preview-hash"))
+}
+
+func TestAuthKeepsReadRoutesOpen(t *testing.T) {
+	app := newAuthApp(t)
+
+	_, status, body, err := utils.DoRequest(app, "GET", "/healthcheck", "")
+
+	assert.NoError(t, err)
+	assert.Equal(t, 200, status)
+	assert.Equal(t, "OK", body)
+}
+
+func TestAuthTokensFileRemoved(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hyper-cas-tokens")
+	assert.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	tokensFile := path.Join(dir, "tokens.yaml")
+	tokens := []byte("- name: file\n  token: file-token\n  scopes: [file:write]\n")
+	modTime := time.Now().Add(-time.Hour)
+	writeTokens := func() {
+		assert.NoError(t, ioutil.WriteFile(tokensFile, tokens, 0644))
+		assert.NoError(t, os.Chtimes(tokensFile, modTime, modTime))
+	}
+	writeTokens()
+	viper.Set("auth.tokensFile", tokensFile)
+	t.Cleanup(func() { viper.Set("auth.tokensFile", nil) })
+	app := newAuthApp(t)
+
+	_, status, _, err := utils.DoRequestWithHeaders(app, "PUT", "/file", "some content", bearer("file-token"))
+	assert.NoError(t, err)
+	assert.Equal(t, 200, status)
+
+	assert.NoError(t, os.Remove(tokensFile))
+	_, status, _, err = utils.DoRequestWithHeaders(app, "PUT", "/file", "some content", bearer("file-token"))
+	assert.NoError(t, err)
+	assert.Equal(t, 401, status)
+
+	// The file is read again even with the same mtime
+	writeTokens()
+	_, status, _, err = utils.DoRequestWithHeaders(app, "PUT", "/file", "some content", bearer("file-token"))
+	assert.NoError(t, err)
+	assert.Equal(t, 200, status)
+}
+
+func TestTokenAllows(t *testing.T) {
+	token := &Token{Scopes: []string{ScopeFileWrite, "label:write:preview-*"}}
+
+	assert.True(t, token.HasScope(ScopeFileWrite))
+	assert.True(t, token.HasScope(ScopeLabelWrite))
+	assert.False(t, token.HasScope(ScopeDistroWrite))
+	assert.True(t, token.Allows(ScopeLabelWrite, "preview-1"))
+	assert.False(t, token.Allows(ScopeLabelWrite, "master"))
+}
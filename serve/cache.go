@@ -0,0 +1,57 @@
+package serve
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Files and distributions are addressed by the hash of their contents, so
+// they never change and can be cached forever
+const immutableCacheControl = "public, max-age=31536000, immutable"
+
+// Contents looked up through a label change whenever the label is set, so
+// they can be cached but have to be revalidated with their entity tag
+const revalidateCacheControl = "no-cache"
+
+func etag(hash string) string {
+	return fmt.Sprintf(`"%s"`, hash)
+}
+
+// setImmutableHeaders for a response with contents addressed by hash
+func setImmutableHeaders(ctx *fasthttp.RequestCtx, hash string) {
+	setCacheHeaders(ctx, hash, immutableCacheControl)
+}
+
+func setCacheHeaders(ctx *fasthttp.RequestCtx, hash, cacheControl string) {
+	ctx.Response.Header.Set("ETag", etag(hash))
+	ctx.Response.Header.Set("Cache-Control", cacheControl)
+}
+
+// isNotModified returns whether the If-None-Match header of the request
+// matches the entity tag of the contents addressed by hash
+func isNotModified(ctx *fasthttp.RequestCtx, hash string) bool {
+	header := string(ctx.Request.Header.Peek("If-None-Match"))
+	if header == "" {
+		return false
+	}
+	tag := etag(hash)
+	for _, value := range strings.Split(header, ",") {
+		value = strings.TrimPrefix(strings.TrimSpace(value), "W/")
+		if value == "*" || value == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// isRangeApplicable returns whether the Range header of the request should be
+// honored, considering the If-Range header for the contents addressed by hash
+func isRangeApplicable(ctx *fasthttp.RequestCtx, hash string) bool {
+	if len(ctx.Request.Header.Peek("Range")) == 0 {
+		return false
+	}
+	ifRange := string(ctx.Request.Header.Peek("If-Range"))
+	return ifRange == "" || ifRange == etag(hash)
+}
@@ -4,11 +4,16 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"mime"
+	"path"
 	"strings"
 
 	"github.com/valyala/fasthttp"
+	"github.com/vtex/hyper-cas/api"
 	"github.com/vtex/hyper-cas/content"
+	"github.com/vtex/hyper-cas/storage"
 	"github.com/vtex/hyper-cas/utils"
 	"go.uber.org/zap"
 )
@@ -30,9 +35,7 @@ func (handler *DistroHandler) handlePut(ctx *fasthttp.RequestCtx) error {
 	for scanner.Scan() {
 		parts := strings.Split(scanner.Text(), ":")
 		if len(parts) != 2 {
-			err := fmt.Errorf("The body should be composed of lines with {filepath}:{content hash} only.")
-			utils.LogError("Failed to parse distribution body.", zap.Error(err))
-			return err
+			return invalidInput("The body should be composed of lines with {filepath}:{content hash} only.")
 		}
 		items = append(items, content.NodeItem{
 			parts[0],
@@ -43,60 +46,165 @@ func (handler *DistroHandler) handlePut(ctx *fasthttp.RequestCtx) error {
 
 	tree, err := content.NewTreeWithHashes(items)
 	if err != nil {
-		utils.LogError("Failed to calculate tree for distribution.", zap.Strings("items", contents))
-		return err
+		Logger(ctx).Info("Failed to calculate tree for distribution.", zap.Strings("items", contents), zap.Error(err))
+		return invalidInput("Failed to calculate tree for distribution: %v", err)
 	}
 	root := tree.Root()
 	hash := fmt.Sprintf("%x", root.Hash)
-	utils.LogDebug("Distribution contents parsed successfully and tree calculated.", zap.String("hash", hash))
+	Logger(ctx).Debug("Distribution contents parsed successfully and tree calculated.", zap.String("hash", hash))
 
 	if handler.App.Storage.HasDistro(hash) {
-		utils.LogInfo("Distribution already exists on storage. Skipping distribution storage...", zap.String("hash", hash))
+		Logger(ctx).Info("Distribution already exists on storage. Skipping distribution storage...", zap.String("hash", hash))
 		ctx.SetStatusCode(200)
 		ctx.SetBodyString(hash)
 		return nil
 	}
 	err = handler.App.Storage.StoreDistro(hash, contents)
 	if err != nil {
-		utils.LogError("Failed to store distribution.", zap.String("hash", hash), zap.Error(err))
+		Logger(ctx).Error("Failed to store distribution.", zap.String("hash", hash), zap.Error(err))
 		return err
 	}
 	ctx.SetBodyString(hash)
-	utils.LogDebug("Distribution stored successfully.", zap.String("hash", hash))
+	Logger(ctx).Debug("Distribution stored successfully.", zap.String("hash", hash))
 
 	return nil
 }
 
-func (handler *DistroHandler) handleGet(ctx *fasthttp.RequestCtx) error {
+func (handler *DistroHandler) serveDistro(ctx *fasthttp.RequestCtx, withBody bool) error {
 	distro := ctx.UserValue("distro").(string)
+	logger := Logger(ctx).With(zap.String("hash", distro))
 	if !handler.App.Storage.HasDistro(distro) {
-		utils.LogInfo("Distribution could not be found in storage.", zap.String("hash", distro))
-		ctx.SetStatusCode(404)
+		return notFound("Distribution %s was not found.", distro)
+	}
+	setImmutableHeaders(ctx, distro)
+	if isNotModified(ctx, distro) {
+		logger.Debug("Distribution not modified.")
+		ctx.SetStatusCode(304)
 		return nil
 	}
 	contents, err := handler.App.Storage.GetDistro(distro)
 	if err != nil {
-		utils.LogError("Distribution could not be retrieved from storage.", zap.String("hash", distro), zap.Error(err))
+		logger.Error("Distribution could not be retrieved from storage.", zap.Error(err))
 		return err
 	}
 	items, err := json.Marshal(contents)
 	if err != nil {
-		utils.LogError("Distribution could not be deserialized from storage.", zap.String("hash", distro), zap.Error(err))
+		logger.Error("Distribution could not be deserialized from storage.", zap.Error(err))
 		return err
 	}
+	ctx.SetContentType("application/json")
+	if !withBody {
+		ctx.Response.Header.SetContentLength(len(items))
+		logger.Debug("Distribution found.")
+		return nil
+	}
 	ctx.SetBody(items)
-	utils.LogDebug("Distribution loaded successfully.", zap.String("hash", distro))
+	logger.Debug("Distribution loaded successfully.")
 	return nil
 }
 
+func (handler *DistroHandler) handleGet(ctx *fasthttp.RequestCtx) error {
+	return handler.serveDistro(ctx, true)
+}
+
 func (handler *DistroHandler) handleHead(ctx *fasthttp.RequestCtx) error {
+	return handler.serveDistro(ctx, false)
+}
+
+func (handler *DistroHandler) handleList(ctx *fasthttp.RequestCtx) error {
+	options, err := parseListOptions(ctx)
+	if err != nil {
+		return err
+	}
+	distros, err := handler.App.Storage.ListDistros(options)
+	if err != nil {
+		Logger(ctx).Error("Failed to list distributions.", zap.Error(err))
+		return err
+	}
+	body, err := json.Marshal(distros)
+	if err != nil {
+		return err
+	}
+	ctx.SetContentType("application/json")
+	ctx.SetBody(body)
+	Logger(ctx).Debug("Distributions listed successfully.", zap.Int("count", len(distros.Distros)))
+	return nil
+}
+
+// resolveDistro returns the hash of the distribution in the route, which is
+// either a distribution hash or a label name, and whether it is immutable
+func (handler *DistroHandler) resolveDistro(ctx *fasthttp.RequestCtx) (string, bool, error) {
 	distro := ctx.UserValue("distro").(string)
-	if handler.App.Storage.HasDistro(distro) {
-		utils.LogDebug("Distribution found.", zap.String("hash", distro))
-		ctx.SetStatusCode(200)
-	} else {
-		utils.LogDebug("Distribution not found.", zap.String("hash", distro))
-		ctx.SetStatusCode(404)
+	if utils.IsHash(distro) && handler.App.Storage.HasDistro(distro) {
+		return distro, true, nil
+	}
+	hash, err := handler.App.Storage.GetLabel(distro)
+	if errors.Is(err, storage.ErrNotFound) {
+		return "", false, notFound("Distribution or label %s was not found.", distro)
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return hash, false, nil
+}
+
+func (handler *DistroHandler) serveDistroFile(ctx *fasthttp.RequestCtx, withBody bool) error {
+	filePath := ctx.UserValue("path").(string)
+	distro, immutable, err := handler.resolveDistro(ctx)
+	if err != nil {
+		return err
+	}
+	logger := Logger(ctx).With(zap.String("distro", distro), zap.String("path", filePath))
+	hash, err := handler.App.Storage.LookupDistroPath(distro, filePath)
+	if errors.Is(err, storage.ErrNotFound) {
+		logger.Debug("Path not found in distribution.")
+		return notFound("Path %s was not found in distribution %s.", filePath, distro)
+	}
+	if err != nil {
+		logger.Error("Failed to look up path in distribution.", zap.Error(err))
+		return err
+	}
+	ctx.Response.Header.Set("X-Distro", distro)
+	if contentType := mime.TypeByExtension(path.Ext(filePath)); contentType != "" {
+		ctx.SetContentType(contentType)
+	}
+	cacheControl := immutableCacheControl
+	if !immutable {
+		cacheControl = revalidateCacheControl
+	}
+	return handler.App.serveBlob(ctx, logger.With(zap.String("hash", hash)), hash, cacheControl, withBody)
+}
+
+func (handler *DistroHandler) handleGetFile(ctx *fasthttp.RequestCtx) error {
+	return handler.serveDistroFile(ctx, true)
+}
+
+func (handler *DistroHandler) handleHeadFile(ctx *fasthttp.RequestCtx) error {
+	return handler.serveDistroFile(ctx, false)
+}
+
+func (handler *DistroHandler) handleListDir(ctx *fasthttp.RequestCtx) error {
+	dir, _ := ctx.UserValue("dir").(string)
+	distro, _, err := handler.resolveDistro(ctx)
+	if err != nil {
+		return err
+	}
+	logger := Logger(ctx).With(zap.String("distro", distro), zap.String("dir", dir))
+	entries, err := handler.App.Storage.ListDistroDir(distro, dir)
+	if errors.Is(err, storage.ErrNotFound) {
+		logger.Debug("Directory not found in distribution.")
+		return notFound("Directory %s was not found in distribution %s.", dir, distro)
+	}
+	if err != nil {
+		logger.Error("Failed to list directory of distribution.", zap.Error(err))
+		return err
+	}
+	body, err := json.Marshal(&api.DistroDir{Distro: distro, Path: strings.Trim(dir, "/"), Entries: entries})
+	if err != nil {
+		return err
 	}
+	ctx.SetContentType("application/json")
+	ctx.SetBody(body)
+	logger.Debug("Distribution directory listed successfully.", zap.Int("count", len(entries)))
 	return nil
 }
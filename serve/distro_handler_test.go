@@ -5,12 +5,15 @@ import (
 	"fmt"
 	"io/ioutil"
 	"math/rand"
+	"net/url"
 	"path"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
+	"github.com/vtex/hyper-cas/api"
 	"github.com/vtex/hyper-cas/storage"
 	"github.com/vtex/hyper-cas/utils"
 )
@@ -57,8 +60,9 @@ func TestDistroHandlerPutWithWrongBody(t *testing.T) {
 	_, status, body, err := utils.DoRequest(app, "PUT", "/distro", "qwe")
 
 	assert.NoError(t, err)
-	assert.Equal(t, 500, status)
-	assert.Equal(t, "Error: The body should be composed of lines with {filepath}:{content hash} only.\n", body)
+	assert.Equal(t, 400, status)
+	errRes := assertErrorBody(t, body, CodeInvalidInput)
+	assert.Equal(t, "The body should be composed of lines with {filepath}:{content hash} only.", errRes.Message)
 }
 
 func TestDistroHandlerGet(t *testing.T) {
@@ -96,7 +100,7 @@ func TestDistroHandlerGetNotFound(t *testing.T) {
 
 	assert.NoError(t, err)
 	assert.Equal(t, 404, status)
-	assert.Equal(t, "", body)
+	assertErrorBody(t, body, CodeNotFound)
 }
 
 func TestDistroHandlerHead(t *testing.T) {
@@ -128,3 +132,257 @@ func TestDistroHandlerHeadNotFound(t *testing.T) {
 	assert.Equal(t, 404, status)
 	assert.Equal(t, "", body)
 }
+
+func TestDistroHandlerGetNotModified(t *testing.T) {
+	app, err := NewApp(200, storage.FileSystem)
+	assert.Nil(t, err)
+	_, status, hash, err := utils.DoRequest(app, "PUT", "/distro", strings.Join([]string{newHash(), newHash()}, "\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, 200, status)
+
+	res, status, body, err := utils.DoRequest(app, "GET", fmt.Sprintf("/distro/%s", hash), "")
+	assert.NoError(t, err)
+	assert.Equal(t, 200, status)
+	assert.Equal(t, fmt.Sprintf(`"%s"`, hash), res.Header.Get("ETag"))
+	assert.Equal(t, "public, max-age=31536000, immutable", res.Header.Get("Cache-Control"))
+
+	_, status, body, err = utils.DoRequestWithHeaders(app, "GET", fmt.Sprintf("/distro/%s", hash), "", map[string]string{
+		"If-None-Match": fmt.Sprintf(`"%s"`, hash),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 304, status)
+	assert.Equal(t, "", body)
+}
+
+func TestDistroHandlerHeadContentLength(t *testing.T) {
+	app, err := NewApp(200, storage.FileSystem)
+	assert.Nil(t, err)
+	_, status, hash, err := utils.DoRequest(app, "PUT", "/distro", strings.Join([]string{newHash(), newHash()}, "\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, 200, status)
+	_, _, body, err := utils.DoRequest(app, "GET", fmt.Sprintf("/distro/%s", hash), "")
+	assert.NoError(t, err)
+
+	res, status, _, err := utils.DoRequest(app, "HEAD", fmt.Sprintf("/distro/%s", hash), "")
+
+	assert.NoError(t, err)
+	assert.Equal(t, 200, status)
+	assert.Equal(t, int64(len(body)), res.ContentLength)
+}
+
+func TestDistroHandlerList(t *testing.T) {
+	app, err := NewApp(200, storage.FileSystem)
+	assert.Nil(t, err)
+	_, status, fileHash, err := utils.DoRequest(app, "PUT", "/file", "listed distro file")
+	assert.NoError(t, err)
+	assert.Equal(t, 200, status)
+	_, status, hash, err := utils.DoRequest(app, "PUT", "/distro", fmt.Sprintf("index.html:%s\nabout.html:%s", fileHash, fileHash))
+	assert.NoError(t, err)
+	assert.Equal(t, 200, status)
+
+	_, status, body, err := utils.DoRequest(app, "GET", fmt.Sprintf("/distros?prefix=%s", hash[0:12]), "")
+
+	assert.NoError(t, err)
+	assert.Equal(t, 200, status)
+	var list storage.DistroList
+	assert.NoError(t, json.Unmarshal([]byte(body), &list))
+	assert.Len(t, list.Distros, 1)
+	assert.Equal(t, hash, list.Distros[0].Hash)
+	assert.Equal(t, 2, list.Distros[0].FileCount)
+	assert.Equal(t, int64(2*len("listed distro file")), list.Distros[0].TotalBytes)
+	assert.False(t, list.Distros[0].CreatedAt.IsZero())
+}
+
+func putLookupDistro(t *testing.T, app *App) (string, string, string) {
+	page := putText(t, app, "<h1>lookup page</h1>")
+	script := putText(t, app, "console.log('lookup')")
+	_, status, hash, err := utils.DoRequest(app, "PUT", "/distro", strings.Join([]string{
+		fmt.Sprintf("index.html:%s", page),
+		fmt.Sprintf("assets/app.js:%s", script),
+		fmt.Sprintf("assets/img/logo.html:%s", page),
+	}, "\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, 200, status)
+	return hash, page, script
+}
+
+func TestDistroHandlerGetFile(t *testing.T) {
+	app, err := NewApp(200, storage.FileSystem)
+	assert.Nil(t, err)
+	hash, page, script := putLookupDistro(t, app)
+
+	res, status, body, err := utils.DoRequest(app, "GET", fmt.Sprintf("/distro/%s/files/assets/app.js", hash), "")
+
+	assert.NoError(t, err)
+	assert.Equal(t, 200, status)
+	assert.Equal(t, "console.log('lookup')", body)
+	assert.Equal(t, fmt.Sprintf(`"%s"`, script), res.Header.Get("ETag"))
+	assert.Equal(t, immutableCacheControl, res.Header.Get("Cache-Control"))
+	assert.Contains(t, res.Header.Get("Content-Type"), "javascript")
+
+	label := fmt.Sprintf("lookup-%s", strings.ToLower(utils.RandString(8)))
+	assert.NoError(t, app.Storage.StoreLabel(label, hash))
+	res, status, body, err = utils.DoRequest(app, "GET", fmt.Sprintf("/distro/%s/files/index.html", label), "")
+
+	assert.NoError(t, err)
+	assert.Equal(t, 200, status)
+	assert.Equal(t, "<h1>lookup page</h1>", body)
+	assert.Equal(t, fmt.Sprintf(`"%s"`, page), res.Header.Get("ETag"))
+	assert.Equal(t, revalidateCacheControl, res.Header.Get("Cache-Control"))
+	assert.Equal(t, hash, res.Header.Get("X-Distro"))
+
+	for _, route := range []string{
+		fmt.Sprintf("/distro/%s/files/missing.html", hash),
+		fmt.Sprintf("/distro/%s/files/assets", hash),
+		"/distro/missing-label/files/index.html",
+	} {
+		_, status, body, err = utils.DoRequest(app, "GET", route, "")
+		assert.NoError(t, err)
+		assert.Equal(t, 404, status, route)
+		assertErrorBody(t, body, CodeNotFound)
+	}
+}
+
+func TestDistroHandlerListDir(t *testing.T) {
+	app, err := NewApp(200, storage.FileSystem)
+	assert.Nil(t, err)
+	hash, page, script := putLookupDistro(t, app)
+
+	_, status, body, err := utils.DoRequest(app, "GET", fmt.Sprintf("/distro/%s/ls", hash), "")
+
+	assert.NoError(t, err)
+	assert.Equal(t, 200, status)
+	var dir api.DistroDir
+	assert.NoError(t, json.Unmarshal([]byte(body), &dir))
+	assert.Equal(t, hash, dir.Distro)
+	assert.Equal(t, "", dir.Path)
+	assert.Equal(t, []*storage.DistroEntry{
+		{Name: "assets", Type: storage.DistroEntryDir, Size: int64(len("console.log('lookup')") + len("<h1>lookup page</h1>")), FileCount: 2},
+		{Name: "index.html", Type: storage.DistroEntryFile, Hash: page, Size: int64(len("<h1>lookup page</h1>"))},
+	}, dir.Entries)
+
+	_, status, body, err = utils.DoRequest(app, "GET", fmt.Sprintf("/distro/%s/ls/assets/", hash), "")
+
+	assert.NoError(t, err)
+	assert.Equal(t, 200, status)
+	dir = api.DistroDir{}
+	assert.NoError(t, json.Unmarshal([]byte(body), &dir))
+	assert.Equal(t, "assets", dir.Path)
+	assert.Len(t, dir.Entries, 2)
+	assert.Equal(t, "app.js", dir.Entries[0].Name)
+	assert.Equal(t, script, dir.Entries[0].Hash)
+	assert.Equal(t, "img", dir.Entries[1].Name)
+	assert.Equal(t, storage.DistroEntryDir, dir.Entries[1].Type)
+
+	_, status, body, err = utils.DoRequest(app, "GET", fmt.Sprintf("/distro/%s/ls/other", hash), "")
+
+	assert.NoError(t, err)
+	assert.Equal(t, 404, status)
+	assertErrorBody(t, body, CodeNotFound)
+}
+
+func putRulesDistro(t *testing.T, app *App, redirects, headers string) (int, string) {
+	page := putText(t, app, fmt.Sprintf("<h1>%s</h1>", utils.RandString(8)))
+	_, status, body, err := utils.DoRequest(app, "PUT", "/distro", strings.Join([]string{
+		fmt.Sprintf("index.html:%s", page),
+		fmt.Sprintf("_redirects:%s", putText(t, app, redirects)),
+		fmt.Sprintf("_headers:%s", putText(t, app, headers)),
+	}, "\n"))
+	assert.NoError(t, err)
+	return status, body
+}
+
+func TestDistroHandlerPutWithRules(t *testing.T) {
+	app, err := NewApp(200, storage.FileSystem)
+	assert.Nil(t, err)
+	status, hash := putRulesDistro(t, app, `
+# comment
+/old /new
+/blog/:year/:slug /posts/:year/:slug 302
+/app/* /index.html 200
+/docs/* https://docs.hyper-cas.org/:splat 301!
+`, `
+/assets/*
+  Cache-Control: public, max-age=31536000
+/*
+  X-Frame-Options: DENY
+  Content-Security-Policy: default-src 'self'; img-src *
+`)
+	assert.Equal(t, 200, status)
+
+	label := fmt.Sprintf("rules-%s", strings.ToLower(utils.RandString(8)))
+	putLabel(t, app, label, hash, time.Now())
+
+	dat, err := ioutil.ReadFile(path.Join(viper.GetString("storage.sitesPath"), fmt.Sprintf("%s.conf", label)))
+	assert.NoError(t, err)
+	conf := string(dat)
+	assert.Contains(t, conf, `if ($uri ~ "^/old$") {`)
+	assert.Contains(t, conf, `return 301 "/new$is_args$args";`)
+	assert.Contains(t, conf, `return 302 "/posts/${hc_year}/${hc_slug}$is_args$args";`)
+	assert.Contains(t, conf, `rewrite "^/app/(?P<hc_splat>.*)$" "/index.html" last;`)
+	assert.Contains(t, conf, `return 301 "https://docs.hyper-cas.org/${hc_splat}$is_args$args";`)
+	assert.Contains(t, conf, `"~^/(?P<hc_splat>.*)$" "default-src 'self'; img-src *";`)
+	assert.Contains(t, conf, "add_header X-Frame-Options $hypercas_")
+	assert.Contains(t, conf, "try_files $uri $uri/ @rules;")
+	// Forced redirects apply before looking for files
+	assert.Less(t, strings.Index(conf, "docs.hyper-cas.org"), strings.Index(conf, "location @rules"))
+	assert.Greater(t, strings.Index(conf, "/posts/"), strings.Index(conf, "location @rules"))
+}
+
+func TestDistroHandlerPutWithUnsupportedRules(t *testing.T) {
+	app, err := NewApp(200, storage.FileSystem)
+	assert.Nil(t, err)
+	status, hash := putRulesDistro(t, app, "/old /new", "/*\n  X-Frame-Options: DENY")
+	assert.Equal(t, 200, status)
+	viper.Set("storage.siteBuilder", "traefik")
+	viper.Set("traefik.configPath", fmt.Sprintf("traefik-%s.yaml", utils.RandString(8)))
+	t.Cleanup(func() { viper.Set("storage.siteBuilder", "nginx") })
+	app, err = NewApp(200, storage.FileSystem)
+	assert.Nil(t, err)
+
+	// Traefik would serve the files instead of their rules
+	status, body := putRulesDistro(t, app, "/old /new", "")
+	assert.Equal(t, 400, status)
+	errRes := assertErrorBody(t, body, CodeInvalidInput)
+	assert.Contains(t, errRes.Message, "_redirects files are not supported")
+
+	// Distributions stored with another site builder can't be set either
+	form := url.Values{}
+	form.Add("label", fmt.Sprintf("rules-%s", strings.ToLower(utils.RandString(8))))
+	form.Add("hash", hash)
+	_, status, body, err = utils.DoRequest(app, "PUT", "/label", form.Encode())
+	assert.NoError(t, err)
+	assert.Equal(t, 400, status)
+	errRes = assertErrorBody(t, body, CodeInvalidInput)
+	assert.Contains(t, errRes.Message, "not supported by the site builder")
+}
+
+func TestDistroHandlerPutWithInvalidRules(t *testing.T) {
+	app, err := NewApp(200, storage.FileSystem)
+	assert.Nil(t, err)
+
+	for _, test := range []struct {
+		redirects, headers, message string
+	}{
+		{"/old /new 404", "", "_redirects line 1: unsupported status"},
+		{"/a /b\n\nold /new", "", "_redirects line 3: path \"old\" should start with /"},
+		{"/blog/* /posts/:slug", "", "placeholder :slug"},
+		{"/api/* https://api.hyper-cas.org/:splat 200", "", "proxying"},
+		{"", "  X-Frame-Options: DENY", "_headers line 1: headers should come after a path"},
+		{"", "/*\n  X-Bad: \"quoted\"", "_headers line 2"},
+		{"", "/*\n/assets/*\n  X-Frame-Options: DENY", "_headers line 1: path \"/*\" has no headers"},
+	} {
+		status, body := putRulesDistro(t, app, test.redirects, test.headers)
+		assert.Equal(t, 400, status, test.message)
+		errRes := assertErrorBody(t, body, CodeInvalidInput)
+		assert.Contains(t, errRes.Message, test.message)
+	}
+
+	for _, manifest := range []string{`{"cache":`, `{"cache":[{"path":"*.js"}]}`, `{"cache":[{"path":"*.js","cacheControl":"max-age=${x}"}]}`} {
+		_, status, body, err := utils.DoRequest(app, "PUT", "/distro", fmt.Sprintf("hyper-cas.json:%s", putText(t, app, manifest)))
+		assert.NoError(t, err)
+		assert.Equal(t, 400, status, manifest)
+		errRes := assertErrorBody(t, body, CodeInvalidInput)
+		assert.Contains(t, errRes.Message, "hyper-cas.json")
+	}
+}
@@ -0,0 +1,104 @@
+package serve
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/valyala/fasthttp"
+	"github.com/vtex/hyper-cas/storage"
+	"go.uber.org/zap"
+)
+
+// Codes of the errors in API responses
+const (
+	CodeInvalidInput        = "invalid_input"
+	CodeUnauthorized        = "unauthorized"
+	CodeForbidden           = "forbidden"
+	CodeNotFound            = "not_found"
+	CodeConflict            = "conflict"
+	CodeHashMismatch        = "hash_mismatch"
+	CodePayloadTooLarge     = "payload_too_large"
+	CodeRangeNotSatisfiable = "range_not_satisfiable"
+	CodeLocked              = "locked"
+	CodeInternal            = "internal"
+	CodeUnavailable         = "unavailable"
+)
+
+// ErrorResponse is the body of responses to failed requests
+type ErrorResponse struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"requestId"`
+}
+
+// HTTPError is returned by handlers to answer with a specific status code
+type HTTPError struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+func (e *HTTPError) Error() string {
+	return e.Message
+}
+
+func newHTTPError(status int, code, format string, args ...interface{}) error {
+	return &HTTPError{Status: status, Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+func invalidInput(format string, args ...interface{}) error {
+	return newHTTPError(400, CodeInvalidInput, format, args...)
+}
+
+func notFound(format string, args ...interface{}) error {
+	return newHTTPError(404, CodeNotFound, format, args...)
+}
+
+// errorStatus returns the status code and error code used to answer with err
+func errorStatus(err error) (int, string) {
+	var httpErr *HTTPError
+	switch {
+	case errors.As(err, &httpErr):
+		return httpErr.Status, httpErr.Code
+	case errors.Is(err, storage.ErrInvalidInput):
+		return 400, CodeInvalidInput
+	case errors.Is(err, storage.ErrNotFound):
+		return 404, CodeNotFound
+	case errors.Is(err, storage.ErrConflict):
+		return 409, CodeConflict
+	case errors.Is(err, storage.ErrHashMismatch):
+		return 422, CodeHashMismatch
+	case errors.Is(err, storage.ErrLocked):
+		return 423, CodeLocked
+	case errors.Is(err, storage.ErrUnavailable):
+		return 503, CodeUnavailable
+	}
+	return 500, CodeInternal
+}
+
+// errorMessage sent to clients for err. Unexpected errors can have internal
+// details, such as file paths, so they are only logged with the request ID.
+func errorMessage(err error, status int) string {
+	var httpErr *HTTPError
+	if status < 500 || errors.As(err, &httpErr) {
+		return err.Error()
+	}
+	if status == 503 {
+		return "The storage is unavailable. Look for the request ID in the logs for details."
+	}
+	return "An internal error occurred. Look for the request ID in the logs for details."
+}
+
+// writeError answers the request with a JSON error body
+func writeError(ctx *fasthttp.RequestCtx, status int, code, message string) {
+	requestID, _ := ctx.UserValue(requestIDUserValue).(string)
+	body, err := json.Marshal(&ErrorResponse{Code: code, Message: message, RequestID: requestID})
+	if err != nil {
+		Logger(ctx).Error("Failed to serialize error response.", zap.Error(err))
+	}
+	ctx.ResetBody()
+	ctx.SetStatusCode(status)
+	ctx.SetContentType("application/json")
+	ctx.SetBody(append(body, '\n'))
+}
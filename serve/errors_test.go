@@ -0,0 +1,71 @@
+package serve
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vtex/hyper-cas/storage"
+	"github.com/vtex/hyper-cas/utils"
+)
+
+func assertErrorBody(t *testing.T, body, code string) *ErrorResponse {
+	var res ErrorResponse
+	err := json.Unmarshal([]byte(body), &res)
+	assert.NoError(t, err, "Should be a JSON error: %s", body)
+	assert.Equal(t, code, res.Code)
+	assert.NotEmpty(t, res.Message)
+	assert.NotEmpty(t, res.RequestID)
+	return &res
+}
+
+func TestErrorStatus(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+		code   string
+	}{
+		{invalidInput("bad"), 400, CodeInvalidInput},
+		{notFound("missing"), 404, CodeNotFound},
+		{fmt.Errorf("wrapped: %w", storage.ErrInvalidInput), 400, CodeInvalidInput},
+		{&storage.Error{Kind: storage.ErrNotFound, Op: "get label", Key: "x", Err: errors.New("missing")}, 404, CodeNotFound},
+		{&storage.Error{Kind: storage.ErrConflict, Op: "store", Err: errors.New("conflict")}, 409, CodeConflict},
+		{&storage.Error{Kind: storage.ErrHashMismatch, Op: "store file", Err: errors.New("mismatch")}, 422, CodeHashMismatch},
+		{&storage.Error{Kind: storage.ErrLocked, Op: "store", Err: utils.ErrLockTimeout}, 423, CodeLocked},
+		{&storage.Error{Kind: storage.ErrUnavailable, Op: "store", Err: errors.New("no space left")}, 503, CodeUnavailable},
+		{errors.New("boom"), 500, CodeInternal},
+	}
+	for _, test := range tests {
+		status, code := errorStatus(test.err)
+		assert.Equal(t, test.status, status, "Status of %v", test.err)
+		assert.Equal(t, test.code, code, "Code of %v", test.err)
+	}
+}
+
+func TestErrorMessage(t *testing.T) {
+	internal := &storage.Error{Op: "store label", Key: "x", Err: errors.New("symlink /app/files/x /app/sites/x: permission denied")}
+	unavailable := &storage.Error{Kind: storage.ErrUnavailable, Op: "store", Err: errors.New("write /app/files/x: no space left on device")}
+
+	assert.Equal(t, "bad", errorMessage(invalidInput("bad"), 400))
+	assert.NotContains(t, errorMessage(internal, 500), "/app")
+	assert.NotContains(t, errorMessage(unavailable, 503), "/app")
+	assert.Equal(t, "draining", errorMessage(newHTTPError(503, CodeUnavailable, "draining"), 503))
+}
+
+func TestErrorResponseHasRequestID(t *testing.T) {
+	app, err := NewApp(200, storage.FileSystem)
+	assert.Nil(t, err)
+
+	res, status, body, err := utils.DoRequestWithHeaders(app, "PUT", "/label", "label=only-label", map[string]string{
+		RequestIDHeader: "error-request",
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 400, status)
+	assert.Equal(t, "application/json", res.Header.Get("Content-Type"))
+	errRes := assertErrorBody(t, body, CodeInvalidInput)
+	assert.Equal(t, "error-request", errRes.RequestID)
+	assert.Contains(t, errRes.Message, "Both label and hash must be set")
+}
@@ -1,9 +1,20 @@
 package serve
 
 import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"strings"
 
+	"github.com/spf13/viper"
 	"github.com/valyala/fasthttp"
+	"github.com/vtex/hyper-cas/metrics"
+	"github.com/vtex/hyper-cas/storage"
 	"github.com/vtex/hyper-cas/utils"
 	"go.uber.org/zap"
 )
@@ -22,41 +33,235 @@ func (handler *FileHandler) handlePut(ctx *fasthttp.RequestCtx) error {
 	strHash := fmt.Sprintf("%x", hash)
 	err := handler.App.Storage.Store(strHash, value)
 	if err != nil {
-		utils.LogError("Failed to store file.", zap.String("hash", fmt.Sprintf("%x", hash)), zap.Error(err))
+		Logger(ctx).Error("Failed to store file.", zap.String("hash", fmt.Sprintf("%x", hash)), zap.Error(err))
 		return err
 	}
 	ctx.SetBodyString(strHash)
-	utils.LogDebug("Successfully stored file.", zap.String("hash", fmt.Sprintf("%x", hash)))
+	Logger(ctx).Debug("Successfully stored file.", zap.String("hash", fmt.Sprintf("%x", hash)))
 	return nil
 }
 
-func (handler *FileHandler) handleGet(ctx *fasthttp.RequestCtx) error {
+func (handler *FileHandler) handlePutWithHash(ctx *fasthttp.RequestCtx) error {
+	declaredHash := ctx.UserValue("hash").(string)
+	logger := Logger(ctx).With(zap.String("hash", declaredHash))
+	if !utils.IsHash(declaredHash) {
+		return invalidInput("Invalid hash '%s'.", declaredHash)
+	}
+	value := ctx.Request.Body()
+	strHash := fmt.Sprintf("%x", utils.HashBytes(value))
+	if strHash != declaredHash {
+		logger.Warn("File contents do not match the declared hash.", zap.String("contentHash", strHash), zap.Int("size", len(value)))
+		return newHTTPError(422, CodeHashMismatch, "The file contents hash to %s instead of %s.", strHash, declaredHash)
+	}
+	err := handler.App.Storage.Store(strHash, value)
+	if err != nil {
+		logger.Error("Failed to store file.", zap.Error(err))
+		return err
+	}
+	ctx.SetBodyString(strHash)
+	logger.Debug("Successfully stored file.")
+	return nil
+}
+
+type blobReader struct {
+	io.Reader
+	io.Closer
+}
+
+func (handler *FileHandler) serveFile(ctx *fasthttp.RequestCtx, withBody bool) error {
 	hash := ctx.UserValue("hash").(string)
-	logger := utils.LoggerWith(zap.String("hash", hash))
-	contents, err := handler.App.Storage.Get(hash)
-	if contents == nil {
+	logger := Logger(ctx).With(zap.String("hash", hash))
+	if !utils.IsHash(hash) {
+		logger.Debug("Invalid hash for file.")
+		return notFound("File %s was not found.", hash)
+	}
+	// Clients skip uploading the files that HEAD finds, so a damaged file is
+	// reported as missing to have it uploaded again
+	if !withBody && !handler.App.Storage.Has(hash) {
+		logger.Debug("File not found or damaged for specified hash.")
+		return notFound("File %s was not found.", hash)
+	}
+	return handler.App.serveBlob(ctx, logger, hash, immutableCacheControl, withBody)
+}
+
+// serveBlob writes the contents of the file with hash to the response,
+// honoring conditional and range requests
+func (app *App) serveBlob(ctx *fasthttp.RequestCtx, logger *zap.Logger, hash, cacheControl string, withBody bool) error {
+	blob, size, err := app.Storage.Open(hash)
+	if errors.Is(err, storage.ErrNotFound) {
 		logger.Debug("File not found for specified hash.")
-		ctx.SetStatusCode(404)
-		return nil
+		return notFound("File %s was not found.", hash)
 	}
 	if err != nil {
 		logger.Error("Failed to retrieve file.", zap.Error(err))
 		return err
 	}
-	ctx.SetBody(contents)
+	setCacheHeaders(ctx, hash, cacheControl)
+	ctx.Response.Header.Set("Accept-Ranges", "bytes")
+
+	if isNotModified(ctx, hash) {
+		blob.Close()
+		logger.Debug("File not modified.")
+		ctx.SetStatusCode(304)
+		return nil
+	}
+
+	start, end := 0, int(size)-1
+	if isRangeApplicable(ctx, hash) {
+		start, end, err = fasthttp.ParseByteRange(ctx.Request.Header.Peek("Range"), int(size))
+		if err != nil {
+			blob.Close()
+			logger.Debug("Invalid range requested.", zap.ByteString("range", ctx.Request.Header.Peek("Range")))
+			ctx.Response.Header.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+			return newHTTPError(416, CodeRangeNotSatisfiable, "The requested range is not satisfiable.")
+		}
+		ctx.SetStatusCode(206)
+		ctx.Response.Header.SetContentRange(start, end, int(size))
+	}
+	length := end - start + 1
+
+	if !withBody {
+		blob.Close()
+		ctx.Response.Header.SetContentLength(length)
+		logger.Debug("File exists.")
+		metrics.DedupHits.Inc()
+		return nil
+	}
+	if start > 0 {
+		_, err = blob.Seek(int64(start), io.SeekStart)
+		if err != nil {
+			blob.Close()
+			logger.Error("Failed to seek file.", zap.Error(err))
+			return err
+		}
+	}
+	ctx.SetBodyStream(&blobReader{io.LimitReader(blob, int64(length)), blob}, length)
+	metrics.BytesServed.Add(float64(length))
 	logger.Debug("File retrieved successfully.")
 	return nil
 }
 
+func (handler *FileHandler) handleGet(ctx *fasthttp.RequestCtx) error {
+	return handler.serveFile(ctx, true)
+}
+
 func (handler *FileHandler) handleHead(ctx *fasthttp.RequestCtx) error {
-	hash := ctx.UserValue("hash").(string)
-	logger := utils.LoggerWith(zap.String("hash", hash))
-	if has := handler.App.Storage.Has(hash); has {
-		logger.Debug("File exists.")
-		ctx.SetStatusCode(200)
-	} else {
-		logger.Debug("File not found.")
-		ctx.SetStatusCode(404)
+	return handler.serveFile(ctx, false)
+}
+
+func (handler *FileHandler) handleMissing(ctx *fasthttp.RequestCtx) error {
+	scanner := bufio.NewScanner(bytes.NewReader(ctx.Request.Body()))
+
+	hashes := 0
+	missing := []string{}
+	seen := map[string]bool{}
+	for scanner.Scan() {
+		hash := strings.TrimSpace(scanner.Text())
+		if hash == "" || seen[hash] {
+			continue
+		}
+		if !utils.IsHash(hash) {
+			return invalidInput("The body should be composed of lines with a content hash only (invalid hash: '%s').", hash)
+		}
+		seen[hash] = true
+		hashes++
+		if !handler.App.Storage.Exists(hash) {
+			missing = append(missing, hash)
+			continue
+		}
+		metrics.DedupHits.Inc()
+	}
+	if err := scanner.Err(); err != nil {
+		return invalidInput("The body could not be read: %v", err)
+	}
+
+	body, err := json.Marshal(missing)
+	if err != nil {
+		return err
+	}
+	ctx.SetContentType("application/json")
+	ctx.SetBody(body)
+	Logger(ctx).Debug("Missing files calculated.", zap.Int("hashes", hashes), zap.Int("missing", len(missing)))
+	return nil
+}
+
+var errArchiveTooLarge = errors.New("the files in the archive are too large")
+
+// archiveReader fails once more than `left` bytes are read from an archive,
+// so a small gzipped archive can't expand into files filling the disk
+type archiveReader struct {
+	reader io.Reader
+	left   int64
+}
+
+func (r *archiveReader) Read(p []byte) (int, error) {
+	if r.left <= 0 {
+		return 0, errArchiveTooLarge
+	}
+	if int64(len(p)) > r.left {
+		p = p[:r.left]
+	}
+	n, err := r.reader.Read(p)
+	r.left -= int64(n)
+	return n, err
+}
+
+// handlePutArchive stores the files in a tar archive. The server reads
+// request bodies into memory before calling handlers, so archives are
+// limited to serve.maxArchiveSize bytes, which the server checks before
+// reading them (see requestConfig).
+func (handler *FileHandler) handlePutArchive(ctx *fasthttp.RequestCtx) error {
+	maxSize := viper.GetInt("serve.maxArchiveSize")
+	if ctx.Request.Header.ContentLength() > maxSize || len(ctx.Request.Body()) > maxSize {
+		return newHTTPError(413, CodePayloadTooLarge, "Archives can't be larger than %d bytes.", maxSize)
+	}
+	maxFilesSize := viper.GetInt64("serve.maxArchiveFilesSize")
+	tooLarge := newHTTPError(413, CodePayloadTooLarge, "The files in an archive can't be larger than %d bytes.", maxFilesSize)
+	var reader io.Reader = bufio.NewReader(bytes.NewReader(ctx.Request.Body()))
+	magic, _ := reader.(*bufio.Reader).Peek(2)
+	if bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gzipReader, err := gzip.NewReader(reader)
+		if err != nil {
+			return invalidInput("The body should be a tar archive (optionally gzipped): %v", err)
+		}
+		defer gzipReader.Close()
+		reader = gzipReader
+	}
+	reader = &archiveReader{reader: reader, left: maxFilesSize + 1}
+
+	hashes := map[string]string{}
+	archive := tar.NewReader(reader)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if errors.Is(err, errArchiveTooLarge) {
+			return tooLarge
+		}
+		if err != nil {
+			return invalidInput("The body should be a tar archive (optionally gzipped): %v", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		hash, err := handler.App.Storage.StoreStream(archive)
+		if errors.Is(err, errArchiveTooLarge) {
+			return tooLarge
+		}
+		if err != nil {
+			Logger(ctx).Error("Failed to store archive entry.", zap.String("entry", header.Name), zap.Error(err))
+			return err
+		}
+		hashes[header.Name] = hash
+	}
+
+	body, err := json.Marshal(hashes)
+	if err != nil {
+		return err
 	}
+	ctx.SetContentType("application/json")
+	ctx.SetBody(body)
+	Logger(ctx).Debug("Successfully stored archive.", zap.Int("files", len(hashes)))
 	return nil
 }
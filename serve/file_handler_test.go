@@ -1,14 +1,24 @@
 package serve
 
 import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"math/rand"
 	"path"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
 	"github.com/vtex/hyper-cas/storage"
 	"github.com/vtex/hyper-cas/utils"
 )
@@ -57,7 +67,7 @@ func TestFileHandlerGetNotFound(t *testing.T) {
 
 	assert.NoError(t, err)
 	assert.Equal(t, 404, status)
-	assert.Equal(t, "", body)
+	assertErrorBody(t, body, CodeNotFound)
 }
 
 func TestFileHandlerHead(t *testing.T) {
@@ -79,6 +89,22 @@ func TestFileHandlerHead(t *testing.T) {
 	assert.Equal(t, "", body)
 }
 
+func TestFileHandlerHeadDamagedFile(t *testing.T) {
+	app, err := NewApp(200, storage.FileSystem)
+	assert.Nil(t, err)
+	text := "some text to damage " + utils.RandString(8)
+	_, status, hash, err := utils.DoRequest(app, "PUT", "/file", text)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, status)
+	filePath := path.Join(viper.GetString("storage.rootPath"), "files", hash[0:2], hash[2:4], hash)
+	assert.NoError(t, ioutil.WriteFile(filePath, []byte(text[:10]), 0644))
+
+	_, status, _, err = utils.DoRequest(app, "HEAD", fmt.Sprintf("/file/%s", hash), "")
+
+	assert.NoError(t, err)
+	assert.Equal(t, 404, status)
+}
+
 func TestFileHandlerHeadNotFound(t *testing.T) {
 	app, err := NewApp(200, storage.FileSystem)
 	assert.Nil(t, err)
@@ -89,3 +115,259 @@ func TestFileHandlerHeadNotFound(t *testing.T) {
 	assert.Equal(t, 404, status)
 	assert.Equal(t, "", body)
 }
+
+func TestFileHandlerMissing(t *testing.T) {
+	app, err := NewApp(200, storage.FileSystem)
+	assert.Nil(t, err)
+	text := fmt.Sprintf("some random text: %d", rand.Intn(100))
+	_, status, existing, err := utils.DoRequest(app, "PUT", "/file", text)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, status)
+	missing := fmt.Sprintf("%x", utils.Hash("some missing text"))
+
+	_, status, body, err := utils.DoRequest(app, "POST", "/files/missing", fmt.Sprintf("%s\n%s\n%s", existing, missing, missing))
+
+	assert.NoError(t, err)
+	assert.Equal(t, 200, status)
+	var hashes []string
+	err = json.Unmarshal([]byte(body), &hashes)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{missing}, hashes)
+}
+
+func TestFileHandlerMissingWithLongLine(t *testing.T) {
+	app, err := NewApp(200, storage.FileSystem)
+	assert.Nil(t, err)
+
+	_, status, body, err := utils.DoRequest(app, "POST", "/files/missing", strings.Repeat("a", 100*1024))
+
+	assert.NoError(t, err)
+	assert.Equal(t, 400, status)
+	assertErrorBody(t, body, CodeInvalidInput)
+}
+
+func TestFileHandlerMissingWithWrongBody(t *testing.T) {
+	app, err := NewApp(200, storage.FileSystem)
+	assert.Nil(t, err)
+
+	_, status, body, err := utils.DoRequest(app, "POST", "/files/missing", "qwe")
+
+	assert.NoError(t, err)
+	assert.Equal(t, 400, status)
+	assertErrorBody(t, body, CodeInvalidInput)
+}
+
+func newArchive(t *testing.T, files map[string]string, gzipped bool) string {
+	var buf bytes.Buffer
+	var writer io.Writer = &buf
+	var gzipWriter *gzip.Writer
+	if gzipped {
+		gzipWriter = gzip.NewWriter(&buf)
+		writer = gzipWriter
+	}
+	archive := tar.NewWriter(writer)
+	for name, contents := range files {
+		err := archive.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(contents))})
+		assert.NoError(t, err)
+		_, err = archive.Write([]byte(contents))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, archive.Close())
+	if gzipped {
+		assert.NoError(t, gzipWriter.Close())
+	}
+	return buf.String()
+}
+
+func TestFileHandlerPutArchive(t *testing.T) {
+	for _, gzipped := range []bool{false, true} {
+		app, err := NewApp(200, storage.FileSystem)
+		assert.Nil(t, err)
+		files := map[string]string{
+			"a.txt":        fmt.Sprintf("archived text: %d", rand.Intn(100)),
+			"folder/b.txt": fmt.Sprintf("other archived text: %d", rand.Intn(100)),
+		}
+
+		_, status, body, err := utils.DoRequest(app, "PUT", "/files/archive", newArchive(t, files, gzipped))
+
+		assert.NoError(t, err)
+		assert.Equal(t, 200, status)
+		var hashes map[string]string
+		err = json.Unmarshal([]byte(body), &hashes)
+		assert.NoError(t, err)
+		assert.Len(t, hashes, 2)
+		for name, contents := range files {
+			hash := fmt.Sprintf("%x", utils.Hash(contents))
+			assert.Equal(t, hash, hashes[name])
+			_, status, body, err = utils.DoRequest(app, "GET", fmt.Sprintf("/file/%s", hash), "")
+			assert.NoError(t, err)
+			assert.Equal(t, 200, status)
+			assert.Equal(t, contents, body)
+		}
+	}
+}
+
+func TestFileHandlerPutArchiveWithWrongBody(t *testing.T) {
+	app, err := NewApp(200, storage.FileSystem)
+	assert.Nil(t, err)
+
+	_, status, body, err := utils.DoRequest(app, "PUT", "/files/archive", "not a tar archive")
+
+	assert.NoError(t, err)
+	assert.Equal(t, 400, status)
+	assertErrorBody(t, body, CodeInvalidInput)
+}
+
+func TestFileHandlerPutArchiveTooLarge(t *testing.T) {
+	viper.Set("serve.maxArchiveSize", 16)
+	t.Cleanup(func() { viper.Set("serve.maxArchiveSize", nil) })
+	app, err := NewApp(200, storage.FileSystem)
+	assert.Nil(t, err)
+
+	_, status, body, err := utils.DoRequest(app, "PUT", "/files/archive", newArchive(t, map[string]string{"a.txt": "archived text"}, false))
+
+	assert.NoError(t, err)
+	assert.Equal(t, 413, status)
+	assertErrorBody(t, body, CodePayloadTooLarge)
+}
+
+func TestFileHandlerPutArchiveRejectedBeforeReadingBody(t *testing.T) {
+	viper.Set("serve.maxArchiveSize", 16)
+	t.Cleanup(func() { viper.Set("serve.maxArchiveSize", nil) })
+	app, err := NewApp(200, storage.FileSystem)
+	assert.Nil(t, err)
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+	go app.newServer().Serve(ln)
+	conn, err := ln.Dial()
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	// Only the headers are sent, so the server can't wait for the body
+	_, err = fmt.Fprint(conn, "PUT /files/archive HTTP/1.1\r\nHost: hyper-cas\r\nContent-Length: 1073741824\r\n\r\n")
+	assert.NoError(t, err)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var res fasthttp.Response
+	assert.NoError(t, res.Read(bufio.NewReader(conn)))
+
+	assert.Equal(t, 413, res.StatusCode())
+	assertErrorBody(t, string(res.Body()), CodePayloadTooLarge)
+}
+
+func TestFileHandlerPutArchiveTooLargeOnceExpanded(t *testing.T) {
+	viper.Set("serve.maxArchiveFilesSize", 1024)
+	t.Cleanup(func() { viper.Set("serve.maxArchiveFilesSize", nil) })
+	app, err := NewApp(200, storage.FileSystem)
+	assert.Nil(t, err)
+	contents := strings.Repeat(fmt.Sprintf("expanded %d ", rand.Intn(100)), 1024)
+
+	_, status, body, err := utils.DoRequest(app, "PUT", "/files/archive", newArchive(t, map[string]string{"a.txt": contents}, true))
+
+	assert.NoError(t, err)
+	assert.Equal(t, 413, status)
+	assertErrorBody(t, body, CodePayloadTooLarge)
+	assert.False(t, app.Storage.Exists(fmt.Sprintf("%x", utils.Hash(contents))))
+}
+
+func TestFileHandlerPutWithHash(t *testing.T) {
+	app, err := NewApp(200, storage.FileSystem)
+	assert.Nil(t, err)
+	text := fmt.Sprintf("some random text: %d", rand.Intn(100))
+	hash := fmt.Sprintf("%x", utils.Hash(text))
+
+	_, status, body, err := utils.DoRequest(app, "PUT", fmt.Sprintf("/file/%s", hash), text)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 200, status)
+	assert.Equal(t, hash, body)
+	filePath := path.Join(viper.GetString("storage.rootPath"), "files", hash[0:2], hash[2:4], hash)
+	assert.True(t, utils.FileExists(filePath), "Should exist: %s", filePath)
+}
+
+func TestFileHandlerPutWithWrongHash(t *testing.T) {
+	app, err := NewApp(200, storage.FileSystem)
+	assert.Nil(t, err)
+	text := "some truncated tex"
+	hash := fmt.Sprintf("%x", utils.Hash("some truncated text"))
+
+	_, status, body, err := utils.DoRequest(app, "PUT", fmt.Sprintf("/file/%s", hash), text)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 422, status)
+	assertErrorBody(t, body, CodeHashMismatch)
+	for _, h := range []string{hash, fmt.Sprintf("%x", utils.Hash(text))} {
+		filePath := path.Join(viper.GetString("storage.rootPath"), "files", h[0:2], h[2:4], h)
+		assert.False(t, utils.FileExists(filePath), "Should not exist: %s", filePath)
+	}
+}
+
+func putText(t *testing.T, app *App, text string) string {
+	_, status, hash, err := utils.DoRequest(app, "PUT", "/file", text)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, status)
+	return hash
+}
+
+func TestFileHandlerGetCacheHeaders(t *testing.T) {
+	app, err := NewApp(200, storage.FileSystem)
+	assert.Nil(t, err)
+	hash := putText(t, app, "some cacheable text")
+
+	res, status, _, err := utils.DoRequest(app, "GET", fmt.Sprintf("/file/%s", hash), "")
+
+	assert.NoError(t, err)
+	assert.Equal(t, 200, status)
+	assert.Equal(t, fmt.Sprintf(`"%s"`, hash), res.Header.Get("ETag"))
+	assert.Equal(t, "public, max-age=31536000, immutable", res.Header.Get("Cache-Control"))
+	assert.Equal(t, "bytes", res.Header.Get("Accept-Ranges"))
+}
+
+func TestFileHandlerGetNotModified(t *testing.T) {
+	app, err := NewApp(200, storage.FileSystem)
+	assert.Nil(t, err)
+	hash := putText(t, app, "some cacheable text")
+
+	_, status, body, err := utils.DoRequestWithHeaders(app, "GET", fmt.Sprintf("/file/%s", hash), "", map[string]string{
+		"If-None-Match": fmt.Sprintf(`"other", "%s"`, hash),
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 304, status)
+	assert.Equal(t, "", body)
+}
+
+func TestFileHandlerGetRange(t *testing.T) {
+	app, err := NewApp(200, storage.FileSystem)
+	assert.Nil(t, err)
+	hash := putText(t, app, "0123456789")
+
+	res, status, body, err := utils.DoRequestWithHeaders(app, "GET", fmt.Sprintf("/file/%s", hash), "", map[string]string{
+		"Range": "bytes=2-5",
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 206, status)
+	assert.Equal(t, "2345", body)
+	assert.Equal(t, "bytes 2-5/10", res.Header.Get("Content-Range"))
+
+	_, status, _, err = utils.DoRequestWithHeaders(app, "GET", fmt.Sprintf("/file/%s", hash), "", map[string]string{
+		"Range": "bytes=20-30",
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 416, status)
+}
+
+func TestFileHandlerHeadContentLength(t *testing.T) {
+	app, err := NewApp(200, storage.FileSystem)
+	assert.Nil(t, err)
+	hash := putText(t, app, "0123456789")
+
+	res, status, body, err := utils.DoRequest(app, "HEAD", fmt.Sprintf("/file/%s", hash), "")
+
+	assert.NoError(t, err)
+	assert.Equal(t, 200, status)
+	assert.Equal(t, "", body)
+	assert.Equal(t, int64(10), res.ContentLength)
+	assert.Equal(t, fmt.Sprintf(`"%s"`, hash), res.Header.Get("ETag"))
+}
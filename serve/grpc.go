@@ -0,0 +1,513 @@
+package serve
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/vtex/hyper-cas/content"
+	"github.com/vtex/hyper-cas/metrics"
+	"github.com/vtex/hyper-cas/rpc"
+	"github.com/vtex/hyper-cas/storage"
+	"github.com/vtex/hyper-cas/utils"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// grpcChunkSize of the chunks sent by GetFile
+const grpcChunkSize = 64 * 1024
+
+// grpcScopes required by the gRPC methods that write to storage
+var grpcScopes = map[string]string{
+	"/hypercas.v1.HyperCas/PutFile":     ScopeFileWrite,
+	"/hypercas.v1.HyperCas/PutDistro":   ScopeDistroWrite,
+	"/hypercas.v1.HyperCas/SetLabel":    ScopeLabelWrite,
+	"/hypercas.v1.HyperCas/DeleteLabel": ScopeLabelWrite,
+}
+
+type grpcContextKey int
+
+const (
+	grpcLoggerKey grpcContextKey = iota
+	grpcTokenKey
+)
+
+// GRPCServer serves the gRPC API with the storage of the app
+type GRPCServer struct {
+	rpc.UnimplementedHyperCasServer
+	App *App
+}
+
+func NewGRPCServer(app *App) *GRPCServer {
+	return &GRPCServer{App: app}
+}
+
+// NewGRPC returns a gRPC server with the hyper-cas service registered. TLS
+// is only used when tlsConfig is set.
+func (app *App) NewGRPC(tlsConfig *tls.Config) *grpc.Server {
+	viper.SetDefault("serve.grpcMaxMessageBytes", 64*1024*1024)
+	options := []grpc.ServerOption{
+		grpc.MaxRecvMsgSize(viper.GetInt("serve.grpcMaxMessageBytes")),
+		grpc.UnaryInterceptor(app.grpcUnaryInterceptor),
+		grpc.StreamInterceptor(app.grpcStreamInterceptor),
+	}
+	if tlsConfig != nil {
+		options = append(options, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	server := grpc.NewServer(options...)
+	rpc.RegisterHyperCasServer(server, NewGRPCServer(app))
+	return server
+}
+
+// GRPCLogger for the gRPC request being served, with its request ID
+func GRPCLogger(ctx context.Context) *zap.Logger {
+	if logger, ok := ctx.Value(grpcLoggerKey).(*zap.Logger); ok {
+		return logger
+	}
+	return utils.LoggerInstance()
+}
+
+func firstMetadata(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// grpcContext takes the request ID from the x-request-id metadata (or
+// generates one) and authenticates requests to methods that require a scope
+func (app *App) grpcContext(ctx context.Context, method string) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	requestID := firstMetadata(md, strings.ToLower(RequestIDHeader))
+	if !validRequestID.MatchString(requestID) {
+		requestID = utils.NewID()
+	}
+	grpc.SetHeader(ctx, metadata.Pairs(strings.ToLower(RequestIDHeader), requestID))
+	logger := utils.LoggerWith(zap.String("requestId", requestID))
+	ctx = context.WithValue(ctx, grpcLoggerKey, logger)
+
+	scope, ok := grpcScopes[method]
+	if !ok || !app.auth.Enabled() {
+		return ctx, nil
+	}
+	token := app.auth.AuthenticateHeader(firstMetadata(md, "authorization"))
+	if token == nil {
+		logger.Info("Request without a valid token rejected.", zap.String("method", method))
+		return ctx, newHTTPError(401, CodeUnauthorized, "A valid token is required.")
+	}
+	if !token.HasScope(scope) {
+		logger.Info("Token does not have the required scope.", zap.String("token", token.Name), zap.String("scope", scope))
+		return ctx, newHTTPError(403, CodeForbidden, "The token does not have the %s scope.", scope)
+	}
+	return context.WithValue(ctx, grpcTokenKey, token), nil
+}
+
+// grpcAllowed returns whether the token of the request was granted scope for resource
+func (app *App) grpcAllowed(ctx context.Context, scope, resource string) bool {
+	if !app.auth.Enabled() {
+		return true
+	}
+	token, ok := ctx.Value(grpcTokenKey).(*Token)
+	return ok && token.Allows(scope, resource)
+}
+
+// grpcCodes for the status codes of the REST API
+var grpcCodes = map[int]codes.Code{
+	400: codes.InvalidArgument,
+	401: codes.Unauthenticated,
+	403: codes.PermissionDenied,
+	404: codes.NotFound,
+	409: codes.FailedPrecondition,
+	416: codes.OutOfRange,
+	422: codes.InvalidArgument,
+	423: codes.Aborted,
+	503: codes.Unavailable,
+}
+
+// grpcError converts errors returned by handlers to gRPC status errors
+func grpcError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return status.FromContextError(err).Err()
+	}
+	httpStatus, code := errorStatus(err)
+	grpcCode, ok := grpcCodes[httpStatus]
+	if !ok {
+		grpcCode = codes.Internal
+	}
+	return status.Error(grpcCode, fmt.Sprintf("%s: %s", code, errorMessage(err, httpStatus)))
+}
+
+// grpcServed logs the request, records its metrics and returns its error as
+// a gRPC status error
+func grpcServed(ctx context.Context, method string, start time.Time, err error) error {
+	handlerErr := err
+	err = grpcError(err)
+	code := status.Code(err)
+	logger := GRPCLogger(ctx)
+	if code == codes.Internal || code == codes.Unknown || code == codes.Unavailable {
+		logger.Error("Request failed.", zap.String("method", method), zap.Error(handlerErr))
+	}
+	logger.Info(
+		"Request served.",
+		zap.String("method", method),
+		zap.String("code", code.String()),
+		zap.Duration("duration", time.Since(start)),
+	)
+	metrics.GRPCRequestsTotal.WithLabelValues(method, code.String()).Inc()
+	metrics.GRPCRequestDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+	return err
+}
+
+func (app *App) grpcUnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	ctx, err := app.grpcContext(ctx, info.FullMethod)
+	var res interface{}
+	if err == nil {
+		res, err = handler(ctx, req)
+	}
+	return res, grpcServed(ctx, info.FullMethod, start, err)
+}
+
+// grpcStream replaces the context of a server stream
+type grpcStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *grpcStream) Context() context.Context {
+	return s.ctx
+}
+
+func (app *App) grpcStreamInterceptor(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	ctx, err := app.grpcContext(stream.Context(), info.FullMethod)
+	if err == nil {
+		err = handler(srv, &grpcStream{stream, ctx})
+	}
+	return grpcServed(ctx, info.FullMethod, start, err)
+}
+
+// chunkReader reads the chunks of a PutFile stream
+type chunkReader struct {
+	stream rpc.HyperCas_PutFileServer
+	chunk  []byte
+	size   int64
+	done   bool
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for len(r.chunk) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		req, err := r.stream.Recv()
+		if err != nil {
+			return 0, err
+		}
+		r.chunk = req.Chunk
+	}
+	n := copy(p, r.chunk)
+	r.chunk = r.chunk[n:]
+	r.size += int64(n)
+	return n, nil
+}
+
+// PutFile streams the contents to storage while hashing them. Like
+// `PUT /file/{hash}`, contents that do not match the declared hash are not
+// stored.
+func (s *GRPCServer) PutFile(stream rpc.HyperCas_PutFileServer) error {
+	first, err := stream.Recv()
+	done := err == io.EOF
+	if done {
+		first = &rpc.PutFileRequest{}
+	} else if err != nil {
+		return err
+	}
+	declaredHash := first.Hash
+	if declaredHash != "" && !utils.IsHash(declaredHash) {
+		return invalidInput("Invalid hash '%s'.", declaredHash)
+	}
+	reader := &chunkReader{stream: stream, chunk: first.Chunk, done: done}
+	hash := declaredHash
+	if declaredHash != "" {
+		err = s.App.Storage.StoreStreamWithHash(declaredHash, reader)
+	} else {
+		hash, err = s.App.Storage.StoreStream(reader)
+	}
+	if err != nil {
+		return err
+	}
+	GRPCLogger(stream.Context()).Debug("Successfully stored file.", zap.String("hash", hash), zap.Int64("size", reader.size))
+	return stream.SendAndClose(&rpc.PutFileResponse{Hash: hash, Size: reader.size})
+}
+
+func (s *GRPCServer) GetFile(req *rpc.GetFileRequest, stream rpc.HyperCas_GetFileServer) error {
+	if !utils.IsHash(req.Hash) {
+		return notFound("File %s was not found.", req.Hash)
+	}
+	blob, _, err := s.App.Storage.Open(req.Hash)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+	buf := make([]byte, grpcChunkSize)
+	for {
+		n, err := blob.Read(buf)
+		if n > 0 {
+			sendErr := stream.Send(&rpc.FileChunk{Chunk: buf[:n]})
+			if sendErr != nil {
+				return sendErr
+			}
+			metrics.BytesServed.Add(float64(n))
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (s *GRPCServer) HasFile(ctx context.Context, req *rpc.HasFileRequest) (*rpc.HasResponse, error) {
+	exists := utils.IsHash(req.Hash) && s.App.Storage.Has(req.Hash)
+	if exists {
+		metrics.DedupHits.Inc()
+	}
+	return &rpc.HasResponse{Exists: exists}, nil
+}
+
+func (s *GRPCServer) MissingFiles(ctx context.Context, req *rpc.MissingFilesRequest) (*rpc.MissingFilesResponse, error) {
+	missing := []string{}
+	seen := map[string]bool{}
+	for _, hash := range req.Hashes {
+		if seen[hash] {
+			continue
+		}
+		if !utils.IsHash(hash) {
+			return nil, invalidInput("Invalid hash '%s'.", hash)
+		}
+		seen[hash] = true
+		if !s.App.Storage.Exists(hash) {
+			missing = append(missing, hash)
+			continue
+		}
+		metrics.DedupHits.Inc()
+	}
+	return &rpc.MissingFilesResponse{Hashes: missing}, nil
+}
+
+func (s *GRPCServer) PutDistro(ctx context.Context, req *rpc.PutDistroRequest) (*rpc.PutDistroResponse, error) {
+	if len(req.Files) == 0 {
+		return nil, invalidInput("A distribution must have at least one file.")
+	}
+	contents := []string{}
+	items := []content.NodeItem{}
+	for _, file := range req.Files {
+		if file.Path == "" || strings.Contains(file.Path, ":") || !utils.IsHash(file.Hash) {
+			return nil, invalidInput("Invalid distribution file (path: '%s', hash: '%s').", file.Path, file.Hash)
+		}
+		items = append(items, content.NodeItem{Key: file.Path, Hash: []byte(file.Hash)})
+		contents = append(contents, fmt.Sprintf("%s:%s", file.Path, file.Hash))
+	}
+	tree, err := content.NewTreeWithHashes(items)
+	if err != nil {
+		return nil, invalidInput("Failed to calculate tree for distribution: %v", err)
+	}
+	hash := fmt.Sprintf("%x", tree.Root().Hash)
+	logger := GRPCLogger(ctx).With(zap.String("hash", hash))
+	if s.App.Storage.HasDistro(hash) {
+		logger.Info("Distribution already exists on storage. Skipping distribution storage...")
+		return &rpc.PutDistroResponse{Hash: hash}, nil
+	}
+	err = s.App.Storage.StoreDistro(hash, contents)
+	if err != nil {
+		return nil, err
+	}
+	logger.Debug("Distribution stored successfully.")
+	return &rpc.PutDistroResponse{Hash: hash}, nil
+}
+
+func (s *GRPCServer) GetDistro(ctx context.Context, req *rpc.GetDistroRequest) (*rpc.GetDistroResponse, error) {
+	if !s.App.Storage.HasDistro(req.Hash) {
+		return nil, notFound("Distribution %s was not found.", req.Hash)
+	}
+	contents, err := s.App.Storage.GetDistro(req.Hash)
+	if err != nil {
+		return nil, err
+	}
+	res := &rpc.GetDistroResponse{Files: []*rpc.DistroFile{}}
+	for _, item := range contents {
+		parts := strings.SplitN(item, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		res.Files = append(res.Files, &rpc.DistroFile{Path: parts[0], Hash: parts[1]})
+	}
+	return res, nil
+}
+
+func (s *GRPCServer) HasDistro(ctx context.Context, req *rpc.HasDistroRequest) (*rpc.HasResponse, error) {
+	return &rpc.HasResponse{Exists: s.App.Storage.HasDistro(req.Hash)}, nil
+}
+
+func grpcListOptions(req *rpc.ListRequest) (storage.ListOptions, error) {
+	options := storage.ListOptions{
+		Prefix: req.Prefix,
+		Cursor: req.Cursor,
+		SortBy: req.Sort,
+		Limit:  defaultListLimit,
+	}
+	if req.Limit != 0 {
+		if req.Limit < 1 || req.Limit > maxListLimit {
+			return options, invalidInput("invalid limit '%d' (expected 1 to %d)", req.Limit, maxListLimit)
+		}
+		options.Limit = int(req.Limit)
+	}
+	return options, storage.ValidateListOptions(options)
+}
+
+func (s *GRPCServer) ListDistros(ctx context.Context, req *rpc.ListRequest) (*rpc.ListDistrosResponse, error) {
+	options, err := grpcListOptions(req)
+	if err != nil {
+		return nil, err
+	}
+	distros, err := s.App.Storage.ListDistros(options)
+	if err != nil {
+		return nil, err
+	}
+	res := &rpc.ListDistrosResponse{Distros: []*rpc.Distro{}, NextCursor: distros.NextCursor}
+	for _, info := range distros.Distros {
+		res.Distros = append(res.Distros, &rpc.Distro{
+			Hash:       info.Hash,
+			FileCount:  int64(info.FileCount),
+			TotalBytes: info.TotalBytes,
+			CreatedAt:  timestamppb.New(info.CreatedAt),
+		})
+	}
+	return res, nil
+}
+
+func labelMessage(info *storage.LabelInfo) *rpc.Label {
+	label := &rpc.Label{Name: info.Name, Hash: info.Hash, Revision: info.Revision}
+	if !info.UpdatedAt.IsZero() {
+		label.UpdatedAt = timestamppb.New(info.UpdatedAt)
+	}
+	return label
+}
+
+func (s *GRPCServer) SetLabel(ctx context.Context, req *rpc.SetLabelRequest) (*rpc.Label, error) {
+	if req.Label == "" || req.Hash == "" {
+		return nil, invalidInput("Both label and hash must be set (label: '%s', hash: '%s').", req.Label, req.Hash)
+	}
+	if !s.App.grpcAllowed(ctx, ScopeLabelWrite, req.Label) {
+		return nil, newHTTPError(403, CodeForbidden, "The token is not allowed to update label %s.", req.Label)
+	}
+	err := s.App.Storage.StoreLabel(req.Label, req.Hash)
+	if err != nil {
+		return nil, err
+	}
+	info, err := s.App.Storage.GetLabelInfo(req.Label)
+	if err != nil {
+		return nil, err
+	}
+	GRPCLogger(ctx).Debug("Label stored successfully.", zap.String("label", req.Label), zap.String("hash", req.Hash))
+	return labelMessage(info), nil
+}
+
+func (s *GRPCServer) GetLabel(ctx context.Context, req *rpc.GetLabelRequest) (*rpc.Label, error) {
+	if !s.App.Storage.HasLabel(req.Label) {
+		return nil, notFound("Label %s was not found.", req.Label)
+	}
+	info, err := s.App.Storage.GetLabelInfo(req.Label)
+	if err != nil {
+		return nil, err
+	}
+	return labelMessage(info), nil
+}
+
+func (s *GRPCServer) DeleteLabel(ctx context.Context, req *rpc.DeleteLabelRequest) (*rpc.DeleteLabelResponse, error) {
+	if !s.App.grpcAllowed(ctx, ScopeLabelWrite, req.Label) {
+		return nil, newHTTPError(403, CodeForbidden, "The token is not allowed to delete label %s.", req.Label)
+	}
+	if !s.App.Storage.HasLabel(req.Label) {
+		return nil, notFound("Label %s was not found.", req.Label)
+	}
+	err := s.App.Storage.DeleteLabel(req.Label)
+	if err != nil {
+		return nil, err
+	}
+	GRPCLogger(ctx).Debug("Label deleted successfully.", zap.String("label", req.Label))
+	return &rpc.DeleteLabelResponse{}, nil
+}
+
+func (s *GRPCServer) ListLabels(ctx context.Context, req *rpc.ListRequest) (*rpc.ListLabelsResponse, error) {
+	options, err := grpcListOptions(req)
+	if err != nil {
+		return nil, err
+	}
+	labels, err := s.App.Storage.ListLabels(options)
+	if err != nil {
+		return nil, err
+	}
+	res := &rpc.ListLabelsResponse{Labels: []*rpc.Label{}, NextCursor: labels.NextCursor}
+	for _, info := range labels.Labels {
+		res.Labels = append(res.Labels, labelMessage(info))
+	}
+	return res, nil
+}
+
+// WatchLabels sends the current state of the labels and then every change
+// to them, until the client goes away or the server shuts down
+func (s *GRPCServer) WatchLabels(req *rpc.WatchLabelsRequest, stream rpc.HyperCas_WatchLabelsServer) error {
+	if req.Label != "" && req.Prefix != "" {
+		return invalidInput("Either label or prefix should be set, not both.")
+	}
+	app := s.App
+	var w *watcher
+	if req.Label != "" {
+		w = app.watches.watch(req.Label, true)
+	} else {
+		w = app.watches.watch(req.Prefix, false)
+	}
+	defer app.watches.unwatch(w)
+
+	sent := map[string]*storage.LabelInfo{}
+	for {
+		states, err := app.labelStates(w)
+		if err != nil {
+			return err
+		}
+		for _, change := range labelChanges(sent, states) {
+			err = stream.Send(&rpc.LabelEvent{Type: change.event, Label: labelMessage(change.info)})
+			if err != nil {
+				return err
+			}
+		}
+		sent = states
+
+		select {
+		case <-w.wake:
+		case <-stream.Context().Done():
+			return nil
+		case <-app.watches.closed:
+			return nil
+		}
+	}
+}
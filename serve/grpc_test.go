@@ -0,0 +1,201 @@
+package serve
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vtex/hyper-cas/rpc"
+	"github.com/vtex/hyper-cas/storage"
+	"github.com/vtex/hyper-cas/utils"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func newGRPCClient(t *testing.T, app *App) rpc.HyperCasClient {
+	ln := bufconn.Listen(1024 * 1024)
+	server := app.NewGRPC(nil)
+	go server.Serve(ln)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial(
+		"bufnet",
+		grpc.WithInsecure(),
+		grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+			return ln.Dial()
+		}),
+	)
+	assert.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return rpc.NewHyperCasClient(conn)
+}
+
+func grpcPutFile(t *testing.T, c rpc.HyperCasClient, ctx context.Context, hash string, chunks ...string) (*rpc.PutFileResponse, error) {
+	stream, err := c.PutFile(ctx)
+	assert.NoError(t, err)
+	for i, chunk := range chunks {
+		req := &rpc.PutFileRequest{Chunk: []byte(chunk)}
+		if i == 0 {
+			req.Hash = hash
+		}
+		assert.NoError(t, stream.Send(req))
+	}
+	return stream.CloseAndRecv()
+}
+
+func TestGRPCFiles(t *testing.T) {
+	app, err := NewApp(200, storage.FileSystem)
+	assert.NoError(t, err)
+	c := newGRPCClient(t, app)
+	ctx := context.Background()
+	text := fmt.Sprintf("grpc file %s", utils.RandString(8))
+	hash := fmt.Sprintf("%x", utils.Hash(text))
+
+	var header metadata.MD
+	stream, err := c.PutFile(metadata.AppendToOutgoingContext(ctx, "x-request-id", "grpc-request"), grpc.Header(&header))
+	assert.NoError(t, err)
+	assert.NoError(t, stream.Send(&rpc.PutFileRequest{Hash: hash, Chunk: []byte(text[:4])}))
+	assert.NoError(t, stream.Send(&rpc.PutFileRequest{Chunk: []byte(text[4:])}))
+	res, err := stream.CloseAndRecv()
+	assert.NoError(t, err)
+	assert.Equal(t, hash, res.Hash)
+	assert.Equal(t, int64(len(text)), res.Size)
+	assert.Equal(t, []string{"grpc-request"}, header.Get("x-request-id"))
+
+	has, err := c.HasFile(ctx, &rpc.HasFileRequest{Hash: hash})
+	assert.NoError(t, err)
+	assert.True(t, has.Exists)
+
+	files, err := c.GetFile(ctx, &rpc.GetFileRequest{Hash: hash})
+	assert.NoError(t, err)
+	contents := ""
+	for {
+		chunk, err := files.Recv()
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+		contents += string(chunk.Chunk)
+	}
+	assert.Equal(t, text, contents)
+
+	other := fmt.Sprintf("%x", utils.Hash(utils.RandString(16)))
+	missing, err := c.MissingFiles(ctx, &rpc.MissingFilesRequest{Hashes: []string{hash, other}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{other}, missing.Hashes)
+
+	files, err = c.GetFile(ctx, &rpc.GetFileRequest{Hash: other})
+	assert.NoError(t, err)
+	_, err = files.Recv()
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestGRPCPutFileWithWrongHash(t *testing.T) {
+	app, err := NewApp(200, storage.FileSystem)
+	assert.NoError(t, err)
+	c := newGRPCClient(t, app)
+
+	contents := "mismatched contents " + utils.RandString(8)
+	_, err = grpcPutFile(t, c, context.Background(), fmt.Sprintf("%x", utils.Hash("other")), contents)
+
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.Contains(t, status.Convert(err).Message(), CodeHashMismatch)
+	assert.False(t, app.Storage.Has(fmt.Sprintf("%x", utils.Hash(contents))))
+}
+
+func TestGRPCDistrosAndLabels(t *testing.T) {
+	app, err := NewApp(200, storage.FileSystem)
+	assert.NoError(t, err)
+	c := newGRPCClient(t, app)
+	ctx := context.Background()
+	res, err := grpcPutFile(t, c, ctx, "", fmt.Sprintf("grpc distro %s", utils.RandString(8)))
+	assert.NoError(t, err)
+
+	distro, err := c.PutDistro(ctx, &rpc.PutDistroRequest{Files: []*rpc.DistroFile{{Path: "index.html", Hash: res.Hash}}})
+	assert.NoError(t, err)
+	files, err := c.GetDistro(ctx, &rpc.GetDistroRequest{Hash: distro.Hash})
+	assert.NoError(t, err)
+	assert.Len(t, files.Files, 1)
+	assert.Equal(t, "index.html", files.Files[0].Path)
+	assert.Equal(t, res.Hash, files.Files[0].Hash)
+
+	label := fmt.Sprintf("grpc-%s", strings.ToLower(utils.RandString(8)))
+	set, err := c.SetLabel(ctx, &rpc.SetLabelRequest{Label: label, Hash: distro.Hash})
+	assert.NoError(t, err)
+	assert.Equal(t, distro.Hash, set.Hash)
+	assert.NotZero(t, set.Revision)
+	got, err := c.GetLabel(ctx, &rpc.GetLabelRequest{Label: label})
+	assert.NoError(t, err)
+	assert.Equal(t, set.Revision, got.Revision)
+
+	labels, err := c.ListLabels(ctx, &rpc.ListRequest{Prefix: label})
+	assert.NoError(t, err)
+	assert.Len(t, labels.Labels, 1)
+
+	_, err = c.DeleteLabel(ctx, &rpc.DeleteLabelRequest{Label: label})
+	assert.NoError(t, err)
+	_, err = c.GetLabel(ctx, &rpc.GetLabelRequest{Label: label})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = c.PutDistro(ctx, &rpc.PutDistroRequest{Files: []*rpc.DistroFile{{Path: "index.html", Hash: "invalid"}}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestGRPCWatchLabels(t *testing.T) {
+	app, err := NewApp(200, storage.FileSystem)
+	assert.NoError(t, err)
+	c := newGRPCClient(t, app)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	prefix := fmt.Sprintf("grpc-watch-%s-", strings.ToLower(utils.RandString(8)))
+	hash := fmt.Sprintf("%x", utils.Hash("grpc watch"))
+	storeDistro(t, app, hash)
+	assert.NoError(t, app.Storage.StoreLabel(prefix+"a", hash))
+
+	stream, err := c.WatchLabels(ctx, &rpc.WatchLabelsRequest{Prefix: prefix})
+	assert.NoError(t, err)
+	event, err := stream.Recv()
+	assert.NoError(t, err)
+	assert.Equal(t, storage.EventLabelUpdated, event.Type)
+	assert.Equal(t, prefix+"a", event.Label.Name)
+
+	assert.NoError(t, app.Storage.StoreLabel(prefix+"b", hash))
+	event, err = stream.Recv()
+	assert.NoError(t, err)
+	assert.Equal(t, storage.EventLabelUpdated, event.Type)
+	assert.Equal(t, prefix+"b", event.Label.Name)
+
+	assert.NoError(t, app.Storage.DeleteLabel(prefix+"a"))
+	event, err = stream.Recv()
+	assert.NoError(t, err)
+	assert.Equal(t, storage.EventLabelDeleted, event.Type)
+	assert.Equal(t, prefix+"a", event.Label.Name)
+}
+
+func TestGRPCAuth(t *testing.T) {
+	app := newAuthApp(t)
+	c := newGRPCClient(t, app)
+	ctx := context.Background()
+
+	_, err := grpcPutFile(t, c, ctx, "", "grpc auth")
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	ciCtx := metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer ci-token")
+	res, err := grpcPutFile(t, c, ciCtx, "", "grpc auth")
+	assert.NoError(t, err)
+
+	_, err = c.SetLabel(ciCtx, &rpc.SetLabelRequest{Label: "master", Hash: res.Hash})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	has, err := c.HasFile(ctx, &rpc.HasFileRequest{Hash: res.Hash})
+	assert.NoError(t, err)
+	assert.True(t, has.Exists)
+}
@@ -1,6 +1,19 @@
 package serve
 
-import "github.com/valyala/fasthttp"
+import (
+	"encoding/json"
+
+	"github.com/valyala/fasthttp"
+	"github.com/vtex/hyper-cas/storage"
+	"go.uber.org/zap"
+)
+
+// Statuses reported by the readiness route
+const (
+	ReadinessReady     = "ready"
+	ReadinessDraining  = "draining"
+	ReadinessUnhealthy = "unhealthy"
+)
 
 type HealthcheckHandler struct {
 	App *App
@@ -10,7 +23,36 @@ func NewHealthcheckHandler(app *App) *HealthcheckHandler {
 	return &HealthcheckHandler{App: app}
 }
 
+// readiness is the body of readiness responses
+type readiness struct {
+	Status string           `json:"status"`
+	Checks []*storage.Check `json:"checks"`
+}
+
 func (handler *HealthcheckHandler) handleGet(ctx *fasthttp.RequestCtx) error {
 	ctx.SetBodyString("OK")
 	return nil
 }
+
+func (handler *HealthcheckHandler) handleReadiness(ctx *fasthttp.RequestCtx) error {
+	result := &readiness{Status: ReadinessReady, Checks: handler.App.Storage.Check()}
+	for _, check := range result.Checks {
+		if !check.OK {
+			Logger(ctx).Warn("Readiness check failed.", zap.String("check", check.Name), zap.String("message", check.Message))
+			result.Status = ReadinessUnhealthy
+		}
+	}
+	if handler.App.IsDraining() {
+		result.Status = ReadinessDraining
+	}
+	body, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	if result.Status != ReadinessReady {
+		ctx.SetStatusCode(503)
+	}
+	ctx.SetContentType("application/json")
+	ctx.SetBody(body)
+	return nil
+}
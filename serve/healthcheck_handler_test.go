@@ -1,8 +1,10 @@
 package serve
 
 import (
+	"encoding/json"
 	"testing"
 
+	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
 	"github.com/vtex/hyper-cas/storage"
 	"github.com/vtex/hyper-cas/utils"
@@ -20,3 +22,62 @@ func TestHealthcheckHandler(t *testing.T) {
 	assert.Equal(t, status, 200)
 	assert.Equal(t, body, "OK")
 }
+
+func TestReadinessHandler(t *testing.T) {
+	app, err := NewApp(200, storage.FileSystem)
+	assert.Nil(t, err)
+
+	_, status, body, err := utils.DoRequest(app, "GET", "/readiness", "")
+
+	assert.NoError(t, err)
+	assert.Equal(t, 200, status)
+	result := parseReadiness(t, body)
+	assert.Equal(t, ReadinessReady, result.Status)
+	names := []string{}
+	for _, check := range result.Checks {
+		assert.True(t, check.OK, "%s: %s", check.Name, check.Message)
+		names = append(names, check.Name)
+	}
+	assert.Equal(t, []string{"probe", "lock", "rootPathSpace", "sitesPathSpace"}, names)
+}
+
+func TestReadinessHandlerWithLowDiskSpace(t *testing.T) {
+	app, err := NewApp(200, storage.FileSystem)
+	assert.Nil(t, err)
+	viper.Set("storage.minFreePercent", 101)
+	defer viper.Set("storage.minFreePercent", 1)
+
+	_, status, body, err := utils.DoRequest(app, "GET", "/readiness", "")
+
+	assert.NoError(t, err)
+	assert.Equal(t, 503, status)
+	result := parseReadiness(t, body)
+	assert.Equal(t, ReadinessUnhealthy, result.Status)
+	for _, check := range result.Checks {
+		if check.Name == "rootPathSpace" || check.Name == "sitesPathSpace" {
+			assert.False(t, check.OK)
+			assert.Contains(t, check.Message, "below the minimum")
+		} else {
+			assert.True(t, check.OK, "%s: %s", check.Name, check.Message)
+		}
+	}
+}
+
+func TestReadinessHandlerWhenDraining(t *testing.T) {
+	app, err := NewApp(200, storage.FileSystem)
+	assert.Nil(t, err)
+	app.StartDraining()
+
+	_, status, body, err := utils.DoRequest(app, "GET", "/readiness", "")
+
+	assert.NoError(t, err)
+	assert.Equal(t, 503, status)
+	assert.Equal(t, ReadinessDraining, parseReadiness(t, body).Status)
+}
+
+func parseReadiness(t *testing.T, body string) *readiness {
+	var result readiness
+	err := json.Unmarshal([]byte(body), &result)
+	assert.NoError(t, err, "Should be JSON: %s", body)
+	return &result
+}
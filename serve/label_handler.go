@@ -1,10 +1,11 @@
 package serve
 
 import (
-	"fmt"
+	"encoding/json"
+	"strings"
 
 	"github.com/valyala/fasthttp"
-	"github.com/vtex/hyper-cas/utils"
+	"github.com/vtex/hyper-cas/api"
 	"go.uber.org/zap"
 )
 
@@ -16,16 +17,33 @@ func NewLabelHandler(app *App) *LabelHandler {
 	return &LabelHandler{App: app}
 }
 
+// labelUpdate is the JSON body accepted to update labels
+type labelUpdate struct {
+	Label       string            `json:"label"`
+	Hash        string            `json:"hash"`
+	Annotations map[string]string `json:"annotations"`
+}
+
 func (handler *LabelHandler) handlePut(ctx *fasthttp.RequestCtx) error {
 	label := string(ctx.PostArgs().Peek("label"))
 	hash := string(ctx.PostArgs().Peek("hash"))
-	logger := utils.LoggerWith(zap.String("label", label), zap.String("hash", hash))
+	var annotations map[string]string
+	if strings.HasPrefix(string(ctx.Request.Header.ContentType()), "application/json") {
+		var update labelUpdate
+		err := json.Unmarshal(ctx.Request.Body(), &update)
+		if err != nil {
+			return invalidInput("The body should be a JSON object with label and hash: %v", err)
+		}
+		label, hash, annotations = update.Label, update.Hash, update.Annotations
+	}
+	logger := Logger(ctx).With(zap.String("label", label), zap.String("hash", hash))
 	if label == "" || hash == "" {
-		err := fmt.Errorf("Both label and hash must be set (label: '%s', hash: '%s')", label, hash)
-		logger.Error("Failed to save label.", zap.Error(err))
-		return err
+		return invalidInput("Both label and hash must be set (label: '%s', hash: '%s').", label, hash)
 	}
-	err := handler.App.Storage.StoreLabel(label, hash)
+	if !handler.App.IsAllowed(ctx, ScopeLabelWrite, label) {
+		return newHTTPError(403, CodeForbidden, "The token is not allowed to update label %s.", label)
+	}
+	err := handler.App.Storage.StoreLabelWithAnnotations(label, hash, annotations)
 	if err != nil {
 		logger.Error("Failed to store label.", zap.Error(err))
 		return err
@@ -36,15 +54,13 @@ func (handler *LabelHandler) handlePut(ctx *fasthttp.RequestCtx) error {
 
 func (handler *LabelHandler) handleGet(ctx *fasthttp.RequestCtx) error {
 	label := ctx.UserValue("label").(string)
-	logger := utils.LoggerWith(zap.String("label", label))
+	logger := Logger(ctx).With(zap.String("label", label))
 	if !handler.App.Storage.HasLabel(label) {
-		logger.Info("Label was not found in storage.")
-		ctx.SetStatusCode(404)
-		return nil
+		return notFound("Label %s was not found.", label)
 	}
 	contents, err := handler.App.Storage.GetLabel(label)
 	if err != nil {
-		logger.Error("Could not retrieve label from storage.")
+		logger.Error("Could not retrieve label from storage.", zap.Error(err))
 		return err
 	}
 	ctx.SetBodyString(contents)
@@ -54,7 +70,7 @@ func (handler *LabelHandler) handleGet(ctx *fasthttp.RequestCtx) error {
 
 func (handler *LabelHandler) handleHead(ctx *fasthttp.RequestCtx) error {
 	label := ctx.UserValue("label").(string)
-	logger := utils.LoggerWith(zap.String("label", label))
+	logger := Logger(ctx).With(zap.String("label", label))
 	if has := handler.App.Storage.HasLabel(label); has {
 		logger.Debug("Label found.")
 		ctx.SetStatusCode(200)
@@ -64,3 +80,102 @@ func (handler *LabelHandler) handleHead(ctx *fasthttp.RequestCtx) error {
 	}
 	return nil
 }
+
+func (handler *LabelHandler) handleDelete(ctx *fasthttp.RequestCtx) error {
+	label := ctx.UserValue("label").(string)
+	logger := Logger(ctx).With(zap.String("label", label))
+	if !handler.App.IsAllowed(ctx, ScopeLabelWrite, label) {
+		return newHTTPError(403, CodeForbidden, "The token is not allowed to delete label %s.", label)
+	}
+	if !handler.App.Storage.HasLabel(label) {
+		return notFound("Label %s was not found.", label)
+	}
+	err := handler.App.Storage.DeleteLabel(label)
+	if err != nil {
+		logger.Error("Failed to delete label.", zap.Error(err))
+		return err
+	}
+	logger.Debug("Label deleted successfully.")
+	return nil
+}
+
+func (handler *LabelHandler) handleList(ctx *fasthttp.RequestCtx) error {
+	options, err := parseListOptions(ctx)
+	if err != nil {
+		return err
+	}
+	labels, err := handler.App.Storage.ListLabels(options)
+	if err != nil {
+		Logger(ctx).Error("Failed to list labels.", zap.Error(err))
+		return err
+	}
+	body, err := json.Marshal(labels)
+	if err != nil {
+		return err
+	}
+	ctx.SetContentType("application/json")
+	ctx.SetBody(body)
+	Logger(ctx).Debug("Labels listed successfully.", zap.Int("count", len(labels.Labels)))
+	return nil
+}
+
+func (handler *LabelHandler) handleWatch(ctx *fasthttp.RequestCtx) error {
+	label := ctx.UserValue("label").(string)
+	if acceptsEventStream(ctx) {
+		handler.App.streamLabels(ctx, handler.App.watches.watch(label, true))
+		return nil
+	}
+	return handler.App.longPollLabel(ctx, label)
+}
+
+func (handler *LabelHandler) handleWatchAll(ctx *fasthttp.RequestCtx) error {
+	prefix := string(ctx.QueryArgs().Peek("prefix"))
+	handler.App.streamLabels(ctx, handler.App.watches.watch(prefix, false))
+	return nil
+}
+
+func writeLabelDomains(ctx *fasthttp.RequestCtx, label string, domains []string) error {
+	body, err := json.Marshal(&api.LabelDomains{Label: label, Domains: domains})
+	if err != nil {
+		return err
+	}
+	ctx.SetContentType("application/json")
+	ctx.SetBody(body)
+	return nil
+}
+
+func (handler *LabelHandler) handleGetDomains(ctx *fasthttp.RequestCtx) error {
+	label := ctx.UserValue("label").(string)
+	if !handler.App.Storage.HasLabel(label) {
+		return notFound("Label %s was not found.", label)
+	}
+	domains, err := handler.App.Storage.GetLabelDomains(label)
+	if err != nil {
+		Logger(ctx).Error("Could not retrieve label domains from storage.", zap.String("label", label), zap.Error(err))
+		return err
+	}
+	return writeLabelDomains(ctx, label, domains)
+}
+
+func (handler *LabelHandler) handlePutDomains(ctx *fasthttp.RequestCtx) error {
+	label := ctx.UserValue("label").(string)
+	logger := Logger(ctx).With(zap.String("label", label))
+	if !handler.App.IsAllowed(ctx, ScopeLabelWrite, label) {
+		return newHTTPError(403, CodeForbidden, "The token is not allowed to update label %s.", label)
+	}
+	var update api.LabelDomains
+	err := json.Unmarshal(ctx.Request.Body(), &update)
+	if err != nil || update.Domains == nil {
+		return invalidInput("The body should be a JSON object with a list of domains.")
+	}
+	if !handler.App.Storage.HasLabel(label) {
+		return notFound("Label %s was not found.", label)
+	}
+	domains, err := handler.App.Storage.SetLabelDomains(label, update.Domains)
+	if err != nil {
+		logger.Error("Failed to store label domains.", zap.Error(err))
+		return err
+	}
+	logger.Debug("Label domains stored successfully.", zap.Strings("domains", domains))
+	return writeLabelDomains(ctx, label, domains)
+}
@@ -1,11 +1,16 @@
 package serve
 
 import (
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/url"
+	"os"
 	"path"
+	"regexp"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
@@ -76,7 +81,7 @@ func TestLabelHandlerGetNotFound(t *testing.T) {
 
 	assert.NoError(t, err)
 	assert.Equal(t, 404, status)
-	assert.Equal(t, "", body)
+	assertErrorBody(t, body, CodeNotFound)
 }
 
 func TestLabelHandlerHead(t *testing.T) {
@@ -107,3 +112,571 @@ func TestLabelHandlerHeadNotFound(t *testing.T) {
 	assert.Equal(t, 404, status)
 	assert.Equal(t, "", body)
 }
+
+// storeDistro so labels can point to hash, which only has to be a hash in
+// tests that don't serve the distribution
+func storeDistro(t *testing.T, app *App, hash string) {
+	if !app.Storage.HasDistro(hash) {
+		assert.NoError(t, app.Storage.StoreDistro(hash, []string{}))
+	}
+}
+
+func putLabel(t *testing.T, app *App, label, hash string, modTime time.Time) {
+	storeDistro(t, app, hash)
+	form := url.Values{}
+	form.Add("label", label)
+	form.Add("hash", hash)
+	_, status, _, err := utils.DoRequest(app, "PUT", "/label", form.Encode())
+	assert.NoError(t, err)
+	assert.Equal(t, 200, status)
+	labelPath := path.Join(viper.GetString("storage.rootPath"), "labels", label)
+	assert.NoError(t, os.Chtimes(labelPath, modTime, modTime))
+}
+
+func listLabels(t *testing.T, app *App, query string) *storage.LabelList {
+	_, status, body, err := utils.DoRequest(app, "GET", fmt.Sprintf("/labels?%s", query), "")
+	assert.NoError(t, err)
+	assert.Equal(t, 200, status)
+	var list storage.LabelList
+	assert.NoError(t, json.Unmarshal([]byte(body), &list))
+	return &list
+}
+
+func labelNames(list *storage.LabelList) []string {
+	names := []string{}
+	for _, label := range list.Labels {
+		names = append(names, label.Name)
+	}
+	return names
+}
+
+func TestLabelHandlerList(t *testing.T) {
+	app, err := NewApp(200, storage.FileSystem)
+	assert.Nil(t, err)
+	hash := fmt.Sprintf("%x", utils.Hash("qwe"))
+	now := time.Now()
+	putLabel(t, app, "list-b", hash, now.Add(-3*time.Minute))
+	putLabel(t, app, "list-a", hash, now.Add(-2*time.Minute))
+	putLabel(t, app, "list-c", hash, now.Add(-1*time.Minute))
+	putLabel(t, app, "other", hash, now)
+
+	list := listLabels(t, app, "prefix=list-&limit=2")
+	assert.Equal(t, []string{"list-a", "list-b"}, labelNames(list))
+	assert.Equal(t, hash, list.Labels[0].Hash)
+	assert.NotEmpty(t, list.NextCursor)
+
+	list = listLabels(t, app, fmt.Sprintf("prefix=list-&limit=2&cursor=%s", list.NextCursor))
+	assert.Equal(t, []string{"list-c"}, labelNames(list))
+	assert.Empty(t, list.NextCursor)
+
+	list = listLabels(t, app, "prefix=list-&sort=modified")
+	assert.Equal(t, []string{"list-c", "list-a", "list-b"}, labelNames(list))
+}
+
+func TestLabelHandlerListInvalidOptions(t *testing.T) {
+	app, err := NewApp(200, storage.FileSystem)
+	assert.Nil(t, err)
+
+	for _, query := range []string{"sort=size", "limit=0", "limit=abc", "cursor=invalid"} {
+		_, status, _, err := utils.DoRequest(app, "GET", fmt.Sprintf("/labels?%s", query), "")
+		assert.NoError(t, err)
+		assert.Equal(t, 400, status, query)
+	}
+}
+
+func TestLabelHandlerDelete(t *testing.T) {
+	app, err := NewApp(200, storage.FileSystem)
+	assert.Nil(t, err)
+	putLabel(t, app, "deleted", fmt.Sprintf("%x", utils.Hash("qwe")), time.Now())
+
+	_, status, _, err := utils.DoRequest(app, "DELETE", "/label/deleted", "")
+
+	assert.NoError(t, err)
+	assert.Equal(t, 200, status)
+	assert.False(t, utils.FileExists(path.Join(viper.GetString("storage.rootPath"), "labels", "deleted")))
+	assert.False(t, utils.FileExists(path.Join(viper.GetString("storage.sitesPath"), "deleted.conf")))
+
+	_, status, _, err = utils.DoRequest(app, "DELETE", "/label/deleted", "")
+
+	assert.NoError(t, err)
+	assert.Equal(t, 404, status)
+}
+
+func TestLabelHandlerPutJSON(t *testing.T) {
+	app, err := NewApp(200, storage.FileSystem)
+	assert.Nil(t, err)
+	hash := fmt.Sprintf("%x", utils.Hash("json"))
+	storeDistro(t, app, hash)
+
+	_, status, _, err := utils.DoRequestWithHeaders(app, "PUT", "/label", fmt.Sprintf(`{"label":"json-label","hash":"%s"}`, hash), map[string]string{
+		"Content-Type": "application/json",
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 200, status)
+	label, err := app.Storage.GetLabel("json-label")
+	assert.NoError(t, err)
+	assert.Equal(t, hash, label)
+}
+
+func TestLabelHandlerPutWithCaddy(t *testing.T) {
+	viper.Set("storage.siteBuilder", "caddy")
+	viper.Set("caddy.serverName", "hyper-cas.org")
+	t.Cleanup(func() { viper.Set("storage.siteBuilder", "nginx") })
+	app, err := NewApp(200, storage.FileSystem)
+	assert.Nil(t, err)
+	label := fmt.Sprintf("caddy-%s", strings.ToLower(utils.RandString(8)))
+	hash := fmt.Sprintf("%x", utils.Hash("caddy"))
+
+	putLabel(t, app, label, hash, time.Now())
+
+	confPath := path.Join(viper.GetString("storage.sitesPath"), fmt.Sprintf("%s.caddy", label))
+	dat, err := ioutil.ReadFile(confPath)
+	assert.NoError(t, err)
+	conf := string(dat)
+	assert.Contains(t, conf, fmt.Sprintf("http://%s.hyper-cas.org {", label))
+	assert.Contains(t, conf, fmt.Sprintf("root * /app/sites/%s", hash))
+	assert.Contains(t, conf, fmt.Sprintf("Hyper-Cas-Hash %s", hash))
+	assert.Contains(t, conf, "gzip 6")
+	assert.NotContains(t, conf, "zstd")
+	assert.False(t, utils.FileExists(path.Join(viper.GetString("storage.sitesPath"), fmt.Sprintf("%s.conf", label))))
+
+	_, status, _, err := utils.DoRequest(app, "DELETE", fmt.Sprintf("/label/%s", label), "")
+
+	assert.NoError(t, err)
+	assert.Equal(t, 200, status)
+	assert.False(t, utils.FileExists(confPath))
+}
+
+func TestNewAppWithUnknownSiteBuilder(t *testing.T) {
+	viper.Set("storage.siteBuilder", "apache")
+	t.Cleanup(func() { viper.Set("storage.siteBuilder", "nginx") })
+
+	_, err := NewApp(200, storage.FileSystem)
+
+	assert.Error(t, err)
+
+	viper.Set("storage.siteBuilder", "nginx")
+	viper.Set("nginx.mode", "servers")
+	t.Cleanup(func() { viper.Set("nginx.mode", "server") })
+
+	_, err = NewApp(200, storage.FileSystem)
+
+	assert.Error(t, err)
+}
+
+func TestLabelHandlerPutWithTraefik(t *testing.T) {
+	confName := fmt.Sprintf("traefik-%s.yaml", utils.RandString(8))
+	viper.Set("storage.siteBuilder", "traefik")
+	viper.Set("traefik.serverName", "hyper-cas.org")
+	viper.Set("traefik.configPath", confName)
+	t.Cleanup(func() { viper.Set("storage.siteBuilder", "nginx") })
+	app, err := NewApp(200, storage.FileSystem)
+	assert.Nil(t, err)
+	label := fmt.Sprintf("traefik-%s", strings.ToLower(utils.RandString(8)))
+	hash := fmt.Sprintf("%x", utils.Hash("traefik"))
+
+	putLabel(t, app, label, hash, time.Now())
+
+	confPath := path.Join(viper.GetString("storage.sitesPath"), confName)
+	dat, err := ioutil.ReadFile(confPath)
+	assert.NoError(t, err)
+	conf := string(dat)
+	assert.Contains(t, conf, fmt.Sprintf("rule: Host(`%s.hyper-cas.org`)", label))
+	assert.Contains(t, conf, fmt.Sprintf("prefix: /%s", hash))
+	assert.Contains(t, conf, "url: http://localhost:80")
+	assert.NotContains(t, conf, "PathRegexp")
+	assert.False(t, utils.FileExists(path.Join(viper.GetString("storage.sitesPath"), fmt.Sprintf("%s.conf", label))))
+
+	_, status, _, err := utils.DoRequest(app, "DELETE", fmt.Sprintf("/label/%s", label), "")
+
+	assert.NoError(t, err)
+	assert.Equal(t, 200, status)
+	dat, err = ioutil.ReadFile(confPath)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(dat), label)
+}
+
+func TestLabelHandlerPutWithTraefikCacheRouters(t *testing.T) {
+	confName := fmt.Sprintf("traefik-%s.yaml", utils.RandString(8))
+	viper.Set("storage.siteBuilder", "traefik")
+	viper.Set("traefik.serverName", "hyper-cas.org")
+	viper.Set("traefik.configPath", confName)
+	viper.Set("traefik.cacheRouters", true)
+	t.Cleanup(func() {
+		viper.Set("storage.siteBuilder", "nginx")
+		viper.Set("traefik.cacheRouters", nil)
+	})
+	app, err := NewApp(200, storage.FileSystem)
+	assert.Nil(t, err)
+	label := fmt.Sprintf("traefik-%s", strings.ToLower(utils.RandString(8)))
+
+	putLabel(t, app, label, fmt.Sprintf("%x", utils.Hash("traefik")), time.Now())
+
+	dat, err := ioutil.ReadFile(path.Join(viper.GetString("storage.sitesPath"), confName))
+	assert.NoError(t, err)
+	assert.Contains(t, string(dat), fmt.Sprintf("rule: (Host(`%s.hyper-cas.org`)) && PathRegexp(`(^|/)[^/]*\\.html$`)", label))
+	assert.Contains(t, string(dat), "Cache-Control: no-cache")
+}
+
+func TestLabelHandlerPutWithEnvoy(t *testing.T) {
+	confName := fmt.Sprintf("envoy-%s.json", utils.RandString(8))
+	viper.Set("storage.siteBuilder", "envoy")
+	viper.Set("envoy.serverName", "hyper-cas.org")
+	viper.Set("envoy.configPath", confName)
+	t.Cleanup(func() { viper.Set("storage.siteBuilder", "nginx") })
+	app, err := NewApp(200, storage.FileSystem)
+	assert.Nil(t, err)
+	prefix := fmt.Sprintf("envoy-%s-", strings.ToLower(utils.RandString(8)))
+	hash := fmt.Sprintf("%x", utils.Hash("envoy"))
+
+	putLabel(t, app, prefix+"a", hash, time.Now())
+	putLabel(t, app, prefix+"b", hash, time.Now())
+
+	dat, err := ioutil.ReadFile(path.Join(viper.GetString("storage.sitesPath"), confName))
+	assert.NoError(t, err)
+	var conf struct {
+		VersionInfo string `json:"version_info"`
+		Resources   []struct {
+			Type         string `json:"@type"`
+			VirtualHosts []struct {
+				Name    string   `json:"name"`
+				Domains []string `json:"domains"`
+				Routes  []struct {
+					Route struct {
+						Cluster       string `json:"cluster"`
+						PrefixRewrite string `json:"prefix_rewrite"`
+					} `json:"route"`
+				} `json:"routes"`
+			} `json:"virtual_hosts"`
+		} `json:"resources"`
+	}
+	assert.NoError(t, json.Unmarshal(dat, &conf))
+	assert.NotEmpty(t, conf.VersionInfo)
+	assert.Len(t, conf.Resources, 1)
+	found := 0
+	for _, host := range conf.Resources[0].VirtualHosts {
+		if host.Name == prefix+"a" || host.Name == prefix+"b" {
+			found++
+			assert.Equal(t, []string{fmt.Sprintf("%s.hyper-cas.org", host.Name)}, host.Domains)
+			assert.Equal(t, "hyper-cas-sites", host.Routes[len(host.Routes)-1].Route.Cluster)
+			assert.Equal(t, fmt.Sprintf("/%s/", hash), host.Routes[len(host.Routes)-1].Route.PrefixRewrite)
+		}
+	}
+	assert.Equal(t, 2, found)
+}
+
+func writeTemplate(t *testing.T, contents string) string {
+	file, err := ioutil.TempFile("", "hyper-cas-template")
+	assert.NoError(t, err)
+	_, err = file.WriteString(contents)
+	assert.NoError(t, err)
+	assert.NoError(t, file.Close())
+	t.Cleanup(func() { os.Remove(file.Name()) })
+	return file.Name()
+}
+
+func setTemplates(t *testing.T, global string, templates []map[string]string) {
+	viper.Set("nginx.template", global)
+	viper.Set("nginx.templates", templates)
+	t.Cleanup(func() {
+		viper.Set("nginx.template", "")
+		viper.Set("nginx.templates", nil)
+	})
+}
+
+func TestLabelHandlerPutWithTemplates(t *testing.T) {
+	setTemplates(t, writeTemplate(t, "# global {{.Label}} {{.RootPath}}\n"), []map[string]string{{
+		"labels": "preview-*",
+		"file":   writeTemplate(t, "# preview {{.ServerName}} {{.Distro.FileCount}} {{.Annotations.owner}}{{.Annotations.missing}}\n"),
+	}})
+	app, err := NewApp(200, storage.FileSystem)
+	assert.Nil(t, err)
+	fileHash := putText(t, app, "templated file")
+	_, status, hash, err := utils.DoRequest(app, "PUT", "/distro", fmt.Sprintf("index.html:%s", fileHash))
+	assert.NoError(t, err)
+	assert.Equal(t, 200, status)
+	suffix := strings.ToLower(utils.RandString(8))
+
+	for _, label := range []string{"preview-" + suffix, "main-" + suffix} {
+		_, status, _, err := utils.DoRequestWithHeaders(app, "PUT", "/label", fmt.Sprintf(`{"label":"%s","hash":"%s","annotations":{"owner":"team-a"}}`, label, hash), map[string]string{
+			"Content-Type": "application/json",
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, 200, status)
+	}
+
+	dat, err := ioutil.ReadFile(path.Join(viper.GetString("storage.sitesPath"), fmt.Sprintf("preview-%s.conf", suffix)))
+	assert.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("# preview preview-%s. 1 team-a\n", suffix), string(dat))
+	dat, err = ioutil.ReadFile(path.Join(viper.GetString("storage.sitesPath"), fmt.Sprintf("main-%s.conf", suffix)))
+	assert.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("# global main-%s /app/sites/%s\n", suffix, hash), string(dat))
+	info, err := app.Storage.GetLabelInfo("main-" + suffix)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"owner": "team-a"}, info.Annotations)
+}
+
+func TestLabelHandlerPutWithFailingTemplate(t *testing.T) {
+	setTemplates(t, writeTemplate(t, "# {{.Hash}}{{if .Annotations.broken}}{{.Hash.Field}}{{end}}\n"), nil)
+	app, err := NewApp(200, storage.FileSystem)
+	assert.Nil(t, err)
+	label := fmt.Sprintf("failing-%s", strings.ToLower(utils.RandString(8)))
+	hash := fmt.Sprintf("%x", utils.Hash("working"))
+	putLabel(t, app, label, hash, time.Now())
+	storeDistro(t, app, fmt.Sprintf("%x", utils.Hash("broken")))
+	confPath := path.Join(viper.GetString("storage.sitesPath"), fmt.Sprintf("%s.conf", label))
+
+	_, status, body, err := utils.DoRequestWithHeaders(app, "PUT", "/label", fmt.Sprintf(`{"label":"%s","hash":"%x","annotations":{"broken":"yes"}}`, label, utils.Hash("broken")), map[string]string{
+		"Content-Type": "application/json",
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 500, status)
+	assertErrorBody(t, body, CodeInternal)
+	dat, err := ioutil.ReadFile(confPath)
+	assert.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("# %s\n", hash), string(dat))
+	info, err := app.Storage.GetLabelInfo(label)
+	assert.NoError(t, err)
+	assert.Equal(t, hash, info.Hash)
+	assert.Empty(t, info.Annotations)
+}
+
+func TestLabelHandlerPutWithInvalidAnnotations(t *testing.T) {
+	setTemplates(t, writeTemplate(t, "# {{.Hash}} {{.Annotations.owner}}\n"), nil)
+	app, err := NewApp(200, storage.FileSystem)
+	assert.Nil(t, err)
+	label := fmt.Sprintf("annotated-%s", strings.ToLower(utils.RandString(8)))
+	hash := fmt.Sprintf("%x", utils.Hash("annotated"))
+	putLabel(t, app, label, hash, time.Now())
+	confPath := path.Join(viper.GetString("storage.sitesPath"), fmt.Sprintf("%s.conf", label))
+
+	for _, annotations := range []map[string]string{
+		{"owner": "x; } server { listen 80; root /; } server {"},
+		{"owner": "$request_uri"},
+		{"owner": "line\nbreak"},
+		{"owner": `"quoted"`},
+		{"": "empty key"},
+		{"bad key": "value"},
+	} {
+		body, err := json.Marshal(map[string]interface{}{"label": label, "hash": hash, "annotations": annotations})
+		assert.NoError(t, err)
+		_, status, res, err := utils.DoRequestWithHeaders(app, "PUT", "/label", string(body), map[string]string{
+			"Content-Type": "application/json",
+		})
+
+		assert.NoError(t, err)
+		assert.Equal(t, 400, status, "%v", annotations)
+		assertErrorBody(t, res, CodeInvalidInput)
+	}
+	dat, err := ioutil.ReadFile(confPath)
+	assert.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("# %s \n", hash), string(dat))
+}
+
+func TestNewAppWithInvalidTemplate(t *testing.T) {
+	for _, tmpl := range []string{"{{.Label", "{{.Unknown}}", "{{if false}}x{{end}}"} {
+		setTemplates(t, writeTemplate(t, tmpl), nil)
+
+		_, err := NewApp(200, storage.FileSystem)
+
+		assert.Error(t, err, tmpl)
+	}
+	setTemplates(t, "", []map[string]string{{"labels": "[", "file": writeTemplate(t, "# {{.Label}}")}})
+
+	_, err := NewApp(200, storage.FileSystem)
+
+	assert.Error(t, err)
+}
+
+func TestLabelHandlerPutWithCachePolicy(t *testing.T) {
+	viper.Set("cache.labels", []map[string]interface{}{{
+		"labels": "cached-*",
+		"rules":  []map[string]string{{"path": "/search/**", "cacheControl": "no-store"}},
+	}})
+	t.Cleanup(func() { viper.Set("cache.labels", nil) })
+	app, err := NewApp(200, storage.FileSystem)
+	assert.Nil(t, err)
+	manifest := putText(t, app, fmt.Sprintf(`{"cache":[{"path":"/sw.js","cacheControl":"no-cache, %s"}]}`, utils.RandString(8)))
+	_, status, hash, err := utils.DoRequest(app, "PUT", "/distro", fmt.Sprintf("hyper-cas.json:%s", manifest))
+	assert.NoError(t, err)
+	assert.Equal(t, 200, status)
+	suffix := strings.ToLower(utils.RandString(8))
+
+	putLabel(t, app, "cached-"+suffix, hash, time.Now())
+	putLabel(t, app, "other-"+suffix, hash, time.Now())
+
+	dat, err := ioutil.ReadFile(path.Join(viper.GetString("storage.sitesPath"), fmt.Sprintf("cached-%s.conf", suffix)))
+	assert.NoError(t, err)
+	conf := string(dat)
+	assert.Contains(t, conf, "add_header Cache-Control $hypercas_")
+	search := strings.Index(conf, `"~^/search/.*$" "no-store";`)
+	sw := strings.Index(conf, `"~^/sw\.js$" "no-cache, `)
+	html := strings.Index(conf, `"~(^|/)[^/]*\.html$" "no-cache";`)
+	hashed := strings.Index(conf, `" "public, max-age=31536000, immutable";`)
+	assert.True(t, search >= 0 && search < sw && sw < html && html < hashed, conf)
+	hashedRegexp := regexp.MustCompile(conf[strings.LastIndex(conf[:hashed], `"~`)+2 : hashed])
+	for _, name := range []string{"app.3f2a9c1b.js", "main-5d41402abc4b2a76.css", "chunk.0123456a.js"} {
+		assert.True(t, hashedRegexp.MatchString("/assets/"+name), name)
+	}
+	for _, name := range []string{"report-20241019.pdf", "backup.20240101.tar", "app.3f2a9c.js"} {
+		assert.False(t, hashedRegexp.MatchString("/files/"+name), name)
+	}
+
+	dat, err = ioutil.ReadFile(path.Join(viper.GetString("storage.sitesPath"), fmt.Sprintf("other-%s.conf", suffix)))
+	assert.NoError(t, err)
+	assert.NotContains(t, string(dat), "no-store")
+	assert.Contains(t, string(dat), `"~^/sw\.js$"`)
+}
+
+func TestNewAppWithInvalidCachePolicy(t *testing.T) {
+	t.Cleanup(func() {
+		viper.Set("cache.rules", nil)
+		viper.Set("cache.labels", nil)
+	})
+	for _, rules := range []interface{}{
+		[]map[string]string{{"path": "*.html"}},
+		[]map[string]string{{"path": "*.html", "cacheControl": `"quoted"`}},
+		"not a list",
+	} {
+		viper.Set("cache.rules", rules)
+
+		_, err := NewApp(200, storage.FileSystem)
+
+		assert.Error(t, err, rules)
+	}
+	viper.Set("cache.rules", nil)
+	viper.Set("cache.labels", []map[string]interface{}{{"labels": "[", "rules": []map[string]string{}}})
+
+	_, err := NewApp(200, storage.FileSystem)
+
+	assert.Error(t, err)
+}
+
+func putDomains(t *testing.T, app *App, label, body string) (int, string) {
+	_, status, res, err := utils.DoRequest(app, "PUT", fmt.Sprintf("/label/%s/domains", label), body)
+	assert.NoError(t, err)
+	return status, res
+}
+
+func TestLabelHandlerDomains(t *testing.T) {
+	app, err := NewApp(200, storage.FileSystem)
+	assert.Nil(t, err)
+	suffix := strings.ToLower(utils.RandString(8))
+	label, other := "domains-"+suffix, "other-"+suffix
+	hash := fmt.Sprintf("%x", utils.Hash("domains"))
+	putLabel(t, app, label, hash, time.Now())
+	putLabel(t, app, other, hash, time.Now())
+	domain := fmt.Sprintf("site-%s.example.com", suffix)
+
+	status, body := putDomains(t, app, label, fmt.Sprintf(`{"domains":["WWW.%s.","%s","www.%s"]}`, domain, domain, domain))
+
+	assert.Equal(t, 200, status)
+	assert.JSONEq(t, fmt.Sprintf(`{"label":"%s","domains":["%s","www.%s"]}`, label, domain, domain), body)
+	dat, err := ioutil.ReadFile(path.Join(viper.GetString("storage.sitesPath"), fmt.Sprintf("%s.conf", label)))
+	assert.NoError(t, err)
+	assert.Contains(t, string(dat), fmt.Sprintf("server_name %s. %s www.%s;", label, domain, domain))
+	_, status, body, err = utils.DoRequest(app, "GET", fmt.Sprintf("/label/%s/domains", label), "")
+	assert.NoError(t, err)
+	assert.Equal(t, 200, status)
+	assert.JSONEq(t, fmt.Sprintf(`{"label":"%s","domains":["%s","www.%s"]}`, label, domain, domain), body)
+	info, err := app.Storage.GetLabelInfo(label)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{domain, "www." + domain}, info.Domains)
+
+	status, body = putDomains(t, app, other, fmt.Sprintf(`{"domains":["%s"]}`, domain))
+	assert.Equal(t, 409, status)
+	errRes := assertErrorBody(t, body, CodeConflict)
+	assert.Contains(t, errRes.Message, label)
+	for _, invalid := range []string{`{"domains":["not a domain"]}`, `{"domains":["-bad.com"]}`, `{}`, `[]`} {
+		status, body = putDomains(t, app, other, invalid)
+		assert.Equal(t, 400, status, invalid)
+		assertErrorBody(t, body, CodeInvalidInput)
+	}
+	status, _ = putDomains(t, app, "missing-"+suffix, `{"domains":[]}`)
+	assert.Equal(t, 404, status)
+
+	// Domains removed from a label or from deleted labels can be claimed again
+	status, _ = putDomains(t, app, label, fmt.Sprintf(`{"domains":["%s"]}`, domain))
+	assert.Equal(t, 200, status)
+	status, _ = putDomains(t, app, other, fmt.Sprintf(`{"domains":["www.%s"]}`, domain))
+	assert.Equal(t, 200, status)
+	_, status, _, err = utils.DoRequest(app, "DELETE", fmt.Sprintf("/label/%s", label), "")
+	assert.NoError(t, err)
+	assert.Equal(t, 200, status)
+	status, body = putDomains(t, app, other, fmt.Sprintf(`{"domains":["%s","www.%s"]}`, domain, domain))
+	assert.Equal(t, 200, status)
+	assert.JSONEq(t, fmt.Sprintf(`{"label":"%s","domains":["%s","www.%s"]}`, other, domain, domain), body)
+}
+
+func TestLabelHandlerDomainsConflictWithHosts(t *testing.T) {
+	viper.Set("nginx.serverName", "hyper-cas.org")
+	t.Cleanup(func() { viper.Set("nginx.serverName", "") })
+	app, err := NewApp(200, storage.FileSystem)
+	assert.Nil(t, err)
+	suffix := strings.ToLower(utils.RandString(8))
+	label, other := "hosted-"+suffix, "other-"+suffix
+	hash := fmt.Sprintf("%x", utils.Hash("hosts"))
+	putLabel(t, app, label, hash, time.Now())
+	putLabel(t, app, other, hash, time.Now())
+
+	// The host of another label can't be claimed as a domain
+	status, body := putDomains(t, app, other, fmt.Sprintf(`{"domains":["%s.hyper-cas.org"]}`, label))
+	assert.Equal(t, 409, status)
+	errRes := assertErrorBody(t, body, CodeConflict)
+	assert.Contains(t, errRes.Message, label)
+
+	// And labels can't be created with the host of a domain of another label
+	created := "created-" + suffix
+	status, _ = putDomains(t, app, other, fmt.Sprintf(`{"domains":["%s.hyper-cas.org"]}`, created))
+	assert.Equal(t, 200, status)
+	_, status, body, err = utils.DoRequest(app, "PUT", "/label", fmt.Sprintf("label=%s&hash=%s", created, hash))
+	assert.NoError(t, err)
+	assert.Equal(t, 409, status)
+	errRes = assertErrorBody(t, body, CodeConflict)
+	assert.Contains(t, errRes.Message, other)
+	assert.False(t, app.Storage.HasLabel(created))
+}
+
+func TestLabelHandlerPutWithNginxMap(t *testing.T) {
+	confName := fmt.Sprintf("map-%s.conf", utils.RandString(8))
+	viper.Set("nginx.mode", "map")
+	viper.Set("nginx.serverName", "hyper-cas.org")
+	viper.Set("nginx.mapConfigPath", confName)
+	t.Cleanup(func() {
+		viper.Set("nginx.mode", "server")
+		viper.Set("nginx.serverName", "")
+	})
+	app, err := NewApp(200, storage.FileSystem)
+	assert.Nil(t, err)
+	suffix := strings.ToLower(utils.RandString(8))
+	preview, main := "preview-"+suffix, "main-"+suffix
+	hash := fmt.Sprintf("%x", utils.Hash("map"))
+
+	putLabel(t, app, preview, hash, time.Now())
+	putLabel(t, app, main, hash, time.Now())
+	status, _ := putDomains(t, app, main, fmt.Sprintf(`{"domains":["%s.example.com"]}`, suffix))
+	assert.Equal(t, 200, status)
+
+	confPath := path.Join(viper.GetString("storage.sitesPath"), confName)
+	dat, err := ioutil.ReadFile(confPath)
+	assert.NoError(t, err)
+	conf := string(dat)
+	assert.Contains(t, conf, "map $host $hyper_cas_root {")
+	assert.Contains(t, conf, fmt.Sprintf(`"%s.hyper-cas.org" "/app/sites/%s";`, preview, hash))
+	assert.Contains(t, conf, fmt.Sprintf(`"%s.example.com" "/app/sites/%s";`, suffix, hash))
+	assert.Contains(t, conf, fmt.Sprintf(`"%s.example.com" "%s";`, suffix, main))
+	assert.Contains(t, conf, "root $hyper_cas_root;")
+	assert.Contains(t, conf, `"~(^|/)[^/]*\.html$" "no-cache";`)
+	assert.Equal(t, 1, strings.Count(conf, "server {"))
+	assert.False(t, utils.FileExists(path.Join(viper.GetString("storage.sitesPath"), fmt.Sprintf("%s.conf", preview))))
+
+	_, status, _, err = utils.DoRequest(app, "DELETE", fmt.Sprintf("/label/%s", preview), "")
+	assert.NoError(t, err)
+	assert.Equal(t, 200, status)
+	dat, err = ioutil.ReadFile(confPath)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(dat), preview)
+	assert.Contains(t, string(dat), main)
+}
@@ -0,0 +1,31 @@
+package serve
+
+import (
+	"strconv"
+
+	"github.com/valyala/fasthttp"
+	"github.com/vtex/hyper-cas/storage"
+)
+
+const (
+	defaultListLimit = 100
+	maxListLimit     = 1000
+)
+
+func parseListOptions(ctx *fasthttp.RequestCtx) (storage.ListOptions, error) {
+	args := ctx.QueryArgs()
+	options := storage.ListOptions{
+		Prefix: string(args.Peek("prefix")),
+		Cursor: string(args.Peek("cursor")),
+		SortBy: string(args.Peek("sort")),
+		Limit:  defaultListLimit,
+	}
+	if limit := args.Peek("limit"); len(limit) > 0 {
+		value, err := strconv.Atoi(string(limit))
+		if err != nil || value < 1 || value > maxListLimit {
+			return options, invalidInput("invalid limit '%s' (expected 1 to %d)", limit, maxListLimit)
+		}
+		options.Limit = value
+	}
+	return options, storage.ValidateListOptions(options)
+}
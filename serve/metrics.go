@@ -0,0 +1,78 @@
+package serve
+
+import (
+	"strconv"
+	"time"
+
+	router "github.com/fasthttp/router"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/spf13/viper"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttpadaptor"
+	"github.com/vtex/hyper-cas/metrics"
+	"github.com/vtex/hyper-cas/utils"
+	"go.uber.org/zap"
+)
+
+func metricsHandler() fasthttp.RequestHandler {
+	return fasthttpadaptor.NewFastHTTPHandler(promhttp.Handler())
+}
+
+// Instrument requests to handler with per-route count and latency metrics
+func (app *App) Instrument(handler fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		start := time.Now()
+		handler(ctx)
+
+		route, ok := ctx.UserValue(router.MatchedRoutePathParam).(string)
+		if !ok {
+			route = "unmatched"
+		}
+		method := string(ctx.Method())
+		metrics.RequestsTotal.WithLabelValues(method, route, strconv.Itoa(ctx.Response.StatusCode())).Inc()
+		metrics.RequestDuration.WithLabelValues(method, route).Observe(time.Since(start).Seconds())
+	}
+}
+
+func (app *App) refreshStorageMetrics() {
+	stats, err := app.Storage.Stats()
+	if err != nil {
+		utils.LogError("Failed to refresh storage metrics.", zap.Error(err))
+		return
+	}
+	metrics.BlobCount.Set(float64(stats.FileCount))
+	metrics.BlobBytes.Set(float64(stats.FileBytes))
+}
+
+// StartStorageMetrics refreshes the storage gauges every `metrics.storageRefreshIntervalMs`
+func (app *App) StartStorageMetrics() {
+	viper.SetDefault("metrics.storageRefreshIntervalMs", 60000)
+	interval := time.Duration(viper.GetInt("metrics.storageRefreshIntervalMs")) * time.Millisecond
+
+	app.metricsStop = make(chan struct{})
+	app.metricsDone = make(chan struct{})
+	go func() {
+		defer close(app.metricsDone)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			app.refreshStorageMetrics()
+			select {
+			case <-app.metricsStop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// StopStorageMetrics waits for the current refresh of the storage gauges to
+// finish and stops refreshing them
+func (app *App) StopStorageMetrics() {
+	if app.metricsStop == nil {
+		return
+	}
+	close(app.metricsStop)
+	<-app.metricsDone
+	app.metricsStop = nil
+}
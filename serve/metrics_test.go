@@ -0,0 +1,73 @@
+package serve
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vtex/hyper-cas/storage"
+	"github.com/vtex/hyper-cas/utils"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	app, err := NewApp(200, storage.FileSystem)
+	assert.Nil(t, err)
+	_, status, _, err := utils.DoRequest(app, "GET", "/healthcheck", "")
+	assert.NoError(t, err)
+	assert.Equal(t, 200, status)
+
+	_, status, body, err := utils.DoRequest(app, "GET", "/metrics", "")
+
+	assert.NoError(t, err)
+	assert.Equal(t, 200, status)
+	assert.Contains(t, body, `hypercas_http_requests_total{method="GET",route="/healthcheck",status="200"}`)
+	assert.Contains(t, body, "hypercas_lock_timeouts_total")
+}
+
+func TestRefreshStorageMetrics(t *testing.T) {
+	app, err := NewApp(200, storage.FileSystem)
+	assert.Nil(t, err)
+	_, status, _, err := utils.DoRequest(app, "PUT", "/file", "some text for metrics")
+	assert.NoError(t, err)
+	assert.Equal(t, 200, status)
+
+	app.refreshStorageMetrics()
+
+	_, status, body, err := utils.DoRequest(app, "GET", "/metrics", "")
+	assert.NoError(t, err)
+	assert.Equal(t, 200, status)
+	assert.Contains(t, body, "hypercas_storage_blobs ")
+	assert.NotContains(t, body, "hypercas_storage_blobs 0\n")
+}
+
+func TestStopStorageMetrics(t *testing.T) {
+	app, err := NewApp(200, storage.FileSystem)
+	assert.Nil(t, err)
+	app.StopStorageMetrics()
+
+	app.StartStorageMetrics()
+	app.StopStorageMetrics()
+
+	select {
+	case <-app.metricsDone:
+	default:
+		assert.Fail(t, "Storage metrics should not be refreshed after they are stopped")
+	}
+}
+
+func TestDuplicateFilesStoredMetric(t *testing.T) {
+	app, err := NewApp(200, storage.FileSystem)
+	assert.Nil(t, err)
+	text := "duplicate text for metrics " + utils.RandString(8)
+	for i := 0; i < 2; i++ {
+		_, status, _, err := utils.DoRequest(app, "PUT", "/file", text)
+		assert.NoError(t, err)
+		assert.Equal(t, 200, status)
+	}
+
+	_, status, body, err := utils.DoRequest(app, "GET", "/metrics", "")
+
+	assert.NoError(t, err)
+	assert.Equal(t, 200, status)
+	assert.Contains(t, body, "hypercas_stored_duplicate_files_total ")
+	assert.NotContains(t, body, "hypercas_stored_duplicate_files_total 0\n")
+}
@@ -0,0 +1,72 @@
+package serve
+
+import (
+	"regexp"
+	"time"
+
+	router "github.com/fasthttp/router"
+	"github.com/valyala/fasthttp"
+	"github.com/vtex/hyper-cas/utils"
+	"go.uber.org/zap"
+)
+
+// RequestIDHeader carries the ID used to correlate logs of a request
+const RequestIDHeader = "X-Request-Id"
+
+const (
+	requestIDUserValue = "hyper-cas-request-id"
+	loggerUserValue    = "hyper-cas-logger"
+)
+
+var validRequestID = regexp.MustCompile(`^[a-zA-Z0-9._:/-]{1,128}$`)
+
+// WithRequestID takes the request ID from the X-Request-Id header (or
+// generates one), exposes a logger with it to handlers and writes an
+// access log line once the request is served
+func (app *App) WithRequestID(handler fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		start := time.Now()
+		requestID := string(ctx.Request.Header.Peek(RequestIDHeader))
+		if !validRequestID.MatchString(requestID) {
+			requestID = utils.NewID()
+		}
+		logger := utils.LoggerWith(zap.String("requestId", requestID))
+		ctx.SetUserValue(requestIDUserValue, requestID)
+		ctx.SetUserValue(loggerUserValue, logger)
+		ctx.Response.Header.Set(RequestIDHeader, requestID)
+
+		handler(ctx)
+
+		route, _ := ctx.UserValue(router.MatchedRoutePathParam).(string)
+		responseBytes := ctx.Response.Header.ContentLength()
+		if !ctx.Response.IsBodyStream() {
+			responseBytes = len(ctx.Response.Body())
+		}
+		logger.Info(
+			"Request served.",
+			zap.ByteString("method", ctx.Method()),
+			zap.ByteString("path", ctx.Path()),
+			zap.String("route", route),
+			zap.Int("status", ctx.Response.StatusCode()),
+			zap.Int("requestBytes", len(ctx.Request.Body())),
+			zap.Int("responseBytes", responseBytes),
+			zap.Duration("duration", time.Since(start)),
+			zap.String("remoteIP", ctx.RemoteIP().String()),
+			zap.ByteString("userAgent", ctx.UserAgent()),
+		)
+	}
+}
+
+// RequestID of the request being served
+func RequestID(ctx *fasthttp.RequestCtx) string {
+	requestID, _ := ctx.UserValue(requestIDUserValue).(string)
+	return requestID
+}
+
+// Logger for the request being served, with its request ID
+func Logger(ctx *fasthttp.RequestCtx) *zap.Logger {
+	if logger, ok := ctx.UserValue(loggerUserValue).(*zap.Logger); ok {
+		return logger
+	}
+	return utils.LoggerInstance()
+}
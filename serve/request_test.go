@@ -0,0 +1,42 @@
+package serve
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vtex/hyper-cas/storage"
+	"github.com/vtex/hyper-cas/utils"
+)
+
+func TestRequestIDIsGenerated(t *testing.T) {
+	app, err := NewApp(200, storage.FileSystem)
+	assert.Nil(t, err)
+
+	res, status, _, err := utils.DoRequest(app, "GET", "/healthcheck", "")
+
+	assert.NoError(t, err)
+	assert.Equal(t, 200, status)
+	assert.Len(t, res.Header.Get(RequestIDHeader), 32)
+}
+
+func TestRequestIDIsKept(t *testing.T) {
+	app, err := NewApp(200, storage.FileSystem)
+	assert.Nil(t, err)
+
+	res, status, _, err := utils.DoRequestWithHeaders(app, "GET", "/healthcheck", "", map[string]string{RequestIDHeader: "sync-run-1"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 200, status)
+	assert.Equal(t, "sync-run-1", res.Header.Get(RequestIDHeader))
+}
+
+func TestInvalidRequestIDIsReplaced(t *testing.T) {
+	app, err := NewApp(200, storage.FileSystem)
+	assert.Nil(t, err)
+
+	res, status, _, err := utils.DoRequestWithHeaders(app, "GET", "/healthcheck", "", map[string]string{RequestIDHeader: "invalid id with spaces"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 200, status)
+	assert.Len(t, res.Header.Get(RequestIDHeader), 32)
+}
@@ -0,0 +1,149 @@
+package serve
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/vtex/hyper-cas/utils"
+	"go.uber.org/zap"
+)
+
+// certReloader serves the certificates configured in `serve.tls`, reloading
+// them from disk whenever the files change
+type certReloader struct {
+	certFile          string
+	keyFile           string
+	clientCAFile      string
+	requireClientCert bool
+	checkInterval     time.Duration
+
+	lock      sync.RWMutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+	modTimes  map[string]time.Time
+	lastCheck time.Time
+}
+
+// tlsEnabled returns whether TLS files are configured for the API
+func tlsEnabled() bool {
+	return viper.GetString("serve.tls.certFile") != "" && viper.GetString("serve.tls.keyFile") != ""
+}
+
+func newCertReloader() (*certReloader, error) {
+	viper.SetDefault("serve.tls.requireClientCert", false)
+	viper.SetDefault("serve.tls.reloadIntervalMs", 10000)
+
+	reloader := &certReloader{
+		certFile:          viper.GetString("serve.tls.certFile"),
+		keyFile:           viper.GetString("serve.tls.keyFile"),
+		clientCAFile:      viper.GetString("serve.tls.clientCAFile"),
+		requireClientCert: viper.GetBool("serve.tls.requireClientCert"),
+		checkInterval:     time.Duration(viper.GetInt("serve.tls.reloadIntervalMs")) * time.Millisecond,
+	}
+	err := reloader.load()
+	if err != nil {
+		return nil, err
+	}
+	return reloader, nil
+}
+
+func (r *certReloader) files() []string {
+	files := []string{r.certFile, r.keyFile}
+	if r.clientCAFile != "" {
+		files = append(files, r.clientCAFile)
+	}
+	return files
+}
+
+func (r *certReloader) currentModTimes() map[string]time.Time {
+	modTimes := map[string]time.Time{}
+	for _, file := range r.files() {
+		if info, err := os.Stat(file); err == nil {
+			modTimes[file] = info.ModTime()
+		}
+	}
+	return modTimes
+}
+
+func (r *certReloader) load() error {
+	modTimes := r.currentModTimes()
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	var clientCAs *x509.CertPool
+	if r.clientCAFile != "" {
+		clientCAs, err = utils.LoadCertPool(r.clientCAFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.cert = &cert
+	r.clientCAs = clientCAs
+	r.modTimes = modTimes
+	return nil
+}
+
+func (r *certReloader) changed() bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if time.Since(r.lastCheck) < r.checkInterval {
+		return false
+	}
+	r.lastCheck = time.Now()
+
+	modTimes := r.currentModTimes()
+	for file, modTime := range modTimes {
+		if !modTime.Equal(r.modTimes[file]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *certReloader) reloadIfChanged() {
+	if !r.changed() {
+		return
+	}
+	err := r.load()
+	if err != nil {
+		utils.LogError("Failed to reload TLS certificates. Keeping previous certificates...", zap.Error(err))
+		return
+	}
+	utils.LogInfo("TLS certificates reloaded.", zap.Strings("files", r.files()))
+}
+
+func (r *certReloader) configForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	r.reloadIfChanged()
+
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	config := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{*r.cert},
+		ClientCAs:    r.clientCAs,
+		ClientAuth:   tls.NoClientCert,
+	}
+	if r.clientCAs != nil {
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+		if r.requireClientCert {
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+	return config, nil
+}
+
+// TLSConfig that picks up certificate changes on new connections
+func (r *certReloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		GetConfigForClient: r.configForClient,
+	}
+}
@@ -0,0 +1,164 @@
+package serve
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCert(t *testing.T, name string, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	parentCert, parentKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+	} else {
+		parentCert, parentKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parentCert, &key.PublicKey, parentKey)
+	assert.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+	return &testCert{cert: cert, key: key}
+}
+
+func (c *testCert) write(t *testing.T, certFile, keyFile string) {
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw})
+	assert.NoError(t, ioutil.WriteFile(certFile, certPEM, 0644))
+	if keyFile == "" {
+		return
+	}
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	assert.NoError(t, err)
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	assert.NoError(t, ioutil.WriteFile(keyFile, keyPEM, 0600))
+}
+
+func (c *testCert) tlsCert() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.cert.Raw}, PrivateKey: c.key}
+}
+
+func serveTLS(t *testing.T, reloader *certReloader) (string, func()) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	assert.NoError(t, err)
+	s := &fasthttp.Server{Handler: func(ctx *fasthttp.RequestCtx) { ctx.SetBodyString("OK") }}
+	go s.Serve(tls.NewListener(ln, reloader.TLSConfig()))
+	return ln.Addr().String(), func() { s.Shutdown() }
+}
+
+func peerCommonName(t *testing.T, addr string, config *tls.Config) (string, error) {
+	conn, err := tls.Dial("tcp4", addr, config)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	if err != nil {
+		return "", err
+	}
+	_, err = conn.Read(make([]byte, 1))
+	if err != nil {
+		return "", err
+	}
+	return conn.ConnectionState().PeerCertificates[0].Subject.CommonName, nil
+}
+
+func setupTLSFiles(t *testing.T) (string, *testCert) {
+	dir, err := ioutil.TempDir("", "hyper-cas-tls")
+	assert.NoError(t, err)
+	ca := newTestCert(t, "ca", nil)
+	ca.write(t, path.Join(dir, "ca.pem"), "")
+	newTestCert(t, "server-1", ca).write(t, path.Join(dir, "cert.pem"), path.Join(dir, "key.pem"))
+
+	viper.Set("serve.tls.certFile", path.Join(dir, "cert.pem"))
+	viper.Set("serve.tls.keyFile", path.Join(dir, "key.pem"))
+	viper.Set("serve.tls.clientCAFile", path.Join(dir, "ca.pem"))
+	viper.Set("serve.tls.reloadIntervalMs", 0)
+	return dir, ca
+}
+
+func resetTLSConfig() {
+	viper.Set("serve.tls.certFile", "")
+	viper.Set("serve.tls.keyFile", "")
+	viper.Set("serve.tls.clientCAFile", "")
+	viper.Set("serve.tls.requireClientCert", false)
+}
+
+func TestTLSReloadsCertificates(t *testing.T) {
+	dir, ca := setupTLSFiles(t)
+	defer os.RemoveAll(dir)
+	defer resetTLSConfig()
+	reloader, err := newCertReloader()
+	assert.NoError(t, err)
+	addr, stop := serveTLS(t, reloader)
+	defer stop()
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	config := &tls.Config{RootCAs: roots, ServerName: "localhost"}
+
+	name, err := peerCommonName(t, addr, config)
+	assert.NoError(t, err)
+	assert.Equal(t, "server-1", name)
+
+	newTestCert(t, "server-2", ca).write(t, path.Join(dir, "cert.pem"), path.Join(dir, "key.pem"))
+	later := time.Now().Add(time.Minute)
+	assert.NoError(t, os.Chtimes(path.Join(dir, "cert.pem"), later, later))
+	name, err = peerCommonName(t, addr, config)
+	assert.NoError(t, err)
+	assert.Equal(t, "server-2", name)
+}
+
+func TestTLSRequiresClientCertificate(t *testing.T) {
+	dir, ca := setupTLSFiles(t)
+	defer os.RemoveAll(dir)
+	defer resetTLSConfig()
+	viper.Set("serve.tls.requireClientCert", true)
+	reloader, err := newCertReloader()
+	assert.NoError(t, err)
+	addr, stop := serveTLS(t, reloader)
+	defer stop()
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	_, err = peerCommonName(t, addr, &tls.Config{RootCAs: roots, ServerName: "localhost"})
+	assert.Error(t, err)
+
+	client := newTestCert(t, "client", ca)
+	name, err := peerCommonName(t, addr, &tls.Config{
+		RootCAs:      roots,
+		ServerName:   "localhost",
+		Certificates: []tls.Certificate{client.tlsCert()},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "server-1", name)
+}
@@ -0,0 +1,337 @@
+package serve
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/valyala/fasthttp"
+	"github.com/vtex/hyper-cas/storage"
+	"github.com/vtex/hyper-cas/utils"
+	"go.uber.org/zap"
+)
+
+// watcher is woken up whenever a label it follows changes in this instance.
+// Changes made by other instances are found by the hub, which checks storage
+// every `serve.watchPollIntervalMs` while there are watchers.
+type watcher struct {
+	prefix string
+	exact  bool
+	wake   chan struct{}
+}
+
+func (w *watcher) follows(label string) bool {
+	if w.exact {
+		return label == w.prefix
+	}
+	return strings.HasPrefix(label, w.prefix)
+}
+
+// watchHub keeps the watchers of label changes
+type watchHub struct {
+	lock      sync.Mutex
+	watchers  map[*watcher]struct{}
+	closed    chan struct{}
+	once      sync.Once
+	revisions func() (map[string]int64, error)
+	interval  time.Duration
+	polling   bool
+}
+
+func newWatchHub(revisions func() (map[string]int64, error), interval time.Duration) *watchHub {
+	return &watchHub{
+		watchers:  map[*watcher]struct{}{},
+		closed:    make(chan struct{}),
+		revisions: revisions,
+		interval:  interval,
+	}
+}
+
+func (h *watchHub) handleEvent(event storage.Event) {
+	if event.Label == "" {
+		return
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.wake(event.Label)
+}
+
+// wake the watchers following label. It must be called with the hub locked.
+func (h *watchHub) wake(label string) {
+	for w := range h.watchers {
+		if !w.follows(label) {
+			continue
+		}
+		select {
+		case w.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (h *watchHub) watch(prefix string, exact bool) *watcher {
+	w := &watcher{prefix: prefix, exact: exact, wake: make(chan struct{}, 1)}
+	h.lock.Lock()
+	h.watchers[w] = struct{}{}
+	start := !h.polling
+	h.polling = true
+	h.lock.Unlock()
+	if start {
+		// The revisions are read before the watcher reads the state of its
+		// labels, so no change between both is missed
+		go h.poll(h.readRevisions())
+	}
+	return w
+}
+
+func (h *watchHub) unwatch(w *watcher) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	delete(h.watchers, w)
+}
+
+func (h *watchHub) readRevisions() map[string]int64 {
+	revisions, err := h.revisions()
+	if err != nil {
+		utils.LogError("Failed to read label revisions for watches.", zap.Error(err))
+		return nil
+	}
+	return revisions
+}
+
+// poll storage for label changes made by other instances, waking their
+// watchers. A single poll serves every watcher and stops when none is left.
+func (h *watchHub) poll(known map[string]int64) {
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-h.closed:
+			return
+		case <-ticker.C:
+		}
+		h.lock.Lock()
+		if len(h.watchers) == 0 {
+			h.polling = false
+			h.lock.Unlock()
+			return
+		}
+		h.lock.Unlock()
+
+		revisions := h.readRevisions()
+		if revisions == nil {
+			continue
+		}
+		h.lock.Lock()
+		for label, revision := range revisions {
+			if previous, ok := known[label]; !ok || previous != revision {
+				h.wake(label)
+			}
+		}
+		for label := range known {
+			if _, ok := revisions[label]; !ok {
+				h.wake(label)
+			}
+		}
+		h.lock.Unlock()
+		known = revisions
+	}
+}
+
+// Close ends all the watches, so they don't hold the server on shutdown
+func (h *watchHub) Close() {
+	h.once.Do(func() { close(h.closed) })
+}
+
+func watchDuration(key string) time.Duration {
+	return time.Duration(viper.GetInt(key)) * time.Millisecond
+}
+
+func acceptsEventStream(ctx *fasthttp.RequestCtx) bool {
+	return strings.Contains(string(ctx.Request.Header.Peek("Accept")), "text/event-stream")
+}
+
+// labelStates of the labels matching the watcher, by label
+func (app *App) labelStates(w *watcher) (map[string]*storage.LabelInfo, error) {
+	states := map[string]*storage.LabelInfo{}
+	if w.exact {
+		info, err := app.Storage.GetLabelInfo(w.prefix)
+		if err != nil {
+			if errors.Is(err, storage.ErrNotFound) {
+				return states, nil
+			}
+			return nil, err
+		}
+		states[info.Name] = info
+		return states, nil
+	}
+	list, err := app.Storage.ListLabels(storage.ListOptions{Prefix: w.prefix})
+	if err != nil {
+		return nil, err
+	}
+	for _, info := range list.Labels {
+		states[info.Name] = info
+	}
+	return states, nil
+}
+
+// longPollLabel answers as soon as the revision of the label differs from
+// the revision known by the client (0 if the label does not exist) or with
+// 304 once `timeoutMs` (up to `serve.watchTimeoutMs`) is over
+func (app *App) longPollLabel(ctx *fasthttp.RequestCtx, label string) error {
+	logger := Logger(ctx).With(zap.String("label", label))
+	known := int64(-1)
+	if value := ctx.QueryArgs().Peek("revision"); len(value) > 0 {
+		parsed, err := strconv.ParseInt(string(value), 10, 64)
+		if err != nil {
+			return invalidInput("invalid revision '%s'", value)
+		}
+		known = parsed
+	}
+	timeout := watchDuration("serve.watchTimeoutMs")
+	if value := ctx.QueryArgs().Peek("timeoutMs"); len(value) > 0 {
+		parsed, err := strconv.Atoi(string(value))
+		if err != nil || parsed < 0 {
+			return invalidInput("invalid timeoutMs '%s'", value)
+		}
+		if requested := time.Duration(parsed) * time.Millisecond; requested < timeout {
+			timeout = requested
+		}
+	}
+
+	w := app.watches.watch(label, true)
+	defer app.watches.unwatch(w)
+	deadline := time.After(timeout)
+	for {
+		states, err := app.labelStates(w)
+		if err != nil {
+			logger.Error("Failed to read label while watching it.", zap.Error(err))
+			return err
+		}
+		info, exists := states[label]
+		switch {
+		case exists && info.Revision != known:
+			body, err := json.Marshal(info)
+			if err != nil {
+				return err
+			}
+			ctx.SetContentType("application/json")
+			ctx.SetBody(body)
+			logger.Debug("Label changed while being watched.", zap.Int64("revision", info.Revision))
+			return nil
+		case !exists && known != 0:
+			logger.Debug("Watched label does not exist.")
+			return notFound("Label %s was not found.", label)
+		}
+
+		select {
+		case <-w.wake:
+		case <-deadline:
+			ctx.SetStatusCode(304)
+			return nil
+		case <-app.watches.closed:
+			ctx.SetStatusCode(304)
+			return nil
+		}
+	}
+}
+
+// streamLabels sends server-sent events with the current state of the
+// labels followed by the watcher and then with every change to them
+func (app *App) streamLabels(ctx *fasthttp.RequestCtx, w *watcher) {
+	logger := Logger(ctx).With(zap.String("prefix", w.prefix), zap.Bool("exact", w.exact))
+	ctx.SetContentType("text/event-stream")
+	ctx.Response.Header.Set("Cache-Control", "no-cache")
+	ctx.SetBodyStreamWriter(func(writer *bufio.Writer) {
+		defer app.watches.unwatch(w)
+		keepalive := time.NewTicker(watchDuration("serve.watchKeepaliveMs"))
+		defer keepalive.Stop()
+
+		sent := map[string]*storage.LabelInfo{}
+		for {
+			states, err := app.labelStates(w)
+			if err != nil {
+				logger.Error("Failed to read labels while watching them.", zap.Error(err))
+				return
+			}
+			err = writeLabelEvents(writer, sent, states)
+			if err == nil {
+				err = writer.Flush()
+			}
+			if err != nil {
+				logger.Debug("Label watch closed by the client.", zap.Error(err))
+				return
+			}
+			sent = states
+
+			select {
+			case <-w.wake:
+			case <-keepalive.C:
+				fmt.Fprint(writer, ": keepalive\n\n")
+			case <-app.watches.closed:
+				return
+			}
+		}
+	})
+}
+
+// labelChange is an update or deletion of a watched label
+type labelChange struct {
+	event string
+	info  *storage.LabelInfo
+}
+
+// labelChanges between the states sent to a watcher and the current ones:
+// updated labels sorted by name, followed by deleted labels
+func labelChanges(sent, states map[string]*storage.LabelInfo) []labelChange {
+	changes := []labelChange{}
+	names := []string{}
+	for name := range states {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		info := states[name]
+		if previous, ok := sent[name]; ok && previous.Revision == info.Revision {
+			continue
+		}
+		changes = append(changes, labelChange{storage.EventLabelUpdated, info})
+	}
+	deleted := []string{}
+	for name := range sent {
+		if _, ok := states[name]; !ok {
+			deleted = append(deleted, name)
+		}
+	}
+	sort.Strings(deleted)
+	for _, name := range deleted {
+		changes = append(changes, labelChange{storage.EventLabelDeleted, &storage.LabelInfo{Name: name}})
+	}
+	return changes
+}
+
+func writeLabelEvents(writer *bufio.Writer, sent, states map[string]*storage.LabelInfo) error {
+	for _, change := range labelChanges(sent, states) {
+		err := writeEvent(writer, change.event, change.info.Revision, change.info)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeEvent(writer *bufio.Writer, event string, id int64, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(writer, "id: %d\nevent: %s\ndata: %s\n\n", id, event, data)
+	return err
+}
@@ -0,0 +1,187 @@
+package serve
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+	"github.com/vtex/hyper-cas/storage"
+	"github.com/vtex/hyper-cas/utils"
+)
+
+func getLabelInfo(t *testing.T, app *App, label string) *storage.LabelInfo {
+	info, err := app.Storage.GetLabelInfo(label)
+	assert.NoError(t, err)
+	return info
+}
+
+func TestLabelWatchReturnsChangedLabel(t *testing.T) {
+	app, err := NewApp(200, storage.FileSystem)
+	assert.Nil(t, err)
+	putLabel(t, app, "watched", fmt.Sprintf("%x", utils.Hash("watch-1")), time.Now())
+	known := getLabelInfo(t, app, "watched")
+
+	_, status, body, err := utils.DoRequest(app, "GET", "/label/watched/watch", "")
+	assert.NoError(t, err)
+	assert.Equal(t, 200, status)
+	assert.Contains(t, body, known.Hash)
+
+	type result struct {
+		status int
+		body   string
+	}
+	results := make(chan result, 1)
+	go func() {
+		_, status, body, _ := utils.DoRequest(app, "GET", fmt.Sprintf("/label/watched/watch?revision=%d", known.Revision), "")
+		results <- result{status, body}
+	}()
+	time.Sleep(100 * time.Millisecond)
+	newHash := fmt.Sprintf("%x", utils.Hash("watch-2"))
+	storeDistro(t, app, newHash)
+	assert.NoError(t, app.Storage.StoreLabel("watched", newHash))
+
+	select {
+	case res := <-results:
+		assert.Equal(t, 200, res.status)
+		var info storage.LabelInfo
+		assert.NoError(t, json.Unmarshal([]byte(res.body), &info))
+		assert.Equal(t, newHash, info.Hash)
+		assert.True(t, info.Revision > known.Revision)
+	case <-time.After(2 * time.Second):
+		t.Fatal("The watch did not return after the label changed.")
+	}
+}
+
+func TestLabelWatchTimesOut(t *testing.T) {
+	app, err := NewApp(200, storage.FileSystem)
+	assert.Nil(t, err)
+	putLabel(t, app, "unchanged", fmt.Sprintf("%x", utils.Hash("watch-3")), time.Now())
+	known := getLabelInfo(t, app, "unchanged")
+
+	_, status, _, err := utils.DoRequest(app, "GET", fmt.Sprintf("/label/unchanged/watch?revision=%d&timeoutMs=50", known.Revision), "")
+	assert.NoError(t, err)
+	assert.Equal(t, 304, status)
+
+	_, status, _, err = utils.DoRequest(app, "GET", "/label/never-created/watch?revision=0&timeoutMs=50", "")
+	assert.NoError(t, err)
+	assert.Equal(t, 304, status)
+
+	_, status, _, err = utils.DoRequest(app, "GET", "/label/never-created/watch", "")
+	assert.NoError(t, err)
+	assert.Equal(t, 404, status)
+}
+
+func readEvents(t *testing.T, app *App, url string, count int) []string {
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+	go fasthttp.Serve(ln, app.Handler())
+	defer time.AfterFunc(5*time.Second, app.watches.Close).Stop()
+	client := http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+	}}
+	req, err := http.NewRequest("GET", fmt.Sprintf("http://localhost%s", url), nil)
+	assert.NoError(t, err)
+	req.Header.Set("Accept", "text/event-stream")
+	res, err := client.Do(req)
+	assert.NoError(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+
+	events := []string{}
+	event := ""
+	scanner := bufio.NewScanner(res.Body)
+	for len(events) < count && scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "event: ") {
+			event = strings.TrimPrefix(line, "event: ")
+		}
+		if strings.HasPrefix(line, "data: ") {
+			events = append(events, event+" "+strings.TrimPrefix(line, "data: "))
+		}
+	}
+	return events
+}
+
+func TestLabelWatchStreamsPrefix(t *testing.T) {
+	app, err := NewApp(200, storage.FileSystem)
+	assert.Nil(t, err)
+	prefix := fmt.Sprintf("stream-%s-", strings.ToLower(utils.RandString(8)))
+	putLabel(t, app, prefix+"a", fmt.Sprintf("%x", utils.Hash("stream-1")), time.Now())
+	putLabel(t, app, "not-streamed", fmt.Sprintf("%x", utils.Hash("stream-1")), time.Now())
+	storeDistro(t, app, fmt.Sprintf("%x", utils.Hash("stream-2")))
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		app.Storage.StoreLabel(prefix+"b", fmt.Sprintf("%x", utils.Hash("stream-2")))
+		time.Sleep(100 * time.Millisecond)
+		app.Storage.DeleteLabel(prefix + "a")
+	}()
+	events := readEvents(t, app, "/labels/watch?prefix="+prefix, 3)
+	app.watches.Close()
+
+	assert.Len(t, events, 3)
+	assert.True(t, strings.HasPrefix(events[0], storage.EventLabelUpdated+` {"name":"`+prefix+`a"`), events[0])
+	assert.True(t, strings.HasPrefix(events[1], storage.EventLabelUpdated+` {"name":"`+prefix+`b"`), events[1])
+	assert.True(t, strings.HasPrefix(events[2], storage.EventLabelDeleted+` {"name":"`+prefix+`a"`), events[2])
+}
+
+func TestWatchHubPollsStorage(t *testing.T) {
+	var lock sync.Mutex
+	polls := 0
+	revisions := map[string]int64{"polled-a": 1, "polled-b": 1}
+	hub := newWatchHub(func() (map[string]int64, error) {
+		lock.Lock()
+		defer lock.Unlock()
+		polls++
+		copied := map[string]int64{}
+		for label, revision := range revisions {
+			copied[label] = revision
+		}
+		return copied, nil
+	}, 10*time.Millisecond)
+	defer hub.Close()
+	a := hub.watch("polled-a", true)
+	b := hub.watch("polled-b", true)
+
+	// A label changed by another instance only wakes its watchers
+	lock.Lock()
+	revisions["polled-a"] = 2
+	lock.Unlock()
+	select {
+	case <-a.wake:
+	case <-time.After(time.Second):
+		t.Fatal("The watcher was not woken after its label changed in storage.")
+	}
+	select {
+	case <-b.wake:
+		t.Fatal("The watcher was woken without changes to its label.")
+	default:
+	}
+
+	// The poll stops once there are no watchers
+	hub.unwatch(a)
+	hub.unwatch(b)
+	time.Sleep(50 * time.Millisecond)
+	hub.lock.Lock()
+	assert.False(t, hub.polling)
+	hub.lock.Unlock()
+	lock.Lock()
+	stopped := polls
+	lock.Unlock()
+	time.Sleep(50 * time.Millisecond)
+	lock.Lock()
+	assert.Equal(t, stopped, polls)
+	lock.Unlock()
+}
@@ -0,0 +1,46 @@
+package serve
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/valyala/fasthttp"
+	"github.com/vtex/hyper-cas/webhooks"
+	"go.uber.org/zap"
+)
+
+type WebhookHandler struct {
+	App *App
+}
+
+func NewWebhookHandler(app *App) *WebhookHandler {
+	return &WebhookHandler{App: app}
+}
+
+func (handler *WebhookHandler) handleList(ctx *fasthttp.RequestCtx) error {
+	status := string(ctx.QueryArgs().Peek("status"))
+	if status != "" && status != webhooks.StatusPending && status != webhooks.StatusDelivered && status != webhooks.StatusFailed {
+		return invalidInput("invalid status '%s'", status)
+	}
+	limit := defaultListLimit
+	if value := ctx.QueryArgs().Peek("limit"); len(value) > 0 {
+		parsed, err := strconv.Atoi(string(value))
+		if err != nil || parsed < 1 || parsed > maxListLimit {
+			return invalidInput("invalid limit '%s' (expected 1 to %d)", value, maxListLimit)
+		}
+		limit = parsed
+	}
+
+	deliveries, err := handler.App.webhooks.Deliveries(status, limit)
+	if err != nil {
+		Logger(ctx).Error("Failed to list webhook deliveries.", zap.Error(err))
+		return err
+	}
+	body, err := json.Marshal(deliveries)
+	if err != nil {
+		return err
+	}
+	ctx.SetContentType("application/json")
+	ctx.SetBody(body)
+	return nil
+}
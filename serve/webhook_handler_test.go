@@ -0,0 +1,155 @@
+package serve
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/vtex/hyper-cas/storage"
+	"github.com/vtex/hyper-cas/utils"
+	"github.com/vtex/hyper-cas/webhooks"
+)
+
+type webhookRequest struct {
+	header http.Header
+	body   []byte
+}
+
+// webhookStub records the requests it gets, failing the first `failures`
+type webhookStub struct {
+	*httptest.Server
+	lock     sync.Mutex
+	failures int
+	requests []webhookRequest
+}
+
+func newWebhookStub(failures int) *webhookStub {
+	stub := &webhookStub{failures: failures}
+	stub.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		stub.lock.Lock()
+		defer stub.lock.Unlock()
+		stub.requests = append(stub.requests, webhookRequest{header: r.Header, body: body})
+		if stub.failures > 0 {
+			stub.failures--
+			w.WriteHeader(500)
+		}
+	}))
+	return stub
+}
+
+func newWebhookApp(t *testing.T, stub *webhookStub, events []string) *App {
+	dir, err := ioutil.TempDir("", "hyper-cas-webhooks")
+	assert.NoError(t, err)
+	viper.Set("webhooks.path", dir)
+	viper.Set("webhooks.initialBackoffMs", 0)
+	viper.Set("webhooks.endpoints", []map[string]interface{}{
+		{"name": "stub", "url": stub.URL, "secret": "s3cr3t", "events": events},
+	})
+	t.Cleanup(func() {
+		os.RemoveAll(dir)
+		viper.Set("webhooks.path", nil)
+		viper.Set("webhooks.initialBackoffMs", nil)
+		viper.Set("webhooks.endpoints", nil)
+	})
+	app, err := NewApp(200, storage.FileSystem)
+	assert.NoError(t, err)
+	return app
+}
+
+func listDeliveries(t *testing.T, app *App) []*webhooks.Delivery {
+	_, status, body, err := utils.DoRequest(app, "GET", "/webhooks/deliveries", "")
+	assert.NoError(t, err)
+	assert.Equal(t, 200, status)
+	var deliveries []*webhooks.Delivery
+	assert.NoError(t, json.Unmarshal([]byte(body), &deliveries))
+	return deliveries
+}
+
+func TestWebhookLabelUpdated(t *testing.T) {
+	stub := newWebhookStub(1)
+	defer stub.Close()
+	app := newWebhookApp(t, stub, []string{storage.EventLabelUpdated})
+	oldHash := fmt.Sprintf("%x", utils.Hash("webhook-1"))
+	newHash := fmt.Sprintf("%x", utils.Hash("webhook-2"))
+	label := "webhook-" + strings.ToLower(utils.RandString(8))
+	putLabel(t, app, label, oldHash, time.Now())
+	putLabel(t, app, label, newHash, time.Now())
+
+	assert.Equal(t, 1, app.webhooks.ProcessQueue())
+	assert.Equal(t, 2, app.webhooks.ProcessQueue())
+
+	assert.Len(t, stub.requests, 3)
+	// The first delivery failed, so the second one waited for its retry
+	updated := stub.requests[2]
+	assert.Equal(t, storage.EventLabelUpdated, updated.header.Get(webhooks.EventHeader))
+	assert.Equal(t, webhooks.Sign("s3cr3t", updated.body), updated.header.Get(webhooks.SignatureHeader))
+	var event map[string]interface{}
+	assert.NoError(t, json.Unmarshal(updated.body, &event))
+	assert.Equal(t, label, event["label"])
+	assert.Equal(t, newHash, event["hash"])
+	assert.Equal(t, oldHash, event["oldHash"])
+	assert.Equal(t, updated.header.Get(webhooks.DeliveryHeader), event["id"])
+
+	deliveries := listDeliveries(t, app)
+	assert.Len(t, deliveries, 2)
+	for _, delivery := range deliveries {
+		assert.Equal(t, webhooks.StatusDelivered, delivery.Status)
+	}
+	assert.Equal(t, 1, deliveries[0].Attempts)
+	assert.Equal(t, 2, deliveries[1].Attempts)
+	assert.Equal(t, 0, app.webhooks.ProcessQueue())
+}
+
+func TestWebhookLabelDeletedAndDistroCreated(t *testing.T) {
+	stub := newWebhookStub(0)
+	defer stub.Close()
+	app := newWebhookApp(t, stub, []string{storage.EventLabelDeleted, storage.EventDistroCreated})
+	name := utils.RandString(16)
+	_, status, distro, err := utils.DoRequest(app, "PUT", "/distro", fmt.Sprintf("%s.html:%x", name, utils.Hash(name)))
+	assert.NoError(t, err)
+	assert.Equal(t, 200, status)
+	label := "webhook-deleted-" + strings.ToLower(utils.RandString(8))
+	putLabel(t, app, label, distro, time.Now())
+	_, status, _, err = utils.DoRequest(app, "DELETE", "/label/"+label, "")
+	assert.NoError(t, err)
+	assert.Equal(t, 200, status)
+
+	assert.Equal(t, 2, app.webhooks.ProcessQueue())
+
+	deliveries := listDeliveries(t, app)
+	assert.Len(t, deliveries, 2)
+	assert.Equal(t, storage.EventLabelDeleted, deliveries[0].Event.Type)
+	assert.Equal(t, distro, deliveries[0].Event.OldHash)
+	assert.Equal(t, storage.EventDistroCreated, deliveries[1].Event.Type)
+	assert.Equal(t, distro, deliveries[1].Event.Hash)
+}
+
+func TestWebhookDeliveryGivesUp(t *testing.T) {
+	stub := newWebhookStub(10)
+	defer stub.Close()
+	viper.Set("webhooks.maxAttempts", 2)
+	t.Cleanup(func() { viper.Set("webhooks.maxAttempts", nil) })
+	app := newWebhookApp(t, stub, nil)
+	putLabel(t, app, "webhook-failed-"+strings.ToLower(utils.RandString(8)), fmt.Sprintf("%x", utils.Hash("webhook-3")), time.Now())
+
+	app.webhooks.ProcessQueue()
+	deliveries := listDeliveries(t, app)
+	assert.Equal(t, webhooks.StatusPending, deliveries[0].Status)
+	assert.Equal(t, 500, deliveries[0].LastStatusCode)
+
+	app.webhooks.ProcessQueue()
+	deliveries = listDeliveries(t, app)
+	assert.Equal(t, webhooks.StatusFailed, deliveries[0].Status)
+	assert.Equal(t, 2, deliveries[0].Attempts)
+	assert.Equal(t, 0, app.webhooks.ProcessQueue())
+}
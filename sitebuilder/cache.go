@@ -0,0 +1,202 @@
+package sitebuilder
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// ManifestFile in the root of a distribution with its metadata
+const ManifestFile = "hyper-cas.json"
+
+// hashedFileRegexp matches files with a content hash in their names, such as
+// app.3f2a9c1b.js or main-5d41402abc4b2a76.css. The hash has at least 8 hex
+// digits and one of them is a letter, so dates such as report-20241019.pdf
+// don't match.
+var hashedFileRegexp = func() string {
+	// The first letter of the hash comes after up to 7 digits, with enough
+	// digits after it for at least 8 of them
+	hashes := []string{}
+	for digits := 0; digits < 7; digits++ {
+		hashes = append(hashes, fmt.Sprintf("[0-9]{%d}[a-f][0-9a-f]{%d,}", digits, 7-digits))
+	}
+	hashes = append(hashes, "[0-9]{7,}[a-f][0-9a-f]*")
+	return fmt.Sprintf(`[.-](%s)\.[A-Za-z0-9]+$`, strings.Join(hashes, "|"))
+}()
+
+// Manifest of a distribution
+type Manifest struct {
+	Cache []CacheRule `json:"cache"`
+}
+
+// CacheRule sets the Cache-Control header of the paths matching a glob. Globs
+// with a / match the whole path and globs without one match the file name,
+// with * matching anything but / and ** matching anything.
+type CacheRule struct {
+	Path         string `mapstructure:"path" json:"path"`
+	CacheControl string `mapstructure:"cacheControl" json:"cacheControl"`
+}
+
+// CacheLabelRules are the cache rules of the labels matching a glob
+type CacheLabelRules struct {
+	Labels string      `mapstructure:"labels"`
+	Rules  []CacheRule `mapstructure:"rules"`
+}
+
+// CacheMatch is a cache rule with its path as a regular expression, which
+// is the same for nginx, Caddy, Traefik and Envoy
+type CacheMatch struct {
+	Regexp       string
+	CacheControl string
+}
+
+// FullRegexp matches the whole path instead of its end, for proxies such as
+// Envoy whose regular expressions must match all of it
+func (m CacheMatch) FullRegexp() string {
+	switch {
+	case strings.HasPrefix(m.Regexp, "^"):
+		return m.Regexp
+	case strings.HasPrefix(m.Regexp, "(^|/)"):
+		return "^(.*/)?" + strings.TrimPrefix(m.Regexp, "(^|/)")
+	}
+	return "^.*" + m.Regexp
+}
+
+// Validate the glob and the header value of the rule
+func (r CacheRule) Validate() error {
+	if r.Path == "" || r.CacheControl == "" {
+		return fmt.Errorf("cache rules need a path and a cacheControl")
+	}
+	if strings.ContainsAny(r.Path, " \t") {
+		return fmt.Errorf("path %q has whitespace", r.Path)
+	}
+	if err := validateText(r.Path); err != nil {
+		return err
+	}
+	return validateText(r.CacheControl)
+}
+
+// Regexp matching the paths of the glob
+func (r CacheRule) Regexp() string {
+	var re strings.Builder
+	re.WriteString("(^|/)")
+	glob := r.Path
+	if strings.Contains(glob, "/") {
+		re.Reset()
+		re.WriteString("^/")
+		glob = strings.TrimPrefix(glob, "/")
+	}
+	for i := 0; i < len(glob); i++ {
+		switch {
+		case strings.HasPrefix(glob[i:], "**"):
+			re.WriteString(".*")
+			i++
+		case glob[i] == '*':
+			re.WriteString("[^/]*")
+		case glob[i] == '?':
+			re.WriteString("[^/]")
+		default:
+			re.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+	re.WriteString("$")
+	return re.String()
+}
+
+// ParseManifest of a distribution, validating its cache rules
+func ParseManifest(data []byte) (*Manifest, error) {
+	manifest := &Manifest{}
+	err := json.Unmarshal(data, manifest)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", ManifestFile, err)
+	}
+	for i, rule := range manifest.Cache {
+		if err := rule.Validate(); err != nil {
+			return nil, fmt.Errorf("%s cache rule %d: %w", ManifestFile, i, err)
+		}
+	}
+	return manifest, nil
+}
+
+// CachePolicy decides the Cache-Control header of the paths of a site. The
+// rules of the label come first, then the ones in the manifest of the
+// distribution and then the global ones, and the first matching rule wins.
+// Files with a content hash in their names that match no rule are immutable.
+type CachePolicy struct {
+	rules     []CacheRule
+	labels    []CacheLabelRules
+	immutable string
+}
+
+// NewCachePolicy with the rules in the configuration
+func NewCachePolicy() (*CachePolicy, error) {
+	viper.SetDefault("cache.rules", []map[string]string{{"path": "*.html", "cacheControl": "no-cache"}})
+	viper.SetDefault("cache.immutable", "public, max-age=31536000, immutable")
+
+	policy := &CachePolicy{immutable: viper.GetString("cache.immutable")}
+	err := viper.UnmarshalKey("cache.rules", &policy.rules)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid cache.rules: %w", err)
+	}
+	err = viper.UnmarshalKey("cache.labels", &policy.labels)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid cache.labels: %w", err)
+	}
+	rules := policy.rules
+	for _, labelRules := range policy.labels {
+		if _, err := path.Match(labelRules.Labels, ""); err != nil {
+			return nil, fmt.Errorf("Invalid label glob %q in cache.labels: %w", labelRules.Labels, err)
+		}
+		rules = append(rules, labelRules.Rules...)
+	}
+	for _, rule := range rules {
+		if err := rule.Validate(); err != nil {
+			return nil, fmt.Errorf("Invalid cache rule: %w", err)
+		}
+	}
+	if err := validateText(policy.immutable); err != nil {
+		return nil, fmt.Errorf("Invalid cache.immutable: %w", err)
+	}
+	return policy, nil
+}
+
+// Matches for the paths of a site, in order
+func (p *CachePolicy) Matches(site Site) []CacheMatch {
+	return append(p.SiteMatches(site), p.GlobalMatches()...)
+}
+
+// SiteMatches are the matches of the rules of the label and of the manifest
+// of its distribution, which only apply to a site
+func (p *CachePolicy) SiteMatches(site Site) []CacheMatch {
+	rules := []CacheRule{}
+	for _, labelRules := range p.labels {
+		if matched, err := path.Match(labelRules.Labels, site.Label); err == nil && matched {
+			rules = append(rules, labelRules.Rules...)
+			break
+		}
+	}
+	rules = append(rules, site.Rules.Cache...)
+	return cacheMatches(rules)
+}
+
+// GlobalMatches are the matches that apply to every site, after the ones of
+// the site
+func (p *CachePolicy) GlobalMatches() []CacheMatch {
+	matches := cacheMatches(p.rules)
+	if p.immutable != "" {
+		matches = append(matches, CacheMatch{Regexp: hashedFileRegexp, CacheControl: p.immutable})
+	}
+	return matches
+}
+
+func cacheMatches(rules []CacheRule) []CacheMatch {
+	matches := []CacheMatch{}
+	for _, rule := range rules {
+		matches = append(matches, CacheMatch{Regexp: rule.Regexp(), CacheControl: rule.CacheControl})
+	}
+	return matches
+}
@@ -0,0 +1,170 @@
+package sitebuilder
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/spf13/viper"
+)
+
+type CaddySiteBuilder struct {
+	serverName string
+	sitesPath  string
+	template   *template.Template
+	cache      *CachePolicy
+}
+
+func (sb *CaddySiteBuilder) Generate(site Site) (string, error) {
+	addresses := []string{}
+	for _, host := range append([]string{sb.Host(site.Label)}, site.Domains...) {
+		if !viper.GetBool("caddy.useHTTPS") {
+			host = fmt.Sprintf("http://%s", host)
+		}
+		addresses = append(addresses, host)
+	}
+
+	data := struct {
+		Label    string
+		Hash     string
+		RootPath string
+		Address  string
+		Zstd     bool
+		Rules    string
+	}{
+		Label:    site.Label,
+		Hash:     site.Hash,
+		RootPath: fmt.Sprintf("/app/sites/%s", site.Hash),
+		Address:  strings.Join(addresses, ", "),
+		Zstd:     viper.GetBool("caddy.useZstd"),
+		Rules:    caddyRules(site.Rules, sb.cache.Matches(site)),
+	}
+
+	var tpl bytes.Buffer
+	err := sb.template.Execute(&tpl, data)
+	if err != nil {
+		return "", err
+	}
+	return tpl.String(), nil
+}
+
+func (sb *CaddySiteBuilder) Host(label string) string {
+	return fmt.Sprintf("%s.%s", label, sb.serverName)
+}
+
+func (sb *CaddySiteBuilder) SupportsRules() bool {
+	return true
+}
+
+func (sb *CaddySiteBuilder) Extension() string {
+	return ".caddy"
+}
+
+// caddyRules renders redirects as `redir` and `rewrite` directives and
+// headers as `header` directives, with a path_regexp matcher for each rule.
+// Headers set later replace the ones set before, so header rules are written
+// last to first, after the cache policy.
+func caddyRules(rules Rules, cache []CacheMatch) string {
+	var conf strings.Builder
+	for i := len(cache) - 1; i >= 0; i-- {
+		fmt.Fprintf(&conf, "\t@cache%d path_regexp %s\n\theader @cache%d Cache-Control \"%s\"\n", i, cache[i].Regexp, i, cache[i].CacheControl)
+	}
+	for i := len(rules.Headers) - 1; i >= 0; i-- {
+		rule := rules.Headers[i]
+		pattern, err := ParsePathPattern(rule.Path)
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(&conf, "\t@headers%d path_regexp %s\n\theader @headers%d {\n", i, pattern.Regexp("hc_"), i)
+		for _, header := range rule.Headers {
+			fmt.Fprintf(&conf, "\t\t%s \"%s\"\n", header.Name, header.Value)
+		}
+		conf.WriteString("\t}\n")
+	}
+	for i, redirect := range rules.Redirects {
+		pattern, err := ParsePathPattern(redirect.From)
+		if err != nil {
+			continue
+		}
+		matcher := fmt.Sprintf("redirect%d", i)
+		fmt.Fprintf(&conf, "\t@%s {\n\t\tpath_regexp %s %s\n", matcher, matcher, pattern.Regexp("hc_"))
+		if !redirect.Force {
+			conf.WriteString("\t\tnot file {path} {path}/index.html\n")
+		}
+		conf.WriteString("\t}\n")
+		to := ReplacePlaceholders(redirect.To, func(name string) string {
+			return fmt.Sprintf("{re.%s.hc_%s}", matcher, name)
+		})
+		if redirect.Status == 200 {
+			fmt.Fprintf(&conf, "\trewrite @%s %s\n", matcher, to)
+		} else {
+			fmt.Fprintf(&conf, "\tredir @%s %s %d\n", matcher, to, redirect.Status)
+		}
+	}
+	return conf.String()
+}
+
+func getCaddyfileTemplate() (*template.Template, error) {
+	const tmpl = `
+{{.Address}} {
+	root * {{.RootPath}}
+	header {
+		Hyper-Cas-Label {{.Label}}
+		Hyper-Cas-Hash {{.Hash}}
+		Vary Hyper-Cas-Hash
+	}
+
+	encode {
+		{{- if .Zstd}}
+		zstd
+		{{- end}}
+		gzip 6
+	}
+
+	import {{.RootPath}}/caddy/*.caddy
+
+	@rulesFiles path /_redirects /_headers
+	respond @rulesFiles 404
+{{.Rules}}
+	try_files {path} {path}/ /index.html
+	file_server {
+		index index.html index.htm
+	}
+
+	handle_errors {
+		@notFound expression {http.error.status_code} == 404
+		handle @notFound {
+			rewrite * /404.html
+			file_server
+		}
+		handle {
+			rewrite * /50x.html
+			file_server
+		}
+	}
+}
+`
+	return template.New("caddyfile").Parse(tmpl)
+}
+
+func NewCaddySiteBuilder() (*CaddySiteBuilder, error) {
+	viper.SetDefault("caddy.useZstd", false)
+	viper.SetDefault("caddy.useHTTPS", false)
+	sitesPath := viper.GetString("storage.sitesPath")
+	serverName := viper.GetString("caddy.serverName")
+	tmpl, err := getCaddyfileTemplate()
+	if err != nil {
+		return nil, err
+	}
+	cache, err := NewCachePolicy()
+	if err != nil {
+		return nil, err
+	}
+	return &CaddySiteBuilder{
+		cache:      cache,
+		sitesPath:  sitesPath,
+		serverName: serverName,
+		template:   tmpl,
+	}, nil
+}
@@ -0,0 +1,159 @@
+package sitebuilder
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/spf13/viper"
+	"github.com/vtex/hyper-cas/utils"
+)
+
+const envoyRouteConfigurationType = "type.googleapis.com/envoy.config.route.v3.RouteConfiguration"
+
+type EnvoySiteBuilder struct {
+	serverName      string
+	cluster         string
+	routeConfigName string
+	configPath      string
+	cache           *CachePolicy
+}
+
+type envoyHeaderValue struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+type envoyHeaderValueOption struct {
+	Header envoyHeaderValue `json:"header"`
+}
+
+type envoyRegex struct {
+	Regex string `json:"regex"`
+}
+
+type envoyRegexRewrite struct {
+	Pattern      envoyRegex `json:"pattern"`
+	Substitution string     `json:"substitution"`
+}
+
+type envoyRoute struct {
+	Match struct {
+		Prefix    string      `json:"prefix,omitempty"`
+		SafeRegex *envoyRegex `json:"safe_regex,omitempty"`
+	} `json:"match"`
+	Route struct {
+		Cluster       string             `json:"cluster"`
+		PrefixRewrite string             `json:"prefix_rewrite,omitempty"`
+		RegexRewrite  *envoyRegexRewrite `json:"regex_rewrite,omitempty"`
+	} `json:"route"`
+	ResponseHeadersToAdd []envoyHeaderValueOption `json:"response_headers_to_add,omitempty"`
+}
+
+type envoyVirtualHost struct {
+	Name                 string                   `json:"name"`
+	Domains              []string                 `json:"domains"`
+	Routes               []*envoyRoute            `json:"routes"`
+	ResponseHeadersToAdd []envoyHeaderValueOption `json:"response_headers_to_add"`
+}
+
+type envoyRouteConfiguration struct {
+	Type         string              `json:"@type"`
+	Name         string              `json:"name"`
+	VirtualHosts []*envoyVirtualHost `json:"virtual_hosts"`
+}
+
+// envoyDiscoveryResponse is the format of the files watched by Envoy's
+// filesystem subscriptions (path_config_source)
+type envoyDiscoveryResponse struct {
+	VersionInfo string                     `json:"version_info"`
+	Resources   []*envoyRouteConfiguration `json:"resources"`
+}
+
+func (sb *EnvoySiteBuilder) Build(sites []Site) (string, error) {
+	routeConfig := &envoyRouteConfiguration{
+		Type:         envoyRouteConfigurationType,
+		Name:         sb.routeConfigName,
+		VirtualHosts: []*envoyVirtualHost{},
+	}
+	for _, site := range sites {
+		// Routes are matched in order, so the cache policy comes before the
+		// route of every other path
+		routes := []*envoyRoute{}
+		for _, match := range sb.cache.Matches(site) {
+			route := &envoyRoute{}
+			route.Match.SafeRegex = &envoyRegex{Regex: match.FullRegexp()}
+			route.Route.Cluster = sb.cluster
+			route.Route.RegexRewrite = &envoyRegexRewrite{
+				Pattern:      envoyRegex{Regex: "^/"},
+				Substitution: fmt.Sprintf("/%s/", site.Hash),
+			}
+			route.ResponseHeadersToAdd = []envoyHeaderValueOption{
+				{Header: envoyHeaderValue{Key: "Cache-Control", Value: match.CacheControl}},
+			}
+			routes = append(routes, route)
+		}
+		route := &envoyRoute{}
+		route.Match.Prefix = "/"
+		route.Route.Cluster = sb.cluster
+		route.Route.PrefixRewrite = fmt.Sprintf("/%s/", site.Hash)
+		routes = append(routes, route)
+		routeConfig.VirtualHosts = append(routeConfig.VirtualHosts, &envoyVirtualHost{
+			Name:    site.Label,
+			Domains: append([]string{sb.Host(site.Label)}, site.Domains...),
+			Routes:  routes,
+			ResponseHeadersToAdd: []envoyHeaderValueOption{
+				{Header: envoyHeaderValue{Key: "Hyper-Cas-Label", Value: site.Label}},
+				{Header: envoyHeaderValue{Key: "Hyper-Cas-Hash", Value: site.Hash}},
+				{Header: envoyHeaderValue{Key: "Vary", Value: "Hyper-Cas-Hash"}},
+			},
+		})
+	}
+
+	// The version changes with the routes, including the cache policy
+	routes, err := json.Marshal(routeConfig)
+	if err != nil {
+		return "", err
+	}
+	response := &envoyDiscoveryResponse{
+		VersionInfo: fmt.Sprintf("%x", utils.HashBytes(routes)),
+		Resources:   []*envoyRouteConfiguration{routeConfig},
+	}
+	dat, err := json.MarshalIndent(response, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(dat), nil
+}
+
+func (sb *EnvoySiteBuilder) Generate(site Site) (string, error) {
+	return sb.Build([]Site{site})
+}
+
+func (sb *EnvoySiteBuilder) Host(label string) string {
+	return fmt.Sprintf("%s.%s", label, sb.serverName)
+}
+
+func (sb *EnvoySiteBuilder) Extension() string {
+	return ".json"
+}
+
+func (sb *EnvoySiteBuilder) ConfigPath() string {
+	return sb.configPath
+}
+
+func NewEnvoySiteBuilder() (*EnvoySiteBuilder, error) {
+	viper.SetDefault("envoy.cluster", "hyper-cas-sites")
+	viper.SetDefault("envoy.routeConfigName", "hyper-cas")
+	viper.SetDefault("envoy.configPath", "envoy-routes.json")
+	cache, err := NewCachePolicy()
+	if err != nil {
+		return nil, err
+	}
+	return &EnvoySiteBuilder{
+		cache:           cache,
+		serverName:      viper.GetString("envoy.serverName"),
+		cluster:         viper.GetString("envoy.cluster"),
+		routeConfigName: viper.GetString("envoy.routeConfigName"),
+		configPath:      viper.GetString("envoy.configPath"),
+	}, nil
+}
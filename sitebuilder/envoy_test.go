@@ -0,0 +1,55 @@
+package sitebuilder
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vtex/hyper-cas/utils"
+)
+
+// envoyCacheControl of a path, matching the routes of the virtual host as
+// Envoy does: in order, with safe_regex matching the whole path
+func envoyCacheControl(t *testing.T, host *envoyVirtualHost, path string) string {
+	for _, route := range host.Routes {
+		if route.Match.SafeRegex == nil {
+			continue
+		}
+		re, err := regexp.Compile(fmt.Sprintf("^(?:%s)$", route.Match.SafeRegex.Regex))
+		assert.NoError(t, err)
+		if re.MatchString(path) {
+			return route.ResponseHeadersToAdd[0].Header.Value
+		}
+	}
+	return ""
+}
+
+func TestEnvoyCacheRoutesMatchWholePaths(t *testing.T) {
+	sb, err := NewEnvoySiteBuilder()
+	assert.NoError(t, err)
+	site := Site{Label: "envoy", Hash: fmt.Sprintf("%x", utils.Hash("envoy"))}
+	site.Rules.Cache = []CacheRule{{Path: "/assets/**", CacheControl: "max-age=60"}}
+
+	conf, err := sb.Generate(site)
+	assert.NoError(t, err)
+	var response envoyDiscoveryResponse
+	assert.NoError(t, json.Unmarshal([]byte(conf), &response))
+	host := response.Resources[0].VirtualHosts[0]
+
+	tests := map[string]string{
+		"/index.html":                   "no-cache",
+		"/docs/guide/index.html":        "no-cache",
+		"/assets/logo.png":              "max-age=60",
+		"/assets/js/app.3f2a9c1b.js":    "max-age=60",
+		"/static/js/app.3f2a9c1b.js":    "public, max-age=31536000, immutable",
+		"/main-5d41402abc4b2a76.css":    "public, max-age=31536000, immutable",
+		"/index.html.map":               "",
+		"/reports/report-20241019.pdf":  "",
+		"/static/js/app.3f2a9c1b.js.gz": "",
+	}
+	for path, cacheControl := range tests {
+		assert.Equal(t, cacheControl, envoyCacheControl(t, host, path), path)
+	}
+}
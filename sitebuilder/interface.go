@@ -1,5 +1,58 @@
 package sitebuilder
 
+import "time"
+
+// Types of site builders
+const (
+	Nginx   = "nginx"
+	Caddy   = "caddy"
+	Traefik = "traefik"
+	Envoy   = "envoy"
+)
+
 type SiteBuilder interface {
-	Generate(label, root string) (string, error)
+	Generate(site Site) (string, error)
+	// Extension of the configuration files generated for each label
+	Extension() string
+	// Host the site of a label answers on, besides its custom domains
+	Host(label string) string
+}
+
+// RulesSiteBuilder turns the `_redirects` and `_headers` files of
+// distributions into rules of its web server, and doesn't serve the files.
+// Other site builders can't have labels of distributions with them.
+type RulesSiteBuilder interface {
+	SiteBuilder
+	SupportsRules() bool
+}
+
+// Site served for a label
+type Site struct {
+	Label       string
+	Hash        string
+	Distro      Distro
+	Annotations map[string]string
+	// Domains the site answers on, besides the one of the site builder
+	Domains []string
+	// Rules from the `_redirects` and `_headers` files of the distribution
+	Rules Rules
+}
+
+// Distro the label of a site points to. It is empty when the distribution
+// is not in storage and for aggregate site builders.
+type Distro struct {
+	FileCount  int
+	TotalBytes int64
+	CreatedAt  time.Time
+}
+
+// AggregateSiteBuilder keeps a single configuration document with the sites
+// of every label, instead of one file per label. Generate renders the
+// document with a single site.
+type AggregateSiteBuilder interface {
+	SiteBuilder
+	Build(sites []Site) (string, error)
+	// ConfigPath of the document, which is rewritten whenever a label
+	// changes. Relative paths are relative to the sites path.
+	ConfigPath() string
 }
@@ -3,71 +3,245 @@ package sitebuilder
 import (
 	"bytes"
 	"fmt"
-	"html/template"
+	"io/ioutil"
+	"path"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
 
 	"github.com/spf13/viper"
+	"github.com/vtex/hyper-cas/utils"
 )
 
 type NginxSiteBuilder struct {
 	serverName string
 	sitesPath  string
+	sitesRoot  string
 	template   *template.Template
+	templates  []*nginxLabelTemplate
+	cache      *CachePolicy
 }
 
-func (sb *NginxSiteBuilder) Generate(label, root string) (string, error) {
-	serverName := fmt.Sprintf("%s.%s", label, sb.serverName)
-	useBrotli := viper.GetBool("nginx.useBrotli")
+// NginxTemplate is a template file used for the labels matching a glob
+type NginxTemplate struct {
+	Labels string `mapstructure:"labels"`
+	File   string `mapstructure:"file"`
+}
 
-	brotli := ""
-	if useBrotli {
-		brotli = `
-	# brotli
-	brotli on;
-	brotli_comp_level 6;
-	brotli_types text/xml image/svg+xml application/x-font-ttf image/vnd.microsoft.icon application/x-font-opentype application/json font/eot application/vnd.ms-fontobject application/javascript font/otf application/xml application/xhtml+xml text/javascript  application/x-javascript text/plain application/x-font-truetype application/xml+rss image/x-icon font/opentype text/css image/x-win-bitmap;`
+type nginxLabelTemplate struct {
+	labels   string
+	template *template.Template
+}
+
+// NginxSiteData is what nginx site templates are executed with
+type NginxSiteData struct {
+	Label       string
+	Hash        string
+	RootPath    string
+	ServerName  string
+	Domains     []string
+	UseBrotli   bool
+	Distro      Distro
+	Annotations map[string]string
+	Rules       Rules
+	Cache       []CacheMatch
+	RulesConf   NginxRulesConf
+}
+
+// labelName is a DNS label, since labels are hosts of the site builders
+var labelName = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+var annotationKey = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9._/-]{0,251}[A-Za-z0-9])?$`)
+
+// ValidateAnnotations of a label. Templates write them to the configuration
+// as they are, so values can't have characters that end or open directives,
+// blocks or strings.
+func ValidateAnnotations(annotations map[string]string) error {
+	for key, value := range annotations {
+		if !annotationKey.MatchString(key) {
+			return fmt.Errorf("annotation key %q should be made of letters, digits and ., _, / or -", key)
+		}
+		if strings.Contains(value, ";") {
+			return fmt.Errorf("annotation %s: %q has an unsupported character ';'", key, value)
+		}
+		if err := validateText(value); err != nil {
+			return fmt.Errorf("annotation %s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// ValidateSite checks the fields that templates write to the configuration
+// as they are: the label, the hash and the annotations.
+func ValidateSite(site Site) error {
+	if !labelName.MatchString(site.Label) {
+		return fmt.Errorf("label %q should be made of lowercase letters, digits and - (at most 63)", site.Label)
 	}
+	if !utils.IsHash(site.Hash) {
+		return fmt.Errorf("hash %q is not a valid hash", site.Hash)
+	}
+	return ValidateAnnotations(site.Annotations)
+}
+
+// NginxRulesConf has the rules of a distribution and the cache policy as
+// nginx directives. HTTP goes outside of the server block, Server inside it
+// and Fallback in a location used when no file matches the path.
+type NginxRulesConf struct {
+	HTTP     string
+	Server   string
+	Fallback string
+}
 
-	data := struct {
-		Label      string
-		Hash       string
-		RootPath   string
-		ServerName string
-		Brotli     string
-	}{
-		Label:      label,
-		Hash:       root,
-		RootPath:   fmt.Sprintf("/app/sites/%s", root),
-		ServerName: serverName,
-		Brotli:     brotli,
+func (sb *NginxSiteBuilder) Generate(site Site) (string, error) {
+	annotations := site.Annotations
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	domains := site.Domains
+	if domains == nil {
+		domains = []string{}
+	}
+	cache := sb.cache.Matches(site)
+	data := &NginxSiteData{
+		Label:       site.Label,
+		Hash:        site.Hash,
+		RootPath:    path.Join(sb.sitesRoot, site.Hash),
+		ServerName:  sb.Host(site.Label),
+		Domains:     domains,
+		UseBrotli:   viper.GetBool("nginx.useBrotli"),
+		Distro:      site.Distro,
+		Annotations: annotations,
+		Rules:       site.Rules,
+		Cache:       cache,
+		RulesConf:   nginxRulesConf(site.Label, site.Rules, cache),
 	}
 
+	tmpl := sb.templateFor(site.Label)
 	var tpl bytes.Buffer
-	err := sb.template.Execute(&tpl, data)
+	err := tmpl.Execute(&tpl, data)
 	if err != nil {
 		return "", err
 	}
+	if strings.TrimSpace(tpl.String()) == "" {
+		return "", fmt.Errorf("template %s generated an empty configuration", tmpl.Name())
+	}
 	return tpl.String(), nil
 }
 
+// templateFor returns the template of the first glob matching label or,
+// when none match, the global template
+func (sb *NginxSiteBuilder) templateFor(label string) *template.Template {
+	for _, labelTemplate := range sb.templates {
+		if matched, err := path.Match(labelTemplate.labels, label); err == nil && matched {
+			return labelTemplate.template
+		}
+	}
+	return sb.template
+}
+
+func (sb *NginxSiteBuilder) Host(label string) string {
+	return fmt.Sprintf("%s.%s", label, sb.serverName)
+}
+
+func (sb *NginxSiteBuilder) SupportsRules() bool {
+	return true
+}
+
+func (sb *NginxSiteBuilder) Extension() string {
+	return ".conf"
+}
+
+// nginxRulesConf renders redirects as `return` and `rewrite` directives and
+// headers as `add_header` directives with values from a map for each header.
+// The cache policy comes after the Cache-Control headers of the rules.
+func nginxRulesConf(label string, rules Rules, cache []CacheMatch) NginxRulesConf {
+	var http, server, fallback strings.Builder
+	prefix := fmt.Sprintf("hypercas_%x", utils.Hash(label))[:19]
+
+	names := []string{}
+	values := map[string][]string{}
+	for _, rule := range rules.Headers {
+		pattern, err := ParsePathPattern(rule.Path)
+		if err != nil {
+			continue
+		}
+		for _, header := range rule.Headers {
+			name := strings.ToLower(header.Name)
+			if _, ok := values[name]; !ok {
+				names = append(names, header.Name)
+			}
+			values[name] = append(values[name], fmt.Sprintf("\t\"~%s\" \"%s\";\n", pattern.Regexp("hc_"), header.Value))
+		}
+	}
+	for _, match := range cache {
+		if _, ok := values["cache-control"]; !ok {
+			names = append(names, "Cache-Control")
+		}
+		values["cache-control"] = append(values["cache-control"], fmt.Sprintf("\t\"~%s\" \"%s\";\n", match.Regexp, match.CacheControl))
+	}
+	for i, name := range names {
+		variable := fmt.Sprintf("$%s_%d", prefix, i)
+		fmt.Fprintf(&http, "map $uri %s {\n\tdefault \"\";\n%s}\n", variable, strings.Join(values[strings.ToLower(name)], ""))
+		fmt.Fprintf(&server, "\tadd_header %s %s;\n", name, variable)
+	}
+
+	for _, redirect := range rules.Redirects {
+		pattern, err := ParsePathPattern(redirect.From)
+		if err != nil {
+			continue
+		}
+		to := ReplacePlaceholders(redirect.To, func(name string) string {
+			return fmt.Sprintf("${hc_%s}", name)
+		})
+		directive := fmt.Sprintf("rewrite \"%s\" \"%s\" last;", pattern.Regexp("hc_"), to)
+		if redirect.Status != 200 {
+			if !strings.Contains(to, "?") {
+				to += "$is_args$args"
+			}
+			directive = fmt.Sprintf("if ($uri ~ \"%s\") {\n\t\treturn %d \"%s\";\n\t}", pattern.Regexp("hc_"), redirect.Status, to)
+		}
+		if redirect.Force {
+			fmt.Fprintf(&server, "\t%s\n", directive)
+		} else {
+			fmt.Fprintf(&fallback, "\t%s\n", directive)
+		}
+	}
+	return NginxRulesConf{HTTP: http.String(), Server: server.String(), Fallback: fallback.String()}
+}
+
 func getConfTemplate() (*template.Template, error) {
-	const tmpl = `
+	const tmpl = `{{.RulesConf.HTTP}}
 server {
 	root {{.RootPath}};
 	index index.html index.htm;
-	server_name {{.ServerName}};
+	server_name {{.ServerName}}{{range .Domains}} {{.}}{{end}};
 	add_header Hyper-Cas-Label {{.Label}};
 	add_header Hyper-Cas-Hash {{.Hash}};
 	add_header Vary Hyper-Cas-Hash;
-	
+{{.RulesConf.Server}}
 	gzip on;
 	gzip_vary on;
 	gzip_proxied any;
 	gzip_comp_level 6;
 	gzip_types text/plain text/css text/xml application/json application/javascript application/xml+rss application/atom+xml image/svg+xml;
-	{{.Brotli}}
+	{{if .UseBrotli}}
+	# brotli
+	brotli on;
+	brotli_comp_level 6;
+	brotli_types text/xml image/svg+xml application/x-font-ttf image/vnd.microsoft.icon application/x-font-opentype application/json font/eot application/vnd.ms-fontobject application/javascript font/otf application/xml application/xhtml+xml text/javascript  application/x-javascript text/plain application/x-font-truetype application/xml+rss image/x-icon font/opentype text/css image/x-win-bitmap;{{end}}
 
     location / {
-        try_files $uri $uri/ /index.html;
+        try_files $uri $uri/ {{if .RulesConf.Fallback}}@rules{{else}}/index.html{{end}};
+    }
+{{if .RulesConf.Fallback}}
+    location @rules {
+{{.RulesConf.Fallback}}
+        try_files /index.html =404;
+    }
+{{end}}
+    location ~ ^/_(redirects|headers)$ {
+        return 404;
     }
 
 	include {{.RootPath}}/nginx/*.conf;
@@ -79,17 +253,87 @@ server {
 	return template.New("conf").Parse(tmpl)
 }
 
+// loadTemplate parses a template file and checks that it can be executed,
+// so a broken template is found at startup instead of when a label is set
+func loadTemplate(filePath string) (*template.Template, error) {
+	dat, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read nginx template %s: %w", filePath, err)
+	}
+	tmpl, err := template.New(filePath).Option("missingkey=zero").Parse(string(dat))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid nginx template %s: %w", filePath, err)
+	}
+	err = validateTemplate(tmpl)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid nginx template %s: %w", filePath, err)
+	}
+	return tmpl, nil
+}
+
+func validateTemplate(tmpl *template.Template) error {
+	sample := &NginxSiteData{
+		Label:       "label",
+		Hash:        "da39a3ee5e6b4b0d3255bfef95601890afd80709",
+		RootPath:    "/app/sites/da39a3ee5e6b4b0d3255bfef95601890afd80709",
+		ServerName:  "label.hyper-cas.org",
+		Domains:     []string{"www.hyper-cas.org"},
+		Distro:      Distro{FileCount: 1, TotalBytes: 1, CreatedAt: time.Now()},
+		Annotations: map[string]string{},
+	}
+	var tpl bytes.Buffer
+	err := tmpl.Execute(&tpl, sample)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(tpl.String()) == "" {
+		return fmt.Errorf("the template generated an empty configuration")
+	}
+	return nil
+}
+
 func NewNginxSiteBuilder() (*NginxSiteBuilder, error) {
-	viper.SetDefault("useBrotli", false)
+	viper.SetDefault("nginx.useBrotli", false)
+	viper.SetDefault("nginx.sitesRoot", "/app/sites")
 	sitesPath := viper.GetString("storage.sitesPath")
 	serverName := viper.GetString("nginx.serverName")
+
 	tmpl, err := getConfTemplate()
+	if templateFile := viper.GetString("nginx.template"); templateFile != "" {
+		tmpl, err = loadTemplate(templateFile)
+	}
 	if err != nil {
 		return nil, err
 	}
+
+	var configs []NginxTemplate
+	err = viper.UnmarshalKey("nginx.templates", &configs)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid nginx.templates: %w", err)
+	}
+	templates := []*nginxLabelTemplate{}
+	for _, config := range configs {
+		if _, err := path.Match(config.Labels, ""); err != nil {
+			return nil, fmt.Errorf("Invalid label glob %q in nginx.templates: %w", config.Labels, err)
+		}
+		labelTemplate, err := loadTemplate(config.File)
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, &nginxLabelTemplate{labels: config.Labels, template: labelTemplate})
+	}
+
+	cache, err := NewCachePolicy()
+	if err != nil {
+		return nil, err
+	}
+
 	return &NginxSiteBuilder{
+		cache:      cache,
 		sitesPath:  sitesPath,
 		serverName: serverName,
+		sitesRoot:  viper.GetString("nginx.sitesRoot"),
 		template:   tmpl,
+		templates:  templates,
 	}, nil
 }
@@ -0,0 +1,218 @@
+package sitebuilder
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/spf13/viper"
+)
+
+// Modes of the nginx site builder
+const (
+	NginxModeServer = "server"
+	NginxModeMap    = "map"
+)
+
+// NginxMapSiteBuilder keeps every label in maps from the host to the root of
+// its distribution, used by a single shared server block. nginx reloads then
+// don't get slower with thousands of labels, like they do with a server
+// block per label.
+type NginxMapSiteBuilder struct {
+	serverName string
+	sitesRoot  string
+	configPath string
+	listen     string
+	template   *template.Template
+	cache      *CachePolicy
+}
+
+type nginxMapHost struct {
+	Host     string
+	Label    string
+	Hash     string
+	RootPath string
+}
+
+type nginxMapData struct {
+	Hosts     []*nginxMapHost
+	SiteCache []CacheMatch
+	Cache     []CacheMatch
+	Listen    string
+	UseBrotli bool
+	HasCache  bool
+}
+
+func (sb *NginxMapSiteBuilder) Build(sites []Site) (string, error) {
+	data := &nginxMapData{
+		Hosts:     []*nginxMapHost{},
+		SiteCache: []CacheMatch{},
+		Cache:     sb.cache.GlobalMatches(),
+		Listen:    sb.listen,
+		UseBrotli: viper.GetBool("nginx.useBrotli"),
+	}
+	for _, site := range sites {
+		rootPath := path.Join(sb.sitesRoot, site.Hash)
+		for _, host := range append([]string{sb.Host(site.Label)}, site.Domains...) {
+			data.Hosts = append(data.Hosts, &nginxMapHost{Host: host, Label: site.Label, Hash: site.Hash, RootPath: rootPath})
+		}
+		// The rules of each site are matched against the label followed by
+		// the path
+		prefix := "^" + regexp.QuoteMeta(site.Label) + ":"
+		for _, match := range sb.cache.SiteMatches(site) {
+			re := match.Regexp
+			switch {
+			case strings.HasPrefix(re, "^"):
+				re = prefix + re[1:]
+			case strings.HasPrefix(re, "(^|/)"):
+				re = prefix + ".*/" + re[len("(^|/)"):]
+			default:
+				re = prefix + ".*" + re
+			}
+			data.SiteCache = append(data.SiteCache, CacheMatch{Regexp: re, CacheControl: match.CacheControl})
+		}
+	}
+	data.HasCache = len(data.SiteCache) > 0 || len(data.Cache) > 0
+
+	var tpl bytes.Buffer
+	err := sb.template.Execute(&tpl, data)
+	if err != nil {
+		return "", err
+	}
+	return tpl.String(), nil
+}
+
+func (sb *NginxMapSiteBuilder) Generate(site Site) (string, error) {
+	return sb.Build([]Site{site})
+}
+
+func (sb *NginxMapSiteBuilder) Host(label string) string {
+	return fmt.Sprintf("%s.%s", label, sb.serverName)
+}
+
+func (sb *NginxMapSiteBuilder) Extension() string {
+	return ".conf"
+}
+
+func (sb *NginxMapSiteBuilder) ConfigPath() string {
+	return sb.configPath
+}
+
+func getNginxMapTemplate() (*template.Template, error) {
+	const tmpl = `map $host $hyper_cas_root {
+	hostnames;
+	default "";
+{{- range .Hosts}}
+	"{{.Host}}" "{{.RootPath}}";
+{{- end}}
+}
+
+map $host $hyper_cas_label {
+	hostnames;
+	default "";
+{{- range .Hosts}}
+	"{{.Host}}" "{{.Label}}";
+{{- end}}
+}
+
+map $host $hyper_cas_hash {
+	hostnames;
+	default "";
+{{- range .Hosts}}
+	"{{.Host}}" "{{.Hash}}";
+{{- end}}
+}
+{{if .HasCache}}
+map "$hyper_cas_label:$uri" $hyper_cas_site_cache_control {
+	default "";
+{{- range .SiteCache}}
+	"~{{.Regexp}}" "{{.CacheControl}}";
+{{- end}}
+}
+
+map $uri $hyper_cas_global_cache_control {
+	default "";
+{{- range .Cache}}
+	"~{{.Regexp}}" "{{.CacheControl}}";
+{{- end}}
+}
+
+map $hyper_cas_site_cache_control $hyper_cas_cache_control {
+	"" $hyper_cas_global_cache_control;
+	default $hyper_cas_site_cache_control;
+}
+{{end}}
+server {
+	listen {{.Listen}} default_server;
+	server_name _;
+
+	if ($hyper_cas_root = "") {
+		return 404;
+	}
+	root $hyper_cas_root;
+	index index.html index.htm;
+	add_header Hyper-Cas-Label $hyper_cas_label;
+	add_header Hyper-Cas-Hash $hyper_cas_hash;
+	add_header Vary Hyper-Cas-Hash;
+	{{- if .HasCache}}
+	add_header Cache-Control $hyper_cas_cache_control;
+	{{- end}}
+
+	gzip on;
+	gzip_vary on;
+	gzip_proxied any;
+	gzip_comp_level 6;
+	gzip_types text/plain text/css text/xml application/json application/javascript application/xml+rss application/atom+xml image/svg+xml;
+	{{- if .UseBrotli}}
+
+	# brotli
+	brotli on;
+	brotli_comp_level 6;
+	brotli_types text/xml image/svg+xml application/x-font-ttf image/vnd.microsoft.icon application/x-font-opentype application/json font/eot application/vnd.ms-fontobject application/javascript font/otf application/xml application/xhtml+xml text/javascript  application/x-javascript text/plain application/x-font-truetype application/xml+rss image/x-icon font/opentype text/css image/x-win-bitmap;
+	{{- end}}
+
+	location / {
+		try_files $uri $uri/ /index.html;
+	}
+
+	location ~ ^/_(redirects|headers)$ {
+		return 404;
+	}
+
+	error_page 404 /404.html;
+	error_page 500 502 503 504 /50x.html;
+}
+`
+	return template.New("nginx-map").Parse(tmpl)
+}
+
+func NewNginxMapSiteBuilder() (*NginxMapSiteBuilder, error) {
+	viper.SetDefault("nginx.useBrotli", false)
+	viper.SetDefault("nginx.sitesRoot", "/app/sites")
+	viper.SetDefault("nginx.mapConfigPath", "hyper-cas-map.conf")
+	viper.SetDefault("nginx.mapListen", "80")
+	var templates []NginxTemplate
+	err := viper.UnmarshalKey("nginx.templates", &templates)
+	if err != nil || viper.GetString("nginx.template") != "" || len(templates) > 0 {
+		return nil, fmt.Errorf("nginx.template and nginx.templates are not supported with nginx.mode %s", NginxModeMap)
+	}
+	tmpl, err := getNginxMapTemplate()
+	if err != nil {
+		return nil, err
+	}
+	cache, err := NewCachePolicy()
+	if err != nil {
+		return nil, err
+	}
+	return &NginxMapSiteBuilder{
+		serverName: viper.GetString("nginx.serverName"),
+		sitesRoot:  viper.GetString("nginx.sitesRoot"),
+		configPath: viper.GetString("nginx.mapConfigPath"),
+		listen:     viper.GetString("nginx.mapListen"),
+		template:   tmpl,
+		cache:      cache,
+	}, nil
+}
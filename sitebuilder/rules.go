@@ -0,0 +1,261 @@
+package sitebuilder
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Files in the root of a distribution with its redirect and header rules,
+// in the format used by Netlify
+const (
+	RedirectsFile = "_redirects"
+	HeadersFile   = "_headers"
+)
+
+// Rules shipped with a distribution
+type Rules struct {
+	Redirects []Redirect   `json:"redirects,omitempty"`
+	Headers   []HeaderRule `json:"headers,omitempty"`
+	Cache     []CacheRule  `json:"cache,omitempty"`
+}
+
+// Empty returns whether there are no rules
+func (r *Rules) Empty() bool {
+	return len(r.Redirects) == 0 && len(r.Headers) == 0 && len(r.Cache) == 0
+}
+
+// Redirect from a path pattern to another path or URL. Status 200 rewrites
+// the path instead of redirecting. Redirects that are not forced only apply
+// when no file matches the path.
+type Redirect struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Status int    `json:"status"`
+	Force  bool   `json:"force,omitempty"`
+}
+
+// HeaderRule adds headers to the responses of paths matching a pattern
+type HeaderRule struct {
+	Path    string   `json:"path"`
+	Headers []Header `json:"headers"`
+}
+
+// Header of a response
+type Header struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+var redirectStatuses = map[int]bool{200: true, 301: true, 302: true, 303: true, 307: true, 308: true}
+
+var placeholderName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+var placeholder = regexp.MustCompile(`:([A-Za-z_][A-Za-z0-9_]*)`)
+var headerName = regexp.MustCompile("^[A-Za-z0-9!#$%&'*+.^_`|~-]+$")
+
+// validateText rejects characters that can't be safely written to the
+// configuration of the web servers
+func validateText(text string) error {
+	for _, r := range text {
+		if r < 0x20 || r == 0x7f || strings.ContainsRune("\"\\${}", r) {
+			return fmt.Errorf("%q has an unsupported character %q", text, r)
+		}
+	}
+	return nil
+}
+
+// PathPattern of a rule, where a `:name` segment matches any segment and a
+// trailing `*` matches the rest of the path (available as `:splat`)
+type PathPattern struct {
+	Segments []string
+	Splat    bool
+}
+
+// ParsePathPattern validates a pattern, which must be an absolute path
+func ParsePathPattern(pattern string) (*PathPattern, error) {
+	if !strings.HasPrefix(pattern, "/") {
+		return nil, fmt.Errorf("path %q should start with /", pattern)
+	}
+	if err := validateText(pattern); err != nil {
+		return nil, err
+	}
+	parsed := &PathPattern{}
+	if strings.HasSuffix(pattern, "*") {
+		parsed.Splat = true
+		pattern = strings.TrimSuffix(pattern, "*")
+	}
+	if strings.Contains(pattern, "*") {
+		return nil, fmt.Errorf("path %q can only have * at its end", pattern)
+	}
+	parsed.Segments = strings.Split(pattern[1:], "/")
+	for _, segment := range parsed.Segments {
+		if strings.HasPrefix(segment, ":") && !placeholderName.MatchString(segment[1:]) {
+			return nil, fmt.Errorf("invalid placeholder %q", segment)
+		}
+		if segment == ":splat" {
+			return nil, fmt.Errorf("placeholder :splat is reserved for *")
+		}
+	}
+	return parsed, nil
+}
+
+// Names of the placeholders of the pattern
+func (p *PathPattern) Names() []string {
+	names := []string{}
+	for _, segment := range p.Segments {
+		if strings.HasPrefix(segment, ":") {
+			names = append(names, segment[1:])
+		}
+	}
+	if p.Splat {
+		names = append(names, "splat")
+	}
+	return names
+}
+
+// Regexp matching the pattern, with a named group for each placeholder
+// called prefix followed by the placeholder name
+func (p *PathPattern) Regexp(prefix string) string {
+	parts := []string{}
+	for _, segment := range p.Segments {
+		if strings.HasPrefix(segment, ":") {
+			parts = append(parts, fmt.Sprintf("(?P<%s%s>[^/]+)", prefix, segment[1:]))
+		} else {
+			parts = append(parts, regexp.QuoteMeta(segment))
+		}
+	}
+	re := "^/" + strings.Join(parts, "/")
+	if p.Splat {
+		re += fmt.Sprintf("(?P<%ssplat>.*)", prefix)
+	}
+	return re + "$"
+}
+
+// ReplacePlaceholders in the target of a redirect
+func ReplacePlaceholders(to string, replace func(name string) string) string {
+	return placeholder.ReplaceAllStringFunc(to, func(match string) string {
+		return replace(match[1:])
+	})
+}
+
+func parseRedirect(fields []string) (*Redirect, error) {
+	if len(fields) < 2 {
+		return nil, fmt.Errorf("a redirect needs a path and a target")
+	}
+	if len(fields) > 3 {
+		return nil, fmt.Errorf("query parameters, conditions and other options are not supported")
+	}
+	redirect := &Redirect{From: fields[0], To: fields[1], Status: 301}
+	if len(fields) == 3 {
+		status := fields[2]
+		if strings.HasSuffix(status, "!") {
+			redirect.Force = true
+			status = strings.TrimSuffix(status, "!")
+		}
+		code, err := strconv.Atoi(status)
+		if err != nil || !redirectStatuses[code] {
+			return nil, fmt.Errorf("unsupported status %q (expected 200, 301, 302, 303, 307 or 308)", fields[2])
+		}
+		redirect.Status = code
+	}
+
+	from, err := ParsePathPattern(redirect.From)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateText(redirect.To); err != nil {
+		return nil, err
+	}
+	external := strings.HasPrefix(redirect.To, "http://") || strings.HasPrefix(redirect.To, "https://")
+	if !external && !strings.HasPrefix(redirect.To, "/") {
+		return nil, fmt.Errorf("target %q should be a path or an http(s) URL", redirect.To)
+	}
+	if external && redirect.Status == 200 {
+		return nil, fmt.Errorf("proxying to %q is not supported", redirect.To)
+	}
+	names := map[string]bool{}
+	for _, name := range from.Names() {
+		names[name] = true
+	}
+	for _, match := range placeholder.FindAllStringSubmatch(redirect.To, -1) {
+		if !names[match[1]] {
+			return nil, fmt.Errorf("placeholder :%s of %q is not in %q", match[1], redirect.To, redirect.From)
+		}
+	}
+	return redirect, nil
+}
+
+// ParseRedirects in the `_redirects` format: one `<from> <to> [status][!]`
+// rule per line, with # comments
+func ParseRedirects(data []byte) ([]Redirect, error) {
+	redirects := []Redirect{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		if i := strings.Index(text, " #"); i >= 0 {
+			text = text[:i]
+		}
+		redirect, err := parseRedirect(strings.Fields(text))
+		if err != nil {
+			return nil, fmt.Errorf("%s line %d: %w", RedirectsFile, line, err)
+		}
+		redirects = append(redirects, *redirect)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", RedirectsFile, err)
+	}
+	return redirects, nil
+}
+
+// ParseHeaders in the `_headers` format: a path pattern on its own line
+// followed by indented `Name: value` lines, with # comments
+func ParseHeaders(data []byte) ([]HeaderRule, error) {
+	rules := []HeaderRule{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		raw := scanner.Text()
+		text := strings.TrimSpace(raw)
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		if raw[0] != ' ' && raw[0] != '\t' {
+			if len(rules) > 0 && len(rules[len(rules)-1].Headers) == 0 {
+				return nil, fmt.Errorf("%s line %d: path %q has no headers", HeadersFile, line-1, rules[len(rules)-1].Path)
+			}
+			if _, err := ParsePathPattern(text); err != nil {
+				return nil, fmt.Errorf("%s line %d: %w", HeadersFile, line, err)
+			}
+			rules = append(rules, HeaderRule{Path: text, Headers: []Header{}})
+			continue
+		}
+		if len(rules) == 0 {
+			return nil, fmt.Errorf("%s line %d: headers should come after a path", HeadersFile, line)
+		}
+		parts := strings.SplitN(text, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("%s line %d: headers should be formatted as Name: value", HeadersFile, line)
+		}
+		header := Header{Name: strings.TrimSpace(parts[0]), Value: strings.TrimSpace(parts[1])}
+		if !headerName.MatchString(header.Name) {
+			return nil, fmt.Errorf("%s line %d: invalid header name %q", HeadersFile, line, header.Name)
+		}
+		if err := validateText(header.Value); err != nil {
+			return nil, fmt.Errorf("%s line %d: %w", HeadersFile, line, err)
+		}
+		rule := &rules[len(rules)-1]
+		rule.Headers = append(rule.Headers, header)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", HeadersFile, err)
+	}
+	if len(rules) > 0 && len(rules[len(rules)-1].Headers) == 0 {
+		return nil, fmt.Errorf("%s: path %q has no headers", HeadersFile, rules[len(rules)-1].Path)
+	}
+	return rules, nil
+}
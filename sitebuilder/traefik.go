@@ -0,0 +1,164 @@
+package sitebuilder
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/viper"
+	"github.com/vtex/hyper-cas/utils"
+	"gopkg.in/yaml.v2"
+)
+
+const traefikPrefix = "hyper-cas"
+
+// traefikCachePriority is above the priority of the router of each site,
+// which is the length of its rule, so the routers of its cache policy win
+const traefikCachePriority = 10000
+
+type TraefikSiteBuilder struct {
+	serverName string
+	upstream   string
+	configPath string
+	// cache is nil unless traefik.cacheRouters is set, since its routers use
+	// PathRegexp, which Traefik v2 doesn't have
+	cache *CachePolicy
+}
+
+type traefikRouter struct {
+	Rule        string   `yaml:"rule"`
+	Service     string   `yaml:"service"`
+	Middlewares []string `yaml:"middlewares"`
+	Priority    int      `yaml:"priority,omitempty"`
+}
+
+type traefikAddPrefix struct {
+	Prefix string `yaml:"prefix"`
+}
+
+type traefikHeaders struct {
+	CustomResponseHeaders map[string]string `yaml:"customResponseHeaders"`
+}
+
+type traefikMiddleware struct {
+	AddPrefix *traefikAddPrefix `yaml:"addPrefix,omitempty"`
+	Headers   *traefikHeaders   `yaml:"headers,omitempty"`
+	Compress  *struct{}         `yaml:"compress,omitempty"`
+}
+
+type traefikServer struct {
+	URL string `yaml:"url"`
+}
+
+type traefikService struct {
+	LoadBalancer struct {
+		Servers []traefikServer `yaml:"servers"`
+	} `yaml:"loadBalancer"`
+}
+
+type traefikConfig struct {
+	HTTP struct {
+		Routers     map[string]*traefikRouter     `yaml:"routers"`
+		Middlewares map[string]*traefikMiddleware `yaml:"middlewares"`
+		Services    map[string]*traefikService    `yaml:"services"`
+	} `yaml:"http"`
+}
+
+func (sb *TraefikSiteBuilder) Build(sites []Site) (string, error) {
+	service := fmt.Sprintf("%s-sites", traefikPrefix)
+	compress := fmt.Sprintf("%s-compress", traefikPrefix)
+
+	var config traefikConfig
+	config.HTTP.Routers = map[string]*traefikRouter{}
+	config.HTTP.Middlewares = map[string]*traefikMiddleware{
+		compress: {Compress: &struct{}{}},
+	}
+	upstream := &traefikService{}
+	upstream.LoadBalancer.Servers = []traefikServer{{URL: sb.upstream}}
+	config.HTTP.Services = map[string]*traefikService{service: upstream}
+
+	for _, site := range sites {
+		name := fmt.Sprintf("%s-%s", traefikPrefix, site.Label)
+		config.HTTP.Middlewares[name+"-root"] = &traefikMiddleware{
+			AddPrefix: &traefikAddPrefix{Prefix: fmt.Sprintf("/%s", site.Hash)},
+		}
+		config.HTTP.Middlewares[name+"-headers"] = &traefikMiddleware{
+			Headers: &traefikHeaders{CustomResponseHeaders: map[string]string{
+				"Hyper-Cas-Label": site.Label,
+				"Hyper-Cas-Hash":  site.Hash,
+				"Vary":            "Hyper-Cas-Hash",
+			}},
+		}
+		hosts := []string{}
+		for _, domain := range append([]string{sb.Host(site.Label)}, site.Domains...) {
+			hosts = append(hosts, fmt.Sprintf("Host(`%s`)", domain))
+		}
+		host := strings.Join(hosts, " || ")
+		config.HTTP.Routers[name] = &traefikRouter{
+			Rule:        host,
+			Service:     service,
+			Middlewares: []string{name + "-root", name + "-headers", compress},
+		}
+
+		if sb.cache == nil {
+			continue
+		}
+		// A router for each cache rule, the first one with the highest priority
+		matches := sb.cache.Matches(site)
+		for i, match := range matches {
+			cacheName := fmt.Sprintf("%s-cache-%d", name, i)
+			config.HTTP.Middlewares[cacheName] = &traefikMiddleware{
+				Headers: &traefikHeaders{CustomResponseHeaders: map[string]string{
+					"Cache-Control": match.CacheControl,
+				}},
+			}
+			config.HTTP.Routers[cacheName] = &traefikRouter{
+				Rule:        fmt.Sprintf("(%s) && PathRegexp(`%s`)", host, match.Regexp),
+				Service:     service,
+				Middlewares: []string{name + "-root", name + "-headers", cacheName, compress},
+				Priority:    traefikCachePriority + len(matches) - i,
+			}
+		}
+	}
+
+	dat, err := yaml.Marshal(&config)
+	if err != nil {
+		return "", err
+	}
+	return string(dat), nil
+}
+
+func (sb *TraefikSiteBuilder) Generate(site Site) (string, error) {
+	return sb.Build([]Site{site})
+}
+
+func (sb *TraefikSiteBuilder) Host(label string) string {
+	return fmt.Sprintf("%s.%s", label, sb.serverName)
+}
+
+func (sb *TraefikSiteBuilder) Extension() string {
+	return ".yaml"
+}
+
+func (sb *TraefikSiteBuilder) ConfigPath() string {
+	return sb.configPath
+}
+
+func NewTraefikSiteBuilder() (*TraefikSiteBuilder, error) {
+	viper.SetDefault("traefik.upstream", "http://localhost:80")
+	viper.SetDefault("traefik.configPath", "traefik.yaml")
+	sb := &TraefikSiteBuilder{
+		serverName: viper.GetString("traefik.serverName"),
+		upstream:   viper.GetString("traefik.upstream"),
+		configPath: viper.GetString("traefik.configPath"),
+	}
+	if viper.GetBool("traefik.cacheRouters") {
+		cache, err := NewCachePolicy()
+		if err != nil {
+			return nil, err
+		}
+		sb.cache = cache
+	} else if viper.IsSet("cache.rules") || viper.IsSet("cache.labels") || viper.IsSet("cache.immutable") {
+		utils.LogWarn("The cache policy is ignored by Traefik unless traefik.cacheRouters is set.")
+	}
+	return sb, nil
+}
@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+
+	"github.com/vtex/hyper-cas/utils"
+)
+
+func (st *FSStorage) annotationsPath(label string) string {
+	return path.Join(st.rootPath, "annotations", label)
+}
+
+// GetLabelAnnotations from the filesystem. Labels without annotations have
+// an empty map.
+func (st *FSStorage) GetLabelAnnotations(label string) (map[string]string, error) {
+	annotations := map[string]string{}
+	dat, err := ioutil.ReadFile(st.annotationsPath(label))
+	if os.IsNotExist(err) {
+		return annotations, nil
+	}
+	if err != nil {
+		return nil, wrapError("get label annotations", label, err)
+	}
+	err = json.Unmarshal(dat, &annotations)
+	if err != nil {
+		return nil, wrapError("get label annotations", label, err)
+	}
+	return annotations, nil
+}
+
+func (st *FSStorage) storeLabelAnnotations(label string, annotations map[string]string) error {
+	filePath := st.annotationsPath(label)
+	if len(annotations) == 0 {
+		err := os.Remove(filePath)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	err := os.MkdirAll(path.Dir(filePath), os.ModePerm)
+	if err != nil {
+		return err
+	}
+	dat, err := json.Marshal(annotations)
+	if err != nil {
+		return err
+	}
+	unlock, err := utils.Lock(filePath)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return ioutil.WriteFile(filePath, dat, 0644)
+}
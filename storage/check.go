@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/vtex/hyper-cas/utils"
+)
+
+// Check of the health of a storage
+type Check struct {
+	Name       string  `json:"name"`
+	OK         bool    `json:"ok"`
+	Message    string  `json:"message,omitempty"`
+	DurationMs float64 `json:"durationMs"`
+}
+
+func runCheck(name string, check func() (string, error)) *Check {
+	start := time.Now()
+	message, err := check()
+	result := &Check{
+		Name:       name,
+		OK:         err == nil,
+		Message:    message,
+		DurationMs: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		result.Message = err.Error()
+	}
+	return result
+}
+
+// Check that blobs can be written and read, that locks can be acquired and
+// that there is enough free space to store files and sites
+func (st *FSStorage) Check() []*Check {
+	viper.SetDefault("storage.minFreeBytes", 100*1024*1024)
+	viper.SetDefault("storage.minFreePercent", 1)
+	return []*Check{
+		runCheck("probe", st.checkProbe),
+		runCheck("lock", st.checkLock),
+		runCheck("rootPathSpace", func() (string, error) { return checkSpace(st.rootPath) }),
+		runCheck("sitesPathSpace", func() (string, error) { return checkSpace(st.sitesPath) }),
+	}
+}
+
+// checkProbe writes a probe blob to rootPath and reads it back
+func (st *FSStorage) checkProbe() (string, error) {
+	tempDir := path.Join(st.rootPath, "tmp")
+	err := os.MkdirAll(tempDir, os.ModePerm)
+	if err != nil {
+		return "", err
+	}
+	probe := []byte(utils.RandString(32))
+	probePath := path.Join(tempDir, fmt.Sprintf("readiness-%s", probe))
+	defer os.Remove(probePath)
+
+	err = ioutil.WriteFile(probePath, probe, 0644)
+	if err != nil {
+		return "", err
+	}
+	dat, err := ioutil.ReadFile(probePath)
+	if err != nil {
+		return "", err
+	}
+	if !bytes.Equal(dat, probe) {
+		return "", fmt.Errorf("probe blob was read back with different contents")
+	}
+	return "", nil
+}
+
+// checkLock acquires and releases a lock in rootPath
+func (st *FSStorage) checkLock() (string, error) {
+	if !viper.GetBool("file.enableLocks") {
+		return "locks are disabled", nil
+	}
+	lockPath := path.Join(st.rootPath, "tmp", fmt.Sprintf("readiness-%s.lock", utils.RandString(32)))
+	defer os.Remove(lockPath)
+	unlock, err := utils.Lock(lockPath)
+	if err != nil {
+		return "", err
+	}
+	return "", unlock()
+}
+
+// checkSpace fails if the filesystem of dir has less than
+// `storage.minFreeBytes` or `storage.minFreePercent` available
+func checkSpace(dir string) (string, error) {
+	available, total, err := utils.DiskSpace(dir)
+	if err != nil {
+		return "", err
+	}
+	percent := 100.0
+	if total > 0 {
+		percent = float64(available) / float64(total) * 100
+	}
+	message := fmt.Sprintf("%d of %d bytes available (%.1f%%)", available, total, percent)
+	minBytes := viper.GetInt64("storage.minFreeBytes")
+	minPercent := viper.GetFloat64("storage.minFreePercent")
+	if int64(available) < minBytes || percent < minPercent {
+		return "", fmt.Errorf("%s, below the minimum of %d bytes or %.1f%%", message, minBytes, minPercent)
+	}
+	return message, nil
+}
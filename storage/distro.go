@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/vtex/hyper-cas/api"
+	"github.com/vtex/hyper-cas/utils"
+)
+
+// Types of the entries of a distribution directory
+const (
+	DistroEntryFile = api.DistroEntryFile
+	DistroEntryDir  = api.DistroEntryDir
+)
+
+// DistroEntry is a file or directory inside a distribution
+type DistroEntry = api.DistroEntry
+
+// cleanDistroPath normalizes a path inside a distribution, so that "/a/b/",
+// "a/b" and "a/./b" are the same and the root is ""
+func cleanDistroPath(filePath string) string {
+	return strings.Trim(path.Clean("/"+filePath), "/")
+}
+
+func (st *FSStorage) fileSize(hash string) int64 {
+	if !utils.IsHash(hash) {
+		return 0
+	}
+	stat, err := os.Stat(st.filePath(hash))
+	if err != nil {
+		return 0
+	}
+	return stat.Size()
+}
+
+// distroInfo with the size and creation time of a distribution
+func (st *FSStorage) distroInfo(root string) (*DistroInfo, error) {
+	stat, err := os.Stat(path.Join(st.rootPath, "distros", root))
+	if err != nil {
+		return nil, wrapError("get distro", root, err)
+	}
+	contents, err := st.GetDistro(root)
+	if err != nil {
+		return nil, err
+	}
+	info := &DistroInfo{
+		Hash:      root,
+		FileCount: len(contents),
+		CreatedAt: stat.ModTime(),
+	}
+	for _, item := range contents {
+		_, hash := splitFile(item)
+		info.TotalBytes += st.fileSize(hash)
+	}
+	return info, nil
+}
+
+// LookupDistroPath returns the hash of the file in a path of a distribution
+func (st *FSStorage) LookupDistroPath(root, filePath string) (string, error) {
+	contents, err := st.GetDistro(root)
+	if err != nil {
+		return "", err
+	}
+	filePath = cleanDistroPath(filePath)
+	for _, item := range contents {
+		name, hash := splitFile(item)
+		if cleanDistroPath(name) == filePath {
+			return hash, nil
+		}
+	}
+	return "", newError(ErrNotFound, "lookup distro path", root, "path %q was not found", filePath)
+}
+
+// ListDistroDir returns the files and directories directly under a directory
+// of a distribution, sorted by name. Directories have the total size and
+// count of the files under them.
+func (st *FSStorage) ListDistroDir(root, dir string) ([]*DistroEntry, error) {
+	contents, err := st.GetDistro(root)
+	if err != nil {
+		return nil, err
+	}
+	dir = cleanDistroPath(dir)
+	found := dir == ""
+	entries := map[string]*DistroEntry{}
+	for _, item := range contents {
+		name, hash := splitFile(item)
+		name = cleanDistroPath(name)
+		if dir != "" {
+			if !strings.HasPrefix(name, dir+"/") {
+				continue
+			}
+			name = name[len(dir)+1:]
+		}
+		found = true
+		size := st.fileSize(hash)
+		parts := strings.SplitN(name, "/", 2)
+		if len(parts) == 1 {
+			entries[name] = &DistroEntry{Name: name, Type: DistroEntryFile, Hash: hash, Size: size}
+			continue
+		}
+		entry, ok := entries[parts[0]]
+		if !ok {
+			entry = &DistroEntry{Name: parts[0], Type: DistroEntryDir}
+			entries[parts[0]] = entry
+		}
+		entry.Size += size
+		entry.FileCount++
+	}
+	if !found {
+		return nil, newError(ErrNotFound, "list distro dir", root, "directory %q was not found", dir)
+	}
+
+	list := []*DistroEntry{}
+	for _, entry := range entries {
+		list = append(list, entry)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list, nil
+}
@@ -0,0 +1,249 @@
+package storage
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/vtex/hyper-cas/sitebuilder"
+	"github.com/vtex/hyper-cas/utils"
+)
+
+var hostname = regexp.MustCompile(`^([a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?\.)*[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// domainsPath has the custom domains of a label
+func (st *FSStorage) domainsPath(label string) string {
+	return path.Join(st.rootPath, "domains", "labels", label)
+}
+
+// hostPath has the label a custom domain belongs to
+func (st *FSStorage) hostPath(host string) string {
+	return path.Join(st.rootPath, "domains", "hosts", host)
+}
+
+// normalizeDomains lowercases, validates, sorts and removes repeated domains
+func normalizeDomains(label string, domains []string) ([]string, error) {
+	seen := map[string]struct{}{}
+	normalized := []string{}
+	for _, domain := range domains {
+		domain = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+		if len(domain) > 253 || !hostname.MatchString(domain) {
+			return nil, newError(ErrInvalidInput, "set label domains", label, "%q is not a valid domain", domain)
+		}
+		if _, ok := seen[domain]; ok {
+			continue
+		}
+		seen[domain] = struct{}{}
+		normalized = append(normalized, domain)
+	}
+	sort.Strings(normalized)
+	return normalized, nil
+}
+
+// GetLabelDomains from the filesystem. Labels without custom domains have an
+// empty list.
+func (st *FSStorage) GetLabelDomains(label string) ([]string, error) {
+	domains := []string{}
+	dat, err := ioutil.ReadFile(st.domainsPath(label))
+	if os.IsNotExist(err) {
+		return domains, nil
+	}
+	if err != nil {
+		return nil, wrapError("get label domains", label, err)
+	}
+	err = json.Unmarshal(dat, &domains)
+	if err != nil {
+		return nil, wrapError("get label domains", label, err)
+	}
+	return domains, nil
+}
+
+// lockDomains while the domains of a label change, so two labels never
+// claim the same domain
+func (st *FSStorage) lockDomains() (func(), error) {
+	lockPath := path.Join(st.rootPath, "domains", "lock")
+	err := os.MkdirAll(path.Dir(lockPath), os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+	st.domainsLock.Lock()
+	unlock, err := utils.Lock(lockPath)
+	if err != nil {
+		st.domainsLock.Unlock()
+		return nil, err
+	}
+	return func() {
+		unlock()
+		st.domainsLock.Unlock()
+	}, nil
+}
+
+// hostLabel is the label whose site builder host is domain, or an empty
+// string when there is none
+func (st *FSStorage) hostLabel(domain string) string {
+	for i := range domain {
+		if domain[i] != '.' {
+			continue
+		}
+		label := domain[:i]
+		if st.siteBuilder.Host(label) == domain && st.HasLabel(label) {
+			return label
+		}
+	}
+	return ""
+}
+
+// hostOwner is the label that claimed host as a custom domain, or an empty
+// string when there is none. It must be called with the domains locked.
+func (st *FSStorage) hostOwner(host string) (string, error) {
+	owner, err := ioutil.ReadFile(st.hostPath(host))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	return string(owner), err
+}
+
+// SetLabelDomains replaces the custom domains a label answers on, besides
+// the one of the site builder. A domain can only belong to one label, so
+// domains of other labels and site builder hosts of labels are a conflict.
+// Returns the normalized domains.
+func (st *FSStorage) SetLabelDomains(label string, domains []string) ([]string, error) {
+	domains, err := normalizeDomains(label, domains)
+	if err != nil {
+		return nil, err
+	}
+
+	unlock, err := st.lockDomains()
+	if err != nil {
+		return nil, wrapError("set label domains", label, err)
+	}
+	defer unlock()
+	// Labels are stored under the same lock, so the configuration can't be
+	// generated for a hash or annotations that were just replaced
+	hash, err := st.GetLabel(label)
+	if err != nil {
+		return nil, err
+	}
+	annotations, err := st.GetLabelAnnotations(label)
+	if err != nil {
+		return nil, err
+	}
+	err = st.checkDomains("set label domains", label, domains)
+	if err != nil {
+		return nil, err
+	}
+
+	// As with labels, the configuration is generated before anything is
+	// written
+	_, aggregate := st.siteBuilder.(sitebuilder.AggregateSiteBuilder)
+	conf := ""
+	if !aggregate {
+		site := st.site(label, hash, annotations)
+		site.Domains = domains
+		conf, err = st.generate("set label domains", site)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = st.storeLabelDomains(label, domains)
+	if err != nil {
+		return nil, wrapError("set label domains", label, err)
+	}
+	if aggregate {
+		err = st.storeSitesConf()
+	} else {
+		err = st.storeLabelConf(label, conf)
+	}
+	if err != nil {
+		return nil, wrapError("set label domains", label, err)
+	}
+	st.emit(Event{Type: EventLabelDomainsUpdated, Label: label, Hash: hash})
+	return domains, nil
+}
+
+// checkDomains fails when domains belong to another label or are the site
+// builder host of a label. It must be called with the domains locked.
+func (st *FSStorage) checkDomains(op, label string, domains []string) error {
+	for _, domain := range domains {
+		owner, err := st.hostOwner(domain)
+		if err != nil {
+			return wrapError(op, label, err)
+		}
+		if owner != "" && owner != label {
+			return newError(ErrConflict, op, label, "domain %s belongs to label %s", domain, owner)
+		}
+		if owner := st.hostLabel(domain); owner != "" {
+			return newError(ErrConflict, op, label, "domain %s is the host of label %s", domain, owner)
+		}
+	}
+	return nil
+}
+
+// storeLabelDomains claims the domains of a label and releases the ones it
+// no longer has. It must be called with the domains locked.
+func (st *FSStorage) storeLabelDomains(label string, domains []string) error {
+	previous, err := st.GetLabelDomains(label)
+	if err != nil {
+		return err
+	}
+	claimed := map[string]struct{}{}
+	for _, domain := range domains {
+		claimed[domain] = struct{}{}
+		hostPath := st.hostPath(domain)
+		err := os.MkdirAll(path.Dir(hostPath), os.ModePerm)
+		if err != nil {
+			return err
+		}
+		err = utils.WriteFileAtomically(hostPath, []byte(label))
+		if err != nil {
+			return err
+		}
+	}
+
+	filePath := st.domainsPath(label)
+	if len(domains) == 0 {
+		err = os.Remove(filePath)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	} else {
+		err = os.MkdirAll(path.Dir(filePath), os.ModePerm)
+		if err != nil {
+			return err
+		}
+		dat, err := json.Marshal(domains)
+		if err != nil {
+			return err
+		}
+		err = utils.WriteFileAtomically(filePath, dat)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, domain := range previous {
+		if _, ok := claimed[domain]; ok {
+			continue
+		}
+		err := os.Remove(st.hostPath(domain))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteLabelDomains releases the domains of a deleted label
+func (st *FSStorage) deleteLabelDomains(label string) error {
+	unlock, err := st.lockDomains()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return st.storeLabelDomains(label, nil)
+}
@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+
+	"github.com/vtex/hyper-cas/utils"
+)
+
+// Kinds of errors returned by storages. Use errors.Is to check for them.
+var (
+	ErrNotFound     = errors.New("not found")
+	ErrInvalidInput = errors.New("invalid input")
+	ErrConflict     = errors.New("conflict")
+	ErrLocked       = errors.New("lock timeout")
+	ErrUnavailable  = errors.New("storage unavailable")
+	ErrHashMismatch = errors.New("hash mismatch")
+)
+
+// Error in a storage operation on a key (a hash or a label)
+type Error struct {
+	Kind error
+	Op   string
+	Key  string
+	Err  error
+}
+
+func (e *Error) Error() string {
+	if e.Op == "" {
+		return e.Err.Error()
+	}
+	if e.Key == "" {
+		return fmt.Sprintf("%s: %v", e.Op, e.Err)
+	}
+	return fmt.Sprintf("%s %s: %v", e.Op, e.Key, e.Err)
+}
+
+// Unwrap returns the error that caused the storage error
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is returns whether target is the kind of the error
+func (e *Error) Is(target error) bool {
+	return e.Kind != nil && e.Kind == target
+}
+
+// newError of the specified kind
+func newError(kind error, op, key, format string, args ...interface{}) error {
+	return &Error{Kind: kind, Op: op, Key: key, Err: fmt.Errorf(format, args...)}
+}
+
+// wrapError classifies errors from the filesystem, returning nil if err is nil
+func wrapError(op, key string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var storageErr *Error
+	if errors.As(err, &storageErr) {
+		return err
+	}
+	var kind error
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		kind = ErrNotFound
+	case errors.Is(err, utils.ErrLockTimeout):
+		kind = ErrLocked
+	case errors.Is(err, os.ErrPermission),
+		errors.Is(err, syscall.ENOSPC),
+		errors.Is(err, syscall.EROFS),
+		errors.Is(err, syscall.EIO):
+		kind = ErrUnavailable
+	}
+	return &Error{Kind: kind, Op: op, Key: key, Err: err}
+}
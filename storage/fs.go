@@ -3,12 +3,17 @@ package storage
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path"
+	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/spf13/viper"
+	"github.com/vtex/hyper-cas/metrics"
 	"github.com/vtex/hyper-cas/sitebuilder"
 	"github.com/vtex/hyper-cas/utils"
 	"go.uber.org/zap"
@@ -19,6 +24,12 @@ type FSStorage struct {
 	rootPath    string
 	sitesPath   string
 	siteBuilder sitebuilder.SiteBuilder
+
+	listenersLock sync.RWMutex
+	listeners     []Listener
+
+	sitesConfLock sync.Mutex
+	domainsLock   sync.Mutex
 }
 
 // NewFSStorage with the specified settings
@@ -44,6 +55,22 @@ func NewFSStorage(siteBuilder sitebuilder.SiteBuilder) (*FSStorage, error) {
 	}, nil
 }
 
+// Subscribe listener to the events emitted by the storage
+func (st *FSStorage) Subscribe(listener Listener) {
+	st.listenersLock.Lock()
+	defer st.listenersLock.Unlock()
+	st.listeners = append(st.listeners, listener)
+}
+
+func (st *FSStorage) emit(event Event) {
+	event.Time = time.Now().UTC()
+	st.listenersLock.RLock()
+	defer st.listenersLock.RUnlock()
+	for _, listener := range st.listeners {
+		listener(event)
+	}
+}
+
 func symlink(filePath, symlinkPath string) error {
 	logger := utils.LoggerWith(
 		zap.String("filePath", filePath),
@@ -68,70 +95,182 @@ func (st *FSStorage) filePath(hash string) string {
 
 // Store files in the filesystem
 func (st *FSStorage) Store(hash string, value []byte) error {
+	if !utils.IsHash(hash) {
+		return newError(ErrInvalidInput, "store file", hash, "invalid hash")
+	}
 	fileDir := path.Join(st.rootPath, "files", hash[0:2], hash[2:4])
 	filePath := path.Join(fileDir, hash)
 	fileTemp := fmt.Sprintf("%s_%s", filePath, utils.RandString(16))
 
 	err := os.MkdirAll(fileDir, os.ModePerm)
 	if err != nil {
-		return err
+		return wrapError("store file", hash, err)
 	}
 
 	err = ioutil.WriteFile(fileTemp, value, 0644)
 	if err != nil {
-		return err
+		return wrapError("store file", hash, err)
 	}
 
+	existed, err := st.moveFile(fileTemp, hash)
+	if err != nil {
+		return wrapError("store file", hash, err)
+	}
+	metrics.FilesStored.Inc()
+	metrics.BytesStored.Add(float64(len(value)))
+	if existed {
+		metrics.DuplicateFilesStored.Inc()
+	}
+	return nil
+}
+
+// StoreStream writes the contents of reader to the filesystem while hashing
+// them, so files are never fully loaded in memory
+func (st *FSStorage) StoreStream(reader io.Reader) (string, error) {
+	return st.storeStream(reader, "")
+}
+
+// StoreStreamWithHash writes the contents of reader to the filesystem if
+// they hash to hash. Contents with another hash are not stored.
+func (st *FSStorage) StoreStreamWithHash(hash string, reader io.Reader) error {
+	if !utils.IsHash(hash) {
+		return newError(ErrInvalidInput, "store file", hash, "invalid hash")
+	}
+	_, err := st.storeStream(reader, hash)
+	return err
+}
+
+func (st *FSStorage) storeStream(reader io.Reader, declaredHash string) (string, error) {
+	tempDir := path.Join(st.rootPath, "tmp")
+	err := os.MkdirAll(tempDir, os.ModePerm)
+	if err != nil {
+		return "", wrapError("store file", "", err)
+	}
+	fileTemp := path.Join(tempDir, utils.RandString(32))
+
+	file, err := os.OpenFile(fileTemp, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0644)
+	if err != nil {
+		return "", wrapError("store file", "", err)
+	}
+	hasher := utils.NewHasher()
+	size, err := io.Copy(file, io.TeeReader(reader, hasher))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(fileTemp)
+		return "", wrapError("store file", "", err)
+	}
+
+	hash := fmt.Sprintf("%x", hasher.Sum(nil))
+	if declaredHash != "" && hash != declaredHash {
+		os.Remove(fileTemp)
+		return "", newError(ErrHashMismatch, "store file", declaredHash, "the file contents hash to %s", hash)
+	}
+	err = os.MkdirAll(path.Dir(st.filePath(hash)), os.ModePerm)
+	if err != nil {
+		os.Remove(fileTemp)
+		return "", wrapError("store file", hash, err)
+	}
+	existed, err := st.moveFile(fileTemp, hash)
+	if err != nil {
+		return "", wrapError("store file", hash, err)
+	}
+	metrics.FilesStored.Inc()
+	metrics.BytesStored.Add(float64(size))
+	if existed {
+		metrics.DuplicateFilesStored.Inc()
+	}
+	return hash, nil
+}
+
+// moveFile to the path of hash, returning whether the file was already
+// stored
+func (st *FSStorage) moveFile(fileTemp, hash string) (bool, error) {
+	filePath := st.filePath(hash)
 	unlock, err := utils.Lock(filePath)
 	if err != nil {
 		utils.LogError("failed to lock file", zap.Error(err))
 		os.Remove(fileTemp)
-		return err
+		return false, err
 	}
 	defer unlock()
 
+	existed := utils.FileExists(filePath)
 	err = os.Rename(fileTemp, filePath)
 	if err != nil {
 		utils.LogError("failed to move file to proper path", zap.Error(err))
 		os.Remove(fileTemp)
-		return err
+		return false, err
 	}
-	return nil
+	return existed, nil
 }
 
 // Get a file from the filesystem
 func (st *FSStorage) Get(hash string) ([]byte, error) {
-	filePath := path.Join(st.rootPath, "files", hash[0:2], hash[2:4], hash)
+	if !utils.IsHash(hash) {
+		return nil, newError(ErrInvalidInput, "get file", hash, "invalid hash")
+	}
+	filePath := st.filePath(hash)
 	if !utils.FileExists(filePath) {
-		return nil, fmt.Errorf("file %s was not found", filePath)
+		return nil, newError(ErrNotFound, "get file", hash, "file was not found")
 	}
 	unlock, err := utils.Lock(filePath)
+	if err != nil {
+		return nil, wrapError("get file", hash, err)
+	}
 	defer unlock()
 
 	dat, err := ioutil.ReadFile(filePath)
 	if err != nil {
-		return nil, err
+		return nil, wrapError("get file", hash, err)
 	}
 
 	return dat, nil
 }
 
+// Open a file in the filesystem for reading, returning its size. Files are
+// only ever replaced atomically, so they are not locked while being read.
+func (st *FSStorage) Open(hash string) (Blob, int64, error) {
+	if !utils.IsHash(hash) {
+		return nil, 0, newError(ErrInvalidInput, "open file", hash, "invalid hash")
+	}
+	file, err := os.Open(st.filePath(hash))
+	if err != nil {
+		return nil, 0, wrapError("open file", hash, err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, wrapError("open file", hash, err)
+	}
+	return file, info.Size(), nil
+}
+
 // Has the file in the filesystem?
 func (st *FSStorage) Has(hash string) bool {
-	filePath := path.Join(st.rootPath, "files", hash[0:2], hash[2:4], hash)
-	if !utils.FileExists(filePath) {
+	if !utils.IsHash(hash) {
 		return false
 	}
-
-	dat, err := ioutil.ReadFile(filePath)
+	file, err := os.Open(st.filePath(hash))
 	if err != nil {
 		return false
 	}
+	defer file.Close()
 
-	datHash := utils.HashBytes(dat)
-	datHashStr := fmt.Sprintf("%x", datHash)
+	// Files are hashed as they are read, so large files aren't loaded into
+	// memory to be checked
+	hasher := utils.NewHasher()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return false
+	}
+	return fmt.Sprintf("%x", hasher.Sum(nil)) == hash
+}
 
-	return datHashStr == hash
+// Exists returns whether a file is stored for the hash, without reading it
+// like Has does
+func (st *FSStorage) Exists(hash string) bool {
+	return utils.IsHash(hash) && utils.FileExists(st.filePath(hash))
 }
 
 func splitFile(hash string) (string, string) {
@@ -141,19 +280,33 @@ func splitFile(hash string) (string, string) {
 
 // StoreDistro in the filesytem
 func (st *FSStorage) StoreDistro(root string, hashes []string) error {
+	for _, item := range hashes {
+		if !strings.Contains(item, ":") {
+			return newError(ErrInvalidInput, "store distro", root, "item %q should be formatted as path:hash", item)
+		}
+	}
+	rules, err := st.parseDistroRules(root, hashes)
+	if err != nil {
+		return err
+	}
+	start := time.Now()
 	dir := path.Join(st.sitesPath, fmt.Sprintf("%s%s", utils.RandString(32), root))
 	defer func() {
 		if utils.DirExists(dir) {
 			os.RemoveAll(dir)
 		}
 	}()
-	err := st.storeDistroLinks(dir, root, hashes)
+	err = st.storeDistroLinks(dir, root, hashes)
 	if err != nil {
-		return err
+		return wrapError("store distro", root, err)
+	}
+	err = st.storeDistroRules(root, rules)
+	if err != nil {
+		return wrapError("store distro", root, err)
 	}
 	err = st.storeDistroFile(root, hashes)
 	if err != nil {
-		return err
+		return wrapError("store distro", root, err)
 	}
 
 	finalPath := path.Join(st.sitesPath, root)
@@ -164,8 +317,10 @@ func (st *FSStorage) StoreDistro(root string, hashes []string) error {
 			utils.LogWarn("Distribution path already exist. Ignoring rename...", zap.Error(err), zap.String("path", finalPath))
 			return nil
 		}
-		return err
+		return wrapError("store distro", root, err)
 	}
+	metrics.DistroMaterializationDuration.Observe(time.Since(start).Seconds())
+	st.emit(Event{Type: EventDistroCreated, Hash: root})
 
 	return nil
 }
@@ -182,7 +337,7 @@ func (st *FSStorage) storeDistroLinks(dir, root string, hashes []string) error {
 		}
 		err = symlink(filePath, symlinkPath)
 		if err != nil {
-			return fmt.Errorf("Error creating symlink between %s and %s: %v", filePath, symlinkPath, err)
+			return fmt.Errorf("Error creating symlink between %s and %s: %w", filePath, symlinkPath, err)
 		}
 	}
 
@@ -197,6 +352,9 @@ func (st *FSStorage) storeDistroFile(root string, hashes []string) error {
 	}
 
 	unlock, err := utils.Lock(filePath)
+	if err != nil {
+		return err
+	}
 	defer unlock()
 
 	contents, err := json.Marshal(hashes)
@@ -214,18 +372,24 @@ func (st *FSStorage) storeDistroFile(root string, hashes []string) error {
 // GetDistro from the filesystem
 func (st *FSStorage) GetDistro(root string) ([]string, error) {
 	filePath := path.Join(st.rootPath, "distros", root)
+	if !utils.FileExists(filePath) {
+		return nil, newError(ErrNotFound, "get distro", root, "distro was not found")
+	}
 	unlock, err := utils.Lock(filePath)
+	if err != nil {
+		return nil, wrapError("get distro", root, err)
+	}
 	defer unlock()
 
 	dat, err := ioutil.ReadFile(filePath)
 	if err != nil {
-		return nil, err
+		return nil, wrapError("get distro", root, err)
 	}
 
 	var contents []string
 	err = json.Unmarshal(dat, &contents)
 	if err != nil {
-		return nil, err
+		return nil, wrapError("get distro", root, err)
 	}
 
 	return contents, nil
@@ -237,62 +401,526 @@ func (st *FSStorage) HasDistro(root string) bool {
 	return utils.FileExists(filePath)
 }
 
-// StoreLabel in the filesystem
+// StoreLabel in the filesystem, keeping its annotations
 func (st *FSStorage) StoreLabel(label, hash string) error {
-	err := st.storeLabelFile(label, hash)
+	return st.StoreLabelWithAnnotations(label, hash, nil)
+}
+
+// StoreLabelWithAnnotations in the filesystem. The annotations replace the
+// ones of the label, unless they are nil.
+func (st *FSStorage) StoreLabelWithAnnotations(label, hash string, annotations map[string]string) error {
+	return st.storeLabel(label, hash, annotations, nil, false)
+}
+
+// RevertLabel restores the distribution, annotations and domains of a label,
+// deleting it when info is nil. Its events are marked as reverted.
+func (st *FSStorage) RevertLabel(label string, info *LabelInfo) error {
+	if info == nil {
+		return st.deleteLabel(label, true)
+	}
+	annotations := info.Annotations
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	domains := info.Domains
+	if domains == nil {
+		domains = []string{}
+	}
+	return st.storeLabel(label, info.Hash, annotations, domains, true)
+}
+
+// storeLabel with its annotations and domains, which are kept when nil
+func (st *FSStorage) storeLabel(label, hash string, annotations map[string]string, domains []string, reverted bool) error {
+	if err := sitebuilder.ValidateSite(sitebuilder.Site{Label: label, Hash: hash, Annotations: annotations}); err != nil {
+		return newError(ErrInvalidInput, "store label", label, "%v", err)
+	}
+	if !st.HasDistro(hash) {
+		return newError(ErrInvalidInput, "store label", label, "distribution %q was not found", hash)
+	}
+	// Distributions stored with another site builder can have rules
+	if !st.supportsRules() {
+		if rules, err := st.GetDistroRules(hash); err == nil && (len(rules.Redirects) > 0 || len(rules.Headers) > 0) {
+			return newError(ErrInvalidInput, "store label", label, "distribution %s has %s or %s files, which are not supported by the site builder", hash, sitebuilder.RedirectsFile, sitebuilder.HeadersFile)
+		}
+	}
+
+	// The domains of the label can't change until its configuration is
+	// written, or it could be written without them
+	unlock, err := st.lockDomains()
 	if err != nil {
-		return err
+		return wrapError("store label", label, err)
+	}
+	defer unlock()
+	oldHash := ""
+	if st.HasLabel(label) {
+		oldHash, _ = st.GetLabel(label)
+	}
+	if annotations == nil {
+		annotations, err = st.GetLabelAnnotations(label)
+		if err != nil {
+			return err
+		}
 	}
-	err = st.storeLabelConf(label, hash)
+	if oldHash == "" {
+		host := st.siteBuilder.Host(label)
+		owner, err := st.hostOwner(host)
+		if err != nil {
+			return wrapError("store label", label, err)
+		}
+		if owner != "" && owner != label {
+			return newError(ErrConflict, "store label", label, "its host %s is a domain of label %s", host, owner)
+		}
+	}
+	oldDomains, err := st.GetLabelDomains(label)
 	if err != nil {
 		return err
 	}
+	if domains != nil {
+		err = st.checkDomains("store label", label, domains)
+		if err != nil {
+			return err
+		}
+	}
+
+	// The configuration is generated before anything is written, so a label
+	// is never changed when its configuration can't be generated
+	_, aggregate := st.siteBuilder.(sitebuilder.AggregateSiteBuilder)
+	conf := ""
+	if !aggregate {
+		site := st.site(label, hash, annotations)
+		if domains != nil {
+			site.Domains = domains
+		}
+		conf, err = st.generate("store label", site)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = st.storeLabelAnnotations(label, annotations)
+	if err != nil {
+		return wrapError("store label", label, err)
+	}
+	revision, err := st.storeLabelFile(label, hash)
+	if err != nil {
+		return wrapError("store label", label, err)
+	}
+	if domains != nil {
+		err = st.storeLabelDomains(label, domains)
+		if err != nil {
+			return wrapError("store label", label, err)
+		}
+	}
+	if aggregate {
+		err = st.storeSitesConf()
+	} else {
+		err = st.storeLabelConf(label, conf)
+	}
+	if err != nil {
+		return wrapError("store label", label, err)
+	}
+	metrics.LabelUpdates.Inc()
+	if oldHash != hash {
+		st.emit(Event{Type: EventLabelUpdated, Label: label, Hash: hash, OldHash: oldHash, Revision: revision, Reverted: reverted})
+	}
+	if domains != nil && strings.Join(domains, " ") != strings.Join(oldDomains, " ") {
+		st.emit(Event{Type: EventLabelDomainsUpdated, Label: label, Hash: hash, Reverted: reverted})
+	}
 
 	return nil
 }
 
-func (st *FSStorage) storeLabelFile(label, hash string) error {
+// storeLabelFile returns the new revision of the label, which is the
+// modification time of its file. The time is moved forward when needed, so
+// revisions increase even on filesystems with coarse timestamps.
+func (st *FSStorage) storeLabelFile(label, hash string) (int64, error) {
 	filePath := path.Join(st.rootPath, "labels", label)
 	err := os.MkdirAll(path.Dir(filePath), os.ModePerm)
 	if err != nil {
-		return err
+		return 0, err
+	}
+
+	var previous time.Time
+	if info, err := os.Stat(filePath); err == nil {
+		previous = info.ModTime()
 	}
 
 	unlock, err := utils.Lock(filePath)
+	if err != nil {
+		return 0, err
+	}
 	defer unlock()
 
-	return ioutil.WriteFile(filePath, []byte(hash), 0644)
+	err = ioutil.WriteFile(filePath, []byte(hash), 0644)
+	if err != nil {
+		return 0, err
+	}
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return 0, err
+	}
+	modTime := info.ModTime()
+	if !modTime.After(previous) {
+		modTime = previous.Add(time.Microsecond)
+		err = os.Chtimes(filePath, modTime, modTime)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return modTime.UnixNano(), nil
+}
+
+// confPath is where the site configuration of a label is written
+func (st *FSStorage) confPath(label string) string {
+	return path.Join(st.sitesPath, label+st.siteBuilder.Extension())
 }
 
-func (st *FSStorage) storeLabelConf(label, hash string) error {
-	conf, err := st.siteBuilder.Generate(label, hash)
+// SiteConfPath returns the file with the site configuration of a label,
+// which is shared by every label with aggregate site builders
+func (st *FSStorage) SiteConfPath(label string) string {
+	if builder, ok := st.siteBuilder.(sitebuilder.AggregateSiteBuilder); ok {
+		return st.sitesConfPath(builder)
+	}
+	return st.confPath(label)
+}
+
+func (st *FSStorage) sitesConfPath(builder sitebuilder.AggregateSiteBuilder) string {
+	confPath := builder.ConfigPath()
+	if !path.IsAbs(confPath) {
+		confPath = path.Join(st.sitesPath, confPath)
+	}
+	return confPath
+}
+
+// site of a label, with the distribution it points to
+func (st *FSStorage) site(label, hash string, annotations map[string]string) sitebuilder.Site {
+	site := sitebuilder.Site{Label: label, Hash: hash, Annotations: annotations}
+	if utils.IsHash(hash) {
+		// Aggregate builders rebuild the sites of every label whenever one
+		// changes and don't use the distribution, which takes reading the
+		// size of each of its files
+		if _, aggregate := st.siteBuilder.(sitebuilder.AggregateSiteBuilder); !aggregate {
+			if info, err := st.distroInfo(hash); err == nil {
+				site.Distro = sitebuilder.Distro{
+					FileCount:  info.FileCount,
+					TotalBytes: info.TotalBytes,
+					CreatedAt:  info.CreatedAt,
+				}
+			}
+		}
+		if rules, err := st.GetDistroRules(hash); err == nil {
+			site.Rules = *rules
+		}
+	}
+	if domains, err := st.GetLabelDomains(label); err == nil {
+		site.Domains = domains
+	}
+	return site
+}
+
+// generate the configuration of a site, once its fields are validated
+func (st *FSStorage) generate(op string, site sitebuilder.Site) (string, error) {
+	if err := sitebuilder.ValidateSite(site); err != nil {
+		return "", newError(ErrInvalidInput, op, site.Label, "%v", err)
+	}
+	conf, err := st.siteBuilder.Generate(site)
+	return conf, wrapError(op, site.Label, err)
+}
+
+func (st *FSStorage) storeLabelConf(label, conf string) error {
+	confPath := st.confPath(label)
+	unlock, err := utils.Lock(confPath)
 	if err != nil {
 		return err
 	}
+	defer unlock()
 
-	confPath := path.Join(st.sitesPath, fmt.Sprintf("%s.conf", label))
-	unlock, err := utils.Lock(confPath)
+	return utils.WriteFileAtomically(confPath, []byte(conf))
+}
+
+// storeSitesConf rewrites the configuration document of an aggregate site
+// builder with the sites of every label
+func (st *FSStorage) storeSitesConf() error {
+	builder := st.siteBuilder.(sitebuilder.AggregateSiteBuilder)
+	confPath := st.sitesConfPath(builder)
+
+	// Labels stored concurrently must not write documents missing each other
+	st.sitesConfLock.Lock()
+	defer st.sitesConfLock.Unlock()
+	unlock, err := utils.Lock(confPath + ".lock")
+	if err != nil {
+		return err
+	}
 	defer unlock()
 
-	return ioutil.WriteFile(confPath, []byte(conf), 0644)
+	entries, err := listDir(path.Join(st.rootPath, "labels"))
+	if err != nil {
+		return err
+	}
+	sites := []sitebuilder.Site{}
+	for _, entry := range entries {
+		hash, err := ioutil.ReadFile(path.Join(st.rootPath, "labels", entry.Name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		annotations, err := st.GetLabelAnnotations(entry.Name)
+		if err != nil {
+			return err
+		}
+		site := st.site(entry.Name, string(hash), annotations)
+		// A label stored before labels were validated can't break the sites
+		// of every other label
+		if err := sitebuilder.ValidateSite(site); err != nil {
+			utils.LogError("Skipping invalid label in the site configuration.", zap.String("label", entry.Name), zap.Error(err))
+			continue
+		}
+		sites = append(sites, site)
+	}
+	conf, err := builder.Build(sites)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(path.Dir(confPath), os.ModePerm)
+	if err != nil {
+		return err
+	}
+	return utils.WriteFileAtomically(confPath, []byte(conf))
 }
 
 // GetLabel from the filesystem
 func (st *FSStorage) GetLabel(label string) (string, error) {
 	filePath := path.Join(st.rootPath, "labels", label)
+	if !utils.FileExists(filePath) {
+		return "", newError(ErrNotFound, "get label", label, "label was not found")
+	}
 	unlock, err := utils.Lock(filePath)
+	if err != nil {
+		return "", wrapError("get label", label, err)
+	}
 	defer unlock()
 
 	dat, err := ioutil.ReadFile(filePath)
 	if err != nil {
-		return "", err
+		return "", wrapError("get label", label, err)
 	}
 
 	return string(dat), nil
 }
 
+// DeleteLabel and its site configuration from the filesystem
+func (st *FSStorage) DeleteLabel(label string) error {
+	return st.deleteLabel(label, false)
+}
+
+func (st *FSStorage) deleteLabel(label string, reverted bool) error {
+	filePath := path.Join(st.rootPath, "labels", label)
+	if !utils.FileExists(filePath) {
+		return newError(ErrNotFound, "delete label", label, "label was not found")
+	}
+	oldHash, err := st.GetLabel(label)
+	if err != nil {
+		return err
+	}
+
+	_, aggregate := st.siteBuilder.(sitebuilder.AggregateSiteBuilder)
+	if !aggregate {
+		confPath := st.confPath(label)
+		unlock, err := utils.Lock(confPath)
+		if err != nil {
+			return wrapError("delete label", label, err)
+		}
+		err = os.Remove(confPath)
+		unlock()
+		if err != nil && !os.IsNotExist(err) {
+			return wrapError("delete label", label, err)
+		}
+	}
+
+	unlock, err := utils.Lock(filePath)
+	if err != nil {
+		return wrapError("delete label", label, err)
+	}
+	err = os.Remove(filePath)
+	unlock()
+	if err != nil {
+		return wrapError("delete label", label, err)
+	}
+	err = st.storeLabelAnnotations(label, nil)
+	if err != nil {
+		return wrapError("delete label", label, err)
+	}
+	err = st.deleteLabelDomains(label)
+	if err != nil {
+		return wrapError("delete label", label, err)
+	}
+	if aggregate {
+		err = st.storeSitesConf()
+		if err != nil {
+			return wrapError("delete label", label, err)
+		}
+	}
+	metrics.LabelUpdates.Inc()
+	st.emit(Event{Type: EventLabelDeleted, Label: label, OldHash: oldHash, Reverted: reverted})
+
+	return nil
+}
+
+// GetLabelInfo with the hash and revision of the label
+func (st *FSStorage) GetLabelInfo(label string) (*LabelInfo, error) {
+	filePath := path.Join(st.rootPath, "labels", label)
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return nil, wrapError("get label", label, err)
+	}
+	hash, err := st.GetLabel(label)
+	if err != nil {
+		return nil, err
+	}
+	annotations, err := st.GetLabelAnnotations(label)
+	if err != nil {
+		return nil, err
+	}
+	domains, err := st.GetLabelDomains(label)
+	if err != nil {
+		return nil, err
+	}
+	return &LabelInfo{
+		Name:        label,
+		Hash:        hash,
+		Revision:    info.ModTime().UnixNano(),
+		UpdatedAt:   info.ModTime(),
+		Annotations: annotations,
+		Domains:     domains,
+	}, nil
+}
+
 // HasLabel in the filesystem?
 func (st *FSStorage) HasLabel(label string) bool {
 	filePath := path.Join(st.rootPath, "labels", label)
 	return utils.FileExists(filePath)
 }
+
+// Stats of the files in the filesystem
+func (st *FSStorage) Stats() (*Stats, error) {
+	stats := &Stats{}
+	filesPath := path.Join(st.rootPath, "files")
+	if !utils.DirExists(filesPath) {
+		return stats, nil
+	}
+	err := filepath.Walk(filesPath, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() && utils.IsHash(info.Name()) {
+			stats.FileCount++
+			stats.FileBytes += info.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, wrapError("stats", "", err)
+	}
+	return stats, nil
+}
+
+func listDir(dir string) ([]listEntry, error) {
+	entries := []listEntry{}
+	if !utils.DirExists(dir) {
+		return entries, nil
+	}
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		name, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, listEntry{Name: filepath.ToSlash(name), ModTime: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// ListLabels in the filesystem
+func (st *FSStorage) ListLabels(options ListOptions) (*LabelList, error) {
+	entries, err := listDir(path.Join(st.rootPath, "labels"))
+	if err != nil {
+		return nil, wrapError("list labels", "", err)
+	}
+	page, next, err := paginate(entries, options)
+	if err != nil {
+		return nil, err
+	}
+
+	list := &LabelList{Labels: []*LabelInfo{}, NextCursor: next}
+	for _, entry := range page {
+		hash, err := st.GetLabel(entry.Name)
+		if err != nil {
+			return nil, err
+		}
+		annotations, err := st.GetLabelAnnotations(entry.Name)
+		if err != nil {
+			return nil, err
+		}
+		domains, err := st.GetLabelDomains(entry.Name)
+		if err != nil {
+			return nil, err
+		}
+		list.Labels = append(list.Labels, &LabelInfo{
+			Name:        entry.Name,
+			Hash:        hash,
+			Revision:    entry.ModTime.UnixNano(),
+			UpdatedAt:   entry.ModTime,
+			Annotations: annotations,
+			Domains:     domains,
+		})
+	}
+	return list, nil
+}
+
+// LabelRevisions of every label in the filesystem, by label. Unlike
+// ListLabels, only the label files are read.
+func (st *FSStorage) LabelRevisions() (map[string]int64, error) {
+	entries, err := listDir(path.Join(st.rootPath, "labels"))
+	if err != nil {
+		return nil, wrapError("list labels", "", err)
+	}
+	revisions := make(map[string]int64, len(entries))
+	for _, entry := range entries {
+		revisions[entry.Name] = entry.ModTime.UnixNano()
+	}
+	return revisions, nil
+}
+
+// ListDistros in the filesystem
+func (st *FSStorage) ListDistros(options ListOptions) (*DistroList, error) {
+	entries, err := listDir(path.Join(st.rootPath, "distros"))
+	if err != nil {
+		return nil, wrapError("list distros", "", err)
+	}
+	page, next, err := paginate(entries, options)
+	if err != nil {
+		return nil, err
+	}
+
+	list := &DistroList{Distros: []*DistroInfo{}, NextCursor: next}
+	for _, entry := range page {
+		info, err := st.distroInfo(entry.Name)
+		if err != nil {
+			return nil, err
+		}
+		list.Distros = append(list.Distros, info)
+	}
+	return list, nil
+}
@@ -1,5 +1,12 @@
 package storage
 
+import (
+	"io"
+
+	"github.com/vtex/hyper-cas/api"
+	"github.com/vtex/hyper-cas/sitebuilder"
+)
+
 type StorageType int
 
 const (
@@ -8,14 +15,86 @@ const (
 
 type Storage interface {
 	Store(key string, value []byte) error
+	StoreStream(reader io.Reader) (string, error)
+	StoreStreamWithHash(hash string, reader io.Reader) error
 	Get(hash string) ([]byte, error)
+	Open(hash string) (Blob, int64, error)
 	Has(hash string) bool
+	Exists(hash string) bool
 
 	StoreDistro(hash string, contents []string) error
 	GetDistro(root string) ([]string, error)
 	HasDistro(hash string) bool
+	ListDistros(options ListOptions) (*DistroList, error)
+	LookupDistroPath(root, path string) (string, error)
+	ListDistroDir(root, dir string) ([]*DistroEntry, error)
+	GetDistroRules(root string) (*sitebuilder.Rules, error)
 
 	StoreLabel(hash string, label string) error
+	StoreLabelWithAnnotations(label, hash string, annotations map[string]string) error
+	GetLabelAnnotations(label string) (map[string]string, error)
+	GetLabelDomains(label string) ([]string, error)
+	SetLabelDomains(label string, domains []string) ([]string, error)
 	GetLabel(label string) (string, error)
 	HasLabel(label string) bool
+	GetLabelInfo(label string) (*LabelInfo, error)
+	DeleteLabel(label string) error
+	RevertLabel(label string, info *LabelInfo) error
+	ListLabels(options ListOptions) (*LabelList, error)
+	LabelRevisions() (map[string]int64, error)
+
+	Subscribe(listener Listener)
+	SiteConfPath(label string) string
+
+	Stats() (*Stats, error)
+	Check() []*Check
 }
+
+// Blob is a file opened for reading from storage
+type Blob interface {
+	io.ReadSeeker
+	io.Closer
+}
+
+// Stats about the files kept in a storage
+type Stats struct {
+	FileCount int64
+	FileBytes int64
+}
+
+// Types of the events emitted by a storage
+const (
+	EventLabelUpdated        = api.EventLabelUpdated
+	EventLabelDeleted        = api.EventLabelDeleted
+	EventLabelDomainsUpdated = api.EventLabelDomainsUpdated
+	EventLabelReverted       = api.EventLabelReverted
+	EventDistroCreated       = api.EventDistroCreated
+)
+
+// Event emitted by a storage whenever labels or distributions change
+type Event = api.Event
+
+// Listener is called synchronously with every event emitted by a storage,
+// so it must not block
+type Listener func(event Event)
+
+// Sort orders supported when listing labels and distributions
+const (
+	SortByName     = api.SortByName
+	SortByModified = api.SortByModified
+)
+
+// ListOptions filter and paginate labels and distributions
+type ListOptions = api.ListOptions
+
+// LabelInfo describes a label and the distribution it points to
+type LabelInfo = api.LabelInfo
+
+// LabelList is a page of labels
+type LabelList = api.LabelList
+
+// DistroInfo describes a distribution and the files in it
+type DistroInfo = api.DistroInfo
+
+// DistroList is a page of distributions
+type DistroList = api.DistroList
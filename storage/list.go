@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+)
+
+type listEntry struct {
+	Name    string    `json:"n"`
+	ModTime time.Time `json:"t"`
+}
+
+func encodeCursor(entry listEntry) (string, error) {
+	dat, err := json.Marshal(entry)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(dat), nil
+}
+
+func decodeCursor(cursor string) (*listEntry, error) {
+	dat, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, newError(ErrInvalidInput, "", "", "invalid cursor '%s'", cursor)
+	}
+	var entry listEntry
+	err = json.Unmarshal(dat, &entry)
+	if err != nil {
+		return nil, newError(ErrInvalidInput, "", "", "invalid cursor '%s'", cursor)
+	}
+	return &entry, nil
+}
+
+// ValidateListOptions returns an error if the options can't be used to list
+func ValidateListOptions(options ListOptions) error {
+	if options.SortBy != "" && options.SortBy != SortByName && options.SortBy != SortByModified {
+		return newError(ErrInvalidInput, "", "", "invalid sort order '%s' (expected '%s' or '%s')", options.SortBy, SortByName, SortByModified)
+	}
+	if options.Limit < 0 {
+		return newError(ErrInvalidInput, "", "", "invalid limit %d", options.Limit)
+	}
+	if options.Cursor != "" {
+		_, err := decodeCursor(options.Cursor)
+		return err
+	}
+	return nil
+}
+
+// paginate filters entries by prefix, sorts them and returns the page after
+// the cursor along with the cursor for the next page
+func paginate(entries []listEntry, options ListOptions) ([]listEntry, string, error) {
+	err := ValidateListOptions(options)
+	if err != nil {
+		return nil, "", err
+	}
+
+	less := func(a, b listEntry) bool { return a.Name < b.Name }
+	if options.SortBy == SortByModified {
+		less = func(a, b listEntry) bool {
+			if a.ModTime.Equal(b.ModTime) {
+				return a.Name < b.Name
+			}
+			return a.ModTime.After(b.ModTime)
+		}
+	}
+
+	filtered := []listEntry{}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name, options.Prefix) {
+			filtered = append(filtered, entry)
+		}
+	}
+	sort.Slice(filtered, func(i, j int) bool { return less(filtered[i], filtered[j]) })
+
+	if options.Cursor != "" {
+		cursor, _ := decodeCursor(options.Cursor)
+		start := sort.Search(len(filtered), func(i int) bool { return less(*cursor, filtered[i]) })
+		filtered = filtered[start:]
+	}
+	if options.Limit == 0 || len(filtered) <= options.Limit {
+		return filtered, "", nil
+	}
+
+	page := filtered[:options.Limit]
+	next, err := encodeCursor(page[len(page)-1])
+	if err != nil {
+		return nil, "", err
+	}
+	return page, next, nil
+}
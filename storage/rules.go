@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+
+	"github.com/vtex/hyper-cas/sitebuilder"
+	"github.com/vtex/hyper-cas/utils"
+)
+
+func (st *FSStorage) rulesPath(root string) string {
+	return path.Join(st.rootPath, "rules", root)
+}
+
+// parseDistroRules from the `_redirects`, `_headers` and `hyper-cas.json`
+// files in the root of a distribution
+func (st *FSStorage) parseDistroRules(root string, hashes []string) (*sitebuilder.Rules, error) {
+	rules := &sitebuilder.Rules{}
+	for _, item := range hashes {
+		name, hash := splitFile(item)
+		name = cleanDistroPath(name)
+		if name != sitebuilder.RedirectsFile && name != sitebuilder.HeadersFile && name != sitebuilder.ManifestFile {
+			continue
+		}
+		if name != sitebuilder.ManifestFile && !st.supportsRules() {
+			return nil, newError(ErrInvalidInput, "store distro", root, "%s files are not supported by the site builder", name)
+		}
+		if !utils.IsHash(hash) || !st.Has(hash) {
+			return nil, newError(ErrInvalidInput, "store distro", root, "file %s was not found in storage", name)
+		}
+		dat, err := st.Get(hash)
+		if err != nil {
+			return nil, err
+		}
+		switch name {
+		case sitebuilder.RedirectsFile:
+			rules.Redirects, err = sitebuilder.ParseRedirects(dat)
+		case sitebuilder.HeadersFile:
+			rules.Headers, err = sitebuilder.ParseHeaders(dat)
+		default:
+			var manifest *sitebuilder.Manifest
+			manifest, err = sitebuilder.ParseManifest(dat)
+			if err == nil {
+				rules.Cache = manifest.Cache
+			}
+		}
+		if err != nil {
+			return nil, newError(ErrInvalidInput, "store distro", root, "%v", err)
+		}
+	}
+	return rules, nil
+}
+
+// supportsRules returns whether the site builder turns `_redirects` and
+// `_headers` files into rules. Otherwise they would be ignored and served.
+func (st *FSStorage) supportsRules() bool {
+	builder, ok := st.siteBuilder.(sitebuilder.RulesSiteBuilder)
+	return ok && builder.SupportsRules()
+}
+
+func (st *FSStorage) storeDistroRules(root string, rules *sitebuilder.Rules) error {
+	if rules.Empty() {
+		return nil
+	}
+	filePath := st.rulesPath(root)
+	err := os.MkdirAll(path.Dir(filePath), os.ModePerm)
+	if err != nil {
+		return err
+	}
+	dat, err := json.Marshal(rules)
+	if err != nil {
+		return err
+	}
+	return utils.WriteFileAtomically(filePath, dat)
+}
+
+// GetDistroRules from the filesystem. Distributions without `_redirects`,
+// `_headers` and `hyper-cas.json` files have no rules.
+func (st *FSStorage) GetDistroRules(root string) (*sitebuilder.Rules, error) {
+	rules := &sitebuilder.Rules{}
+	dat, err := ioutil.ReadFile(st.rulesPath(root))
+	if os.IsNotExist(err) {
+		return rules, nil
+	}
+	if err != nil {
+		return nil, wrapError("get distro rules", root, err)
+	}
+	err = json.Unmarshal(dat, rules)
+	if err != nil {
+		return nil, wrapError("get distro rules", root, err)
+	}
+	return rules, nil
+}
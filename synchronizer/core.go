@@ -1,21 +1,18 @@
 package synchronizer
 
 import (
+	"archive/tar"
 	"bytes"
+	"context"
 	"fmt"
 	"io/ioutil"
-	"net/http"
-	"net/url"
 	"os"
-	"path"
 	"path/filepath"
-	"sort"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/gojektech/heimdall"
-	"github.com/gojektech/heimdall/httpclient"
+	"github.com/vtex/hyper-cas/client"
 	"github.com/vtex/hyper-cas/utils"
 	"go.uber.org/zap"
 )
@@ -23,37 +20,54 @@ import (
 // Sync contents of root dir to CAS
 type Sync struct {
 	rootDir               string
-	apiURL                string
 	wg                    sync.WaitGroup
 	jobChan               chan *fileUpdateJob
 	respChan              chan *fileUpdateResponse
-	fileUploadClient      *httpclient.Client
-	metadataClient        *httpclient.Client
+	filesClient           *client.Client
+	metadataClient        *client.Client
 	maxConcurrentRequests int
+	archiveBatchSize      int
+	archiveMaxFileSize    int
+	runID                 string
+
+	uploadErrLock sync.Mutex
+	uploadErr     error
 }
 
-// NewSync creates a Sync
-func NewSync(root, apiURL string, requestRetriesCount, maxConcurrentRequests, httpTimeoutMs, distroHTTPTimeoutMs int) *Sync {
-	fileUploadClient := initHTTPClient(requestRetriesCount, maxConcurrentRequests, httpTimeoutMs)
-	metadataClient := initHTTPClient(requestRetriesCount, maxConcurrentRequests, distroHTTPTimeoutMs)
-	s := &Sync{
+// NewSync creates a Sync that uploads files with filesClient and sends the
+// other requests (missing files, distributions and labels) with
+// metadataClient, which usually has a longer timeout
+func NewSync(root string, filesClient, metadataClient *client.Client, maxConcurrentRequests int) *Sync {
+	return &Sync{
 		rootDir:               root,
-		apiURL:                apiURL,
-		fileUploadClient:      fileUploadClient,
+		filesClient:           filesClient,
 		metadataClient:        metadataClient,
 		maxConcurrentRequests: maxConcurrentRequests,
+		runID:                 utils.NewID(),
 	}
-	return s
+}
+
+// SetArchiveBatching packs files up to maxFileSize bytes into tar archives of
+// up to batchSize bytes, uploading each archive in a single request.
+// A batchSize of 0 disables archive uploads.
+func (s *Sync) SetArchiveBatching(batchSize, maxFileSize int) {
+	s.archiveBatchSize = batchSize
+	s.archiveMaxFileSize = maxFileSize
 }
 
 type fileUpdateJob struct {
 	path     string
 	filePath string
+	hash     string
+	size     int
+	batch    []*fileUpdateJob
 }
 
 type fileUpdateResponse struct {
 	path          string
+	filePath      string
 	hash          string
+	size          int
 	duration      time.Duration
 	alreadyExists bool
 }
@@ -66,8 +80,8 @@ func readAll(path string) (string, error) {
 	return string(dat), nil
 }
 
-func listFiles(jobChan chan *fileUpdateJob, path string) (int, error) {
-	files := 0
+func listFiles(path string) ([]*fileUpdateJob, error) {
+	jobs := []*fileUpdateJob{}
 	err := filepath.Walk(
 		path,
 		func(p string, info os.FileInfo, err error) error {
@@ -75,172 +89,306 @@ func listFiles(jobChan chan *fileUpdateJob, path string) (int, error) {
 				return err
 			}
 			if !info.IsDir() {
-				files++
 				utils.LogDebug("added job to queue", zap.String("path", p))
-				jobChan <- &fileUpdateJob{
+				jobs = append(jobs, &fileUpdateJob{
 					path: p,
-				}
+				})
 			}
 			return nil
 		},
 	)
-	return files, err
-}
-
-func initHTTPClient(requestRetryCount, maxConcurrentRequests, httpTimeoutMs int) *httpclient.Client {
-	// First set a backoff mechanism. Constant backoff increases the backoff at a constant rate
-	backoffInterval := 2 * time.Millisecond
-	// Define a maximum jitter interval. It must be more than 1*time.Millisecond
-	maximumJitterInterval := 5 * time.Millisecond
-
-	backoff := heimdall.NewConstantBackoff(backoffInterval, maximumJitterInterval)
-
-	// Create a new retry mechanism with the backoff
-	retrier := heimdall.NewRetrier(backoff)
-
-	return httpclient.NewClient(
-		httpclient.WithHTTPTimeout(time.Duration(httpTimeoutMs)*time.Millisecond),
-		httpclient.WithRetrier(retrier),
-		httpclient.WithRetryCount(requestRetryCount),
-	)
+	return jobs, err
 }
 
-func (s *Sync) startWorkers(workerCount int) {
+func (s *Sync) startWorkers(workerCount, responseCount int, worker func()) {
 	s.jobChan = make(chan *fileUpdateJob, workerCount)
-	s.respChan = make(chan *fileUpdateResponse, 1000000000)
+	s.respChan = make(chan *fileUpdateResponse, responseCount)
 
 	for i := 0; i < workerCount; i++ {
 		s.wg.Add(1)
-		go s.worker()
+		go func() {
+			defer func() {
+				utils.LogDebug("worker closed")
+				(&s.wg).Done()
+			}()
+			worker()
+		}()
 	}
 }
 
-func (s *Sync) worker() {
-	defer func() {
-		utils.LogDebug("worker closed")
-		(&s.wg).Done()
-	}()
-
+func (s *Sync) hashWorker() {
 	for {
 		job, ok := <-s.jobChan
 		if !ok {
 			return
 		}
-		utils.LogDebug("processing job", zap.String("filePath", job.filePath), zap.String("path", job.path))
+		utils.LogDebug("hashing file", zap.String("path", job.path))
+		start := time.Now()
 		filePath := strings.Replace(job.path, s.rootDir+"/", "", 1)
 		content, err := readAll(job.path)
+		if err != nil {
+			utils.LogError("failed to read file.", zap.String("path", filePath), zap.String("filePath", job.path), zap.Error(err))
+			s.respChan <- nil
+			continue
+		}
+		s.respChan <- &fileUpdateResponse{
+			path:     filePath,
+			filePath: job.path,
+			hash:     fmt.Sprintf("%x", utils.Hash(content)),
+			size:     len(content),
+			duration: time.Since(start),
+		}
+	}
+}
+
+func (s *Sync) uploadWorker(ctx context.Context) {
+	for {
+		job, ok := <-s.jobChan
+		if !ok {
+			return
+		}
+		if job.batch != nil {
+			s.uploadBatch(ctx, job.batch)
+			continue
+		}
+		utils.LogDebug("uploading file", zap.String("path", job.path), zap.String("hash", job.hash))
 		logger := utils.LoggerWith(
-			zap.String("path", filePath),
-			zap.String("filePath", job.path),
+			zap.String("path", job.path),
+			zap.String("filePath", job.filePath),
+			zap.String("requestId", s.fileRequestID(job.hash)),
 		)
+		content, err := readAll(job.filePath)
 		if err != nil {
 			logger.Error("failed to read file.", zap.Error(err))
 			s.respChan <- nil
 			continue
 		}
-		hash, alreadyExists, duration, err := s.uploadFile(filePath, content)
+		hash, duration, err := s.uploadFile(ctx, job.hash, content)
 		if err != nil {
-			logger.Error("failed to upload file.", zap.String("path", job.path), zap.Error(err))
+			logger.Error("failed to upload file.", zap.Error(err))
+			s.recordUploadError(err)
 			s.respChan <- nil
 			continue
 		}
 		s.respChan <- &fileUpdateResponse{
-			path:          filePath,
-			hash:          hash,
-			duration:      duration,
-			alreadyExists: alreadyExists,
+			path:     job.path,
+			filePath: job.filePath,
+			hash:     hash,
+			duration: duration,
 		}
 	}
 }
 
-func (s *Sync) doReq(client *httpclient.Client, method, reqURL, body string, isURLEncoded bool) (int, string) {
-	u, err := url.Parse(s.apiURL)
+func (s *Sync) uploadBatch(ctx context.Context, batch []*fileUpdateJob) {
+	utils.LogDebug("uploading archive", zap.Int("files", len(batch)), zap.String("requestId", s.archiveRequestID(batch)))
+	hashes, duration, err := s.uploadArchive(ctx, batch)
 	if err != nil {
-		return 500, fmt.Sprintf("Invalid URL %s", s.apiURL)
+		utils.LogError("failed to upload archive.", zap.Int("files", len(batch)), zap.String("requestId", s.archiveRequestID(batch)), zap.Error(err))
+		s.recordUploadError(err)
+		for range batch {
+			s.respChan <- nil
+		}
+		return
 	}
-	u.Path = path.Join(u.Path, reqURL)
-
-	var req *http.Request
-	if body != "" {
-		req, _ = http.NewRequest(method, u.String(), bytes.NewBuffer([]byte(body)))
-	} else {
-		req, _ = http.NewRequest(method, u.String(), nil)
+	for _, job := range batch {
+		if hashes[job.path] != job.hash {
+			utils.LogError(
+				"archive entry stored with unexpected hash.",
+				zap.String("path", job.path),
+				zap.String("hash", job.hash),
+				zap.String("storedHash", hashes[job.path]),
+			)
+			s.respChan <- nil
+			continue
+		}
+		s.respChan <- &fileUpdateResponse{
+			path:     job.path,
+			filePath: job.filePath,
+			hash:     job.hash,
+			duration: duration,
+		}
 	}
-	if isURLEncoded {
-		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+}
+
+// recordUploadError keeps the first error of the uploads, so it is returned
+// with the details of the failed request
+func (s *Sync) recordUploadError(err error) {
+	s.uploadErrLock.Lock()
+	defer s.uploadErrLock.Unlock()
+	if s.uploadErr == nil {
+		s.uploadErr = err
 	}
+}
+
+// Requests for files are sent with the request ID of the run followed by
+// part of the hash of the file, so they can be correlated with the run.
+func (s *Sync) fileRequestID(hash string) string {
+	return fmt.Sprintf("%s-%s", s.runID, hash[0:12])
+}
 
-	resp, err := client.Do(req)
+func (s *Sync) uploadFile(ctx context.Context, hash, content string) (string, time.Duration, error) {
+	start := time.Now()
+	err := s.filesClient.PutFileWithHash(client.WithRequestID(ctx, s.fileRequestID(hash)), hash, strings.NewReader(content))
 	if err != nil {
-		return 500, fmt.Sprintf("%s for %s failed with %v.", method, reqURL, err)
+		return "", time.Since(start), err
 	}
-	defer resp.Body.Close()
+	return hash, time.Since(start), nil
+}
+
+func (s *Sync) archiveRequestID(batch []*fileUpdateJob) string {
+	return fmt.Sprintf("%s-archive-%s", s.runID, batch[0].hash[0:12])
+}
 
-	respBody, err := ioutil.ReadAll(resp.Body)
+func (s *Sync) uploadArchive(ctx context.Context, batch []*fileUpdateJob) (map[string]string, time.Duration, error) {
+	start := time.Now()
+	var buf bytes.Buffer
+	archive := tar.NewWriter(&buf)
+	for _, job := range batch {
+		content, err := readAll(job.filePath)
+		if err != nil {
+			return nil, time.Since(start), err
+		}
+		err = archive.WriteHeader(&tar.Header{Name: job.path, Mode: 0644, Size: int64(len(content))})
+		if err != nil {
+			return nil, time.Since(start), err
+		}
+		_, err = archive.Write([]byte(content))
+		if err != nil {
+			return nil, time.Since(start), err
+		}
+	}
+	err := archive.Close()
 	if err != nil {
-		return 500, fmt.Sprintf("%s for %s failed with %v.", method, reqURL, err)
+		return nil, time.Since(start), err
 	}
 
-	return resp.StatusCode, string(respBody)
+	hashes, err := s.filesClient.PutArchive(client.WithRequestID(ctx, s.archiveRequestID(batch)), bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		return nil, time.Since(start), err
+	}
+	return hashes, time.Since(start), nil
 }
 
-func (s *Sync) uploadFile(path, content string) (string, bool, time.Duration, error) {
-	hashBytes := utils.Hash(content)
-	hash := fmt.Sprintf("%x", hashBytes)
-	fileURL := fmt.Sprintf("/file/%s", hash)
-	start := time.Now()
-	status, _ := s.doReq(s.fileUploadClient, "HEAD", fileURL, "", false)
-	if status == 200 {
-		return hash, true, time.Since(start), nil
+func (s *Sync) missingFiles(ctx context.Context, hashes []string) (map[string]bool, error) {
+	missing, err := s.metadataClient.MissingFiles(ctx, hashes)
+	if err != nil {
+		return nil, err
 	}
-	status, body := s.doReq(s.fileUploadClient, "PUT", "/file", content, false)
-	if status != 200 {
-		return "", false, time.Since(start), fmt.Errorf("failed to put %s. Status: %d Error: %s", path, status, body)
+	result := map[string]bool{}
+	for _, hash := range missing {
+		result[hash] = true
 	}
-	return body, false, time.Since(start), nil
+	return result, nil
 }
 
-func (s *Sync) uploadDistro(hashes map[string]string) (string, time.Duration, error) {
+func (s *Sync) uploadDistro(ctx context.Context, hashes map[string]string) (string, time.Duration, error) {
 	start := time.Now()
-	keys := make([]string, 0, len(hashes))
-	for key := range hashes {
-		keys = append(keys, key)
+	hash, err := s.metadataClient.PutDistro(ctx, hashes)
+	return hash, time.Since(start), err
+}
+
+func (s *Sync) hashFiles() ([]*fileUpdateResponse, error) {
+	jobs, err := listFiles(s.rootDir)
+	if err != nil {
+		return nil, err
 	}
-	sort.Strings(keys)
-	var sb strings.Builder
-	for _, path := range keys {
-		sb.WriteString(path)
-		sb.WriteString(":")
-		sb.WriteString(hashes[path])
-		sb.WriteString("\n")
+	fileCount := len(jobs)
+	s.startWorkers(s.maxConcurrentRequests, fileCount, s.hashWorker)
+	utils.LogDebug("hash workers started.", zap.Int("workerCount", s.maxConcurrentRequests))
+	for _, job := range jobs {
+		s.jobChan <- job
 	}
-	content := sb.String()
-	status, body := s.doReq(s.metadataClient, "PUT", "/distro", content, false)
-	if status != 200 {
-		return "", time.Since(start), fmt.Errorf("failed to put new distro. Status: %d Error: %s", status, body)
+	close(s.jobChan)
+	defer close(s.respChan)
+	utils.LogDebug("Waiting for files to be hashed...")
+	s.wg.Wait()
+
+	files := []*fileUpdateResponse{}
+	for a := 0; a < fileCount; a++ {
+		res := <-s.respChan
+		if res == nil {
+			continue
+		}
+		files = append(files, res)
+	}
+	if len(files) != fileCount {
+		utils.LogError(
+			"failed to hash files.",
+			zap.Int("hashedFiles", len(files)),
+			zap.Int("filesToHash", fileCount),
+		)
+		return nil, fmt.Errorf("failed to hash files")
 	}
-	return body, time.Since(start), nil
+	return files, nil
 }
 
-// HasDistro in hyper-cas with specified hash?
-func (s *Sync) HasDistro(hash string) bool {
-	status, _ := s.doReq(s.metadataClient, "HEAD", fmt.Sprintf("/distro/%s", hash), "", false)
-	return status == 200
-}
+func (s *Sync) uploadMissing(ctx context.Context, files []*fileUpdateResponse, missing map[string]bool) (map[string]time.Duration, error) {
+	jobs := []*fileUpdateJob{}
+	queued := map[string]bool{}
+	var batch *fileUpdateJob
+	batchSize := 0
+	fileCount := 0
+	for _, file := range files {
+		if !missing[file.hash] || queued[file.hash] {
+			continue
+		}
+		queued[file.hash] = true
+		fileCount++
+		job := &fileUpdateJob{path: file.path, filePath: file.filePath, hash: file.hash, size: file.size}
+		if s.archiveBatchSize <= 0 || file.size > s.archiveMaxFileSize {
+			jobs = append(jobs, job)
+			continue
+		}
+		if batch == nil || batchSize+file.size > s.archiveBatchSize {
+			batch = &fileUpdateJob{batch: []*fileUpdateJob{}}
+			batchSize = 0
+			jobs = append(jobs, batch)
+		}
+		batch.batch = append(batch.batch, job)
+		batchSize += file.size
+	}
 
-// SetLabel to specified hash
-func (s *Sync) SetLabel(label, hash string) error {
-	status, body := s.doReq(s.metadataClient, "PUT", "/label", fmt.Sprintf("label=%s&hash=%s", label, hash), true)
-	if status != 200 {
-		return fmt.Errorf("failed to put new distro. Status: %d Error: %s", status, body)
+	s.startWorkers(s.maxConcurrentRequests, fileCount, func() { s.uploadWorker(ctx) })
+	utils.LogDebug("upload workers started.", zap.Int("workerCount", s.maxConcurrentRequests), zap.Int("files", fileCount))
+	for _, job := range jobs {
+		s.jobChan <- job
 	}
-	return nil
+	close(s.jobChan)
+	defer close(s.respChan)
+	utils.LogDebug("Waiting for files to be uploaded...")
+	s.wg.Wait()
+
+	durations := map[string]time.Duration{}
+	for a := 0; a < fileCount; a++ {
+		res := <-s.respChan
+		if res == nil {
+			continue
+		}
+		durations[res.hash] = res.duration
+	}
+	if len(durations) != fileCount {
+		utils.LogError(
+			"failed to upload files to hyper-cas.",
+			zap.Int("uploadedFiles", len(durations)),
+			zap.Int("filesToUpload", fileCount),
+		)
+		if s.uploadErr != nil {
+			return nil, fmt.Errorf("failed to upload files to hyper-cas: %w", s.uploadErr)
+		}
+		return nil, fmt.Errorf("failed to upload files to hyper-cas")
+	}
+	return durations, nil
 }
 
 // Run the sync
-func (s *Sync) Run(label string) (map[string]interface{}, error) {
+func (s *Sync) Run(ctx context.Context, label string) (map[string]interface{}, error) {
 	start := time.Now()
+	s.runID = utils.NewID()
+	s.uploadErr = nil
+	ctx = client.WithRequestID(ctx, s.runID)
+	utils.LogDebug("Starting sync run.", zap.String("requestId", s.runID))
 	result := map[string]interface{}{
+		"requestId": s.runID,
 		"timestamp": int32(time.Now().Unix()),
 		"files":     []map[string]interface{}{},
 		"distro":    map[string]interface{}{},
@@ -249,42 +397,43 @@ func (s *Sync) Run(label string) (map[string]interface{}, error) {
 			"hash":  "",
 		},
 	}
-	s.startWorkers(s.maxConcurrentRequests)
-	utils.LogDebug("workers started.", zap.Int("workerCount", s.maxConcurrentRequests))
-	fileCount, err := listFiles(s.jobChan, s.rootDir)
+	files, err := s.hashFiles()
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	close(s.jobChan)
-	defer close(s.respChan)
-	utils.LogDebug("Waiting for jobs to finish...")
-	s.wg.Wait()
-	utils.LogDebug("Workers finished successfully. Reading results from response channel...")
 	hashes := map[string]string{}
-	for a := 0; a < fileCount; a++ {
-		res := <-s.respChan
-		if res == nil {
-			continue
+	uniqueHashes := []string{}
+	seen := map[string]bool{}
+	for _, file := range files {
+		if !seen[file.hash] {
+			seen[file.hash] = true
+			uniqueHashes = append(uniqueHashes, file.hash)
 		}
-		hashes[res.path] = res.hash
-		result["files"] = append(result["files"].([]map[string]interface{}), map[string]interface{}{
-			"path":     res.path,
-			"hash":     res.hash,
-			"exists":   res.alreadyExists,
-			"duration": res.duration.Milliseconds(),
-		})
+		hashes[file.path] = file.hash
 	}
 	utils.LogDebug("Hashes calculated.", zap.Int("hashes", len(hashes)))
-	if len(hashes) != fileCount {
-		utils.LogError(
-			"failed to upload files to hyper-cas.",
-			zap.Int("uploadedFiled", len(hashes)),
-			zap.Int("filesToUpload", fileCount),
-		)
-		return nil, fmt.Errorf("failed to upload files to hyper-cas")
+
+	missing, err := s.missingFiles(ctx, uniqueHashes)
+	if err != nil {
+		utils.LogError("failed to get missing files from hyper-cas.", zap.Error(err))
+		return nil, err
+	}
+	utils.LogDebug("Missing files retrieved.", zap.Int("missing", len(missing)))
+
+	durations, err := s.uploadMissing(ctx, files, missing)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		result["files"] = append(result["files"].([]map[string]interface{}), map[string]interface{}{
+			"path":     file.path,
+			"hash":     file.hash,
+			"exists":   !missing[file.hash],
+			"duration": (file.duration + durations[file.hash]).Milliseconds(),
+		})
 	}
 
-	distro, distroDuration, err := s.uploadDistro(hashes)
+	distro, distroDuration, err := s.uploadDistro(ctx, hashes)
 	if err != nil {
 		utils.LogError("distro could not be updated.", zap.Error(err))
 		return nil, err
@@ -296,7 +445,7 @@ func (s *Sync) Run(label string) (map[string]interface{}, error) {
 	utils.LogDebug("Distro updated successfully.", zap.String("distro", distro))
 	if label != "" {
 		utils.LogDebug("Label should be set.", zap.String("label", label), zap.String("distro", distro))
-		err = s.SetLabel(label, distro)
+		err = s.metadataClient.SetLabel(ctx, label, distro)
 		if err != nil {
 			utils.LogError("failed to update label.", zap.String("label", label), zap.String("distro", distro), zap.Error(err))
 			return nil, err
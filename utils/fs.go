@@ -1,6 +1,11 @@
 package utils
 
-import "os"
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+)
 
 func FileExists(filename string) bool {
 	info, err := os.Stat(filename)
@@ -17,3 +22,19 @@ func DirExists(dirName string) bool {
 	}
 	return info.IsDir()
 }
+
+// WriteFileAtomically writes to a temporary file that is renamed to
+// filePath, so whatever reads it never reads it partially written
+func WriteFileAtomically(filePath string, dat []byte) error {
+	tempPath := path.Join(path.Dir(filePath), fmt.Sprintf(".%s.%s", path.Base(filePath), RandString(8)))
+	err := ioutil.WriteFile(tempPath, dat, 0644)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tempPath, filePath)
+	if err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+	return nil
+}
@@ -2,10 +2,17 @@ package utils
 
 import (
 	"crypto"
+	"encoding/hex"
+	"hash"
 )
 
+// NewHasher returns the hash used for content keys, for streaming contents
+func NewHasher() hash.Hash {
+	return crypto.SHA1.New()
+}
+
 func HashBytes(content ...[]byte) []byte {
-	h := crypto.SHA1.New()
+	h := NewHasher()
 	for _, d := range content {
 		h.Write(d)
 	}
@@ -15,3 +22,12 @@ func HashBytes(content ...[]byte) []byte {
 func Hash(content string) []byte {
 	return HashBytes([]byte(content))
 }
+
+// IsHash returns whether value is a hex encoded content hash
+func IsHash(value string) bool {
+	if len(value) != crypto.SHA1.Size()*2 {
+		return false
+	}
+	_, err := hex.DecodeString(value)
+	return err == nil
+}
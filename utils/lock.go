@@ -1,16 +1,24 @@
 package utils
 
 import (
+	"sync"
 	"time"
 
 	"github.com/juju/fslock"
 	"github.com/spf13/viper"
+	"github.com/vtex/hyper-cas/metrics"
 )
 
 // Possibility for the future https://medium.com/@gdm85/distributed-locking-for-pennies-distrilock-967347e7f2dd
 
 var noOp = func() error { return nil }
 
+// ErrLockTimeout is returned by Lock when the lock is not acquired in time
+var ErrLockTimeout = fslock.ErrTimeout
+
+var heldLocksMutex sync.Mutex
+var heldLocks = map[*fslock.Lock]struct{}{}
+
 // Lock resource if viper returns it should be locked.
 func Lock(resource string) (func() error, error) {
 	shouldLock := viper.GetBool("file.enableLocks")
@@ -22,9 +30,43 @@ func Lock(resource string) (func() error, error) {
 	}
 
 	lock := fslock.New(resource)
+	start := time.Now()
 	err := lock.LockWithTimeout(time.Millisecond * time.Duration(lockTimeoutMs))
+	metrics.LockWaitDuration.Observe(time.Since(start).Seconds())
 	if err != nil {
+		if err == ErrLockTimeout {
+			metrics.LockTimeouts.Inc()
+		}
 		return noOp, err
 	}
-	return lock.Unlock, nil
+
+	heldLocksMutex.Lock()
+	heldLocks[lock] = struct{}{}
+	heldLocksMutex.Unlock()
+
+	return func() error {
+		heldLocksMutex.Lock()
+		_, held := heldLocks[lock]
+		delete(heldLocks, lock)
+		heldLocksMutex.Unlock()
+		if !held {
+			return nil
+		}
+		return lock.Unlock()
+	}, nil
+}
+
+// ReleaseLocks held by this process, so they are not kept while it shuts down
+func ReleaseLocks() int {
+	heldLocksMutex.Lock()
+	defer heldLocksMutex.Unlock()
+
+	released := 0
+	for lock := range heldLocks {
+		if err := lock.Unlock(); err == nil {
+			released++
+		}
+		delete(heldLocks, lock)
+	}
+	return released
 }
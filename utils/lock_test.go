@@ -61,3 +61,20 @@ func TestLockIsNoOpWhenDisabled(t *testing.T) {
 
 	assert.NoError(t, err)
 }
+
+func TestReleaseLocks(t *testing.T) {
+	viper.Set("file.enableLocks", true)
+	viper.Set("file.lockTimeoutMs", 20)
+	f, cleanup := testFile()
+	defer cleanup()
+	unlock1, err := Lock(f)
+	defer unlock1()
+	assert.NoError(t, err)
+
+	released := ReleaseLocks()
+
+	assert.Equal(t, 1, released)
+	unlock2, err := Lock(f)
+	defer unlock2()
+	assert.NoError(t, err)
+}
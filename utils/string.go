@@ -1,6 +1,10 @@
 package utils
 
-import "math/rand"
+import (
+	cryptorand "crypto/rand"
+	"encoding/hex"
+	"math/rand"
+)
 
 const letterBytes = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
 
@@ -12,3 +16,12 @@ func RandString(n int) string {
 	}
 	return string(b)
 }
+
+// NewID returns a random hex encoded identifier, such as a request ID
+func NewID() string {
+	b := make([]byte, 16)
+	if _, err := cryptorand.Read(b); err != nil {
+		return RandString(32)
+	}
+	return hex.EncodeToString(b)
+}
@@ -10,24 +10,23 @@ import (
 	"os"
 	"strings"
 
-	"github.com/fasthttp/router"
 	"github.com/spf13/viper"
 	"github.com/valyala/fasthttp"
 	"github.com/valyala/fasthttp/fasthttputil"
 )
 
 type App interface {
-	GetRouter() *router.Router
+	Handler() fasthttp.RequestHandler
 }
 
 // serve serves http request using provided fasthttp handler
 func serveRequest(app App, req *http.Request) (*http.Response, error) {
-	router := app.GetRouter()
+	handler := app.Handler()
 	ln := fasthttputil.NewInmemoryListener()
 	defer ln.Close()
 
 	go func() {
-		err := fasthttp.Serve(ln, router.Handler)
+		err := fasthttp.Serve(ln, handler)
 		if err != nil {
 			panic(fmt.Errorf("failed to serve: %v", err))
 		}
@@ -45,6 +44,10 @@ func serveRequest(app App, req *http.Request) (*http.Response, error) {
 }
 
 func DoRequest(app App, method, url, body string) (*http.Response, int, string, error) {
+	return DoRequestWithHeaders(app, method, url, body, nil)
+}
+
+func DoRequestWithHeaders(app App, method, url, body string, headers map[string]string) (*http.Response, int, string, error) {
 	var bodyReader io.Reader
 	if method != "GET" && body != "" {
 		bodyReader = strings.NewReader(body)
@@ -56,6 +59,9 @@ func DoRequest(app App, method, url, body string) (*http.Response, int, string,
 	if method == "POST" || method == "PUT" {
 		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	}
+	for key, value := range headers {
+		r.Header.Set(key, value)
+	}
 
 	res, err := serveRequest(app, r)
 	if err != nil {
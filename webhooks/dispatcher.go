@@ -0,0 +1,440 @@
+package webhooks
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/vtex/hyper-cas/api"
+	"github.com/vtex/hyper-cas/metrics"
+	"github.com/vtex/hyper-cas/storage"
+	"github.com/vtex/hyper-cas/utils"
+	"go.uber.org/zap"
+)
+
+// Headers sent with every delivery
+const (
+	EventHeader     = "X-Hyper-Cas-Event"
+	DeliveryHeader  = "X-Hyper-Cas-Delivery"
+	SignatureHeader = "X-Hyper-Cas-Signature"
+)
+
+// Statuses of a delivery
+const (
+	StatusPending   = api.DeliveryPending
+	StatusDelivered = api.DeliveryDelivered
+	StatusFailed    = api.DeliveryFailed
+)
+
+// Endpoint receives the events it subscribed to. An endpoint without events
+// receives all of them.
+type Endpoint struct {
+	Name   string   `mapstructure:"name"`
+	URL    string   `mapstructure:"url"`
+	Secret string   `mapstructure:"secret"`
+	Events []string `mapstructure:"events"`
+}
+
+// Accepts returns whether the endpoint subscribed to the event type
+func (e *Endpoint) Accepts(eventType string) bool {
+	if len(e.Events) == 0 {
+		return true
+	}
+	for _, event := range e.Events {
+		if event == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// Delivery of an event to an endpoint
+type Delivery = api.Delivery
+
+// payload is the body posted to endpoints
+type payload struct {
+	ID string `json:"id"`
+	storage.Event
+}
+
+// Sign body with the secret of an endpoint. Receivers should compute the
+// same HMAC-SHA256 and compare it to the signature header.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return fmt.Sprintf("sha256=%x", mac.Sum(nil))
+}
+
+// Dispatcher delivers storage events to the configured endpoints. Pending
+// deliveries are kept in a queue directory, so they survive restarts, and
+// finished ones are moved to a log directory that keeps the latest
+// `webhooks.logSize` deliveries.
+type Dispatcher struct {
+	endpoints      []Endpoint
+	queuePath      string
+	logPath        string
+	client         *http.Client
+	maxAttempts    int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	pollInterval   time.Duration
+	logSize        int
+
+	lock sync.Mutex
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewDispatcher with the endpoints in the configuration
+func NewDispatcher() (*Dispatcher, error) {
+	viper.SetDefault("webhooks.path", path.Join(viper.GetString("storage.rootPath"), "webhooks"))
+	viper.SetDefault("webhooks.maxAttempts", 10)
+	viper.SetDefault("webhooks.initialBackoffMs", 1000)
+	viper.SetDefault("webhooks.maxBackoffMs", 600000)
+	viper.SetDefault("webhooks.timeoutMs", 10000)
+	viper.SetDefault("webhooks.pollIntervalMs", 1000)
+	viper.SetDefault("webhooks.logSize", 1000)
+
+	var endpoints []Endpoint
+	err := viper.UnmarshalKey("webhooks.endpoints", &endpoints)
+	if err != nil {
+		return nil, err
+	}
+	// Deliveries refer to their endpoint by name
+	names := map[string]bool{}
+	for _, endpoint := range endpoints {
+		if endpoint.Name == "" {
+			return nil, fmt.Errorf("webhook endpoint with url '%s' does not have a name", endpoint.URL)
+		}
+		if names[endpoint.Name] {
+			return nil, fmt.Errorf("webhook endpoint '%s' is configured more than once", endpoint.Name)
+		}
+		names[endpoint.Name] = true
+		if endpoint.URL == "" {
+			return nil, fmt.Errorf("webhook endpoint '%s' does not have an url", endpoint.Name)
+		}
+	}
+
+	rootPath := viper.GetString("webhooks.path")
+	d := &Dispatcher{
+		endpoints:      endpoints,
+		queuePath:      path.Join(rootPath, "queue"),
+		logPath:        path.Join(rootPath, "log"),
+		client:         &http.Client{Timeout: time.Duration(viper.GetInt("webhooks.timeoutMs")) * time.Millisecond},
+		maxAttempts:    viper.GetInt("webhooks.maxAttempts"),
+		initialBackoff: time.Duration(viper.GetInt("webhooks.initialBackoffMs")) * time.Millisecond,
+		maxBackoff:     time.Duration(viper.GetInt("webhooks.maxBackoffMs")) * time.Millisecond,
+		pollInterval:   time.Duration(viper.GetInt("webhooks.pollIntervalMs")) * time.Millisecond,
+		logSize:        viper.GetInt("webhooks.logSize"),
+	}
+	for _, dir := range []string{d.queuePath, d.logPath} {
+		err = os.MkdirAll(dir, os.ModePerm)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// Enqueue one delivery of the event for each endpoint that subscribed to it
+func (d *Dispatcher) Enqueue(event storage.Event) error {
+	now := time.Now().UTC()
+	for _, endpoint := range d.endpoints {
+		if !endpoint.Accepts(event.Type) {
+			continue
+		}
+		delivery := &Delivery{
+			ID:            fmt.Sprintf("%019d-%s", now.UnixNano(), strings.ToLower(utils.RandString(8))),
+			Endpoint:      endpoint.Name,
+			URL:           endpoint.URL,
+			Event:         event,
+			Status:        StatusPending,
+			CreatedAt:     now,
+			NextAttemptAt: now,
+		}
+		err := writeDelivery(d.queuePath, delivery)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// HandleEvent enqueues deliveries of storage events, logging failures
+func (d *Dispatcher) HandleEvent(event storage.Event) {
+	err := d.Enqueue(event)
+	if err != nil {
+		utils.LogError("Failed to enqueue webhook deliveries.", zap.String("event", event.Type), zap.Error(err))
+	}
+}
+
+// Start delivering queued events every `webhooks.pollIntervalMs`
+func (d *Dispatcher) Start() {
+	d.stop = make(chan struct{})
+	d.done = make(chan struct{})
+	go func() {
+		defer close(d.done)
+		ticker := time.NewTicker(d.pollInterval)
+		defer ticker.Stop()
+		for {
+			d.ProcessQueue()
+			select {
+			case <-d.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop delivering events, waiting for the current deliveries to finish.
+// Pending deliveries stay in the queue for the next start.
+func (d *Dispatcher) Stop() {
+	if d.stop == nil {
+		return
+	}
+	close(d.stop)
+	<-d.done
+	d.stop = nil
+}
+
+// ProcessQueue attempts the deliveries that are due, returning how many
+// deliveries were attempted. Endpoints get their deliveries concurrently, in
+// order, and the remaining ones of an endpoint wait for the next run once
+// one fails, so an endpoint that is down doesn't hold back the others.
+func (d *Dispatcher) ProcessQueue() int {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	names, err := deliveryNames(d.queuePath)
+	if err != nil {
+		utils.LogError("Failed to read webhook queue.", zap.String("path", d.queuePath), zap.Error(err))
+		return 0
+	}
+	due := map[string][]*Delivery{}
+	now := time.Now()
+	for _, name := range names {
+		delivery, err := readDelivery(path.Join(d.queuePath, name))
+		if err != nil {
+			utils.LogError("Failed to read webhook delivery.", zap.String("delivery", name), zap.Error(err))
+			continue
+		}
+		if delivery.NextAttemptAt.After(now) {
+			continue
+		}
+		due[delivery.Endpoint] = append(due[delivery.Endpoint], delivery)
+	}
+
+	var wg sync.WaitGroup
+	attempts := make(chan int, len(due))
+	for _, deliveries := range due {
+		wg.Add(1)
+		go func(deliveries []*Delivery) {
+			defer wg.Done()
+			attempted := 0
+			for _, delivery := range deliveries {
+				attempted++
+				if !d.attempt(delivery) {
+					break
+				}
+			}
+			attempts <- attempted
+		}(deliveries)
+	}
+	wg.Wait()
+	close(attempts)
+	attempted := 0
+	for count := range attempts {
+		attempted += count
+	}
+	if attempted > 0 {
+		d.pruneLog()
+	}
+	return attempted
+}
+
+func (d *Dispatcher) endpoint(name string) *Endpoint {
+	for i := range d.endpoints {
+		if d.endpoints[i].Name == name {
+			return &d.endpoints[i]
+		}
+	}
+	return nil
+}
+
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	backoff := d.initialBackoff
+	for i := 1; i < attempts && backoff < d.maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > d.maxBackoff {
+		return d.maxBackoff
+	}
+	return backoff
+}
+
+// attempt a delivery, returning whether the endpoint accepted it
+func (d *Dispatcher) attempt(delivery *Delivery) bool {
+	logger := utils.LoggerWith(
+		zap.String("delivery", delivery.ID),
+		zap.String("endpoint", delivery.Endpoint),
+		zap.String("event", delivery.Event.Type),
+	)
+	delivery.Attempts++
+	delivery.LastStatusCode = 0
+	delivery.LastError = ""
+
+	secret := ""
+	if endpoint := d.endpoint(delivery.Endpoint); endpoint != nil {
+		secret = endpoint.Secret
+		delivery.URL = endpoint.URL
+	}
+	statusCode, err := d.post(delivery, secret)
+	delivery.LastStatusCode = statusCode
+	now := time.Now().UTC()
+	switch {
+	case err == nil:
+		delivery.Status = StatusDelivered
+		delivery.CompletedAt = &now
+		metrics.WebhookDeliveries.WithLabelValues(StatusDelivered).Inc()
+		logger.Info("Webhook delivered.", zap.Int("attempts", delivery.Attempts))
+	case delivery.Attempts >= d.maxAttempts:
+		delivery.Status = StatusFailed
+		delivery.LastError = err.Error()
+		delivery.CompletedAt = &now
+		metrics.WebhookDeliveries.WithLabelValues(StatusFailed).Inc()
+		logger.Error("Webhook delivery failed. Giving up.", zap.Int("attempts", delivery.Attempts), zap.Error(err))
+	default:
+		delivery.LastError = err.Error()
+		delivery.NextAttemptAt = now.Add(d.backoff(delivery.Attempts))
+		metrics.WebhookDeliveries.WithLabelValues("retried").Inc()
+		logger.Warn("Webhook delivery failed. Retrying later.", zap.Int("attempts", delivery.Attempts), zap.Time("nextAttemptAt", delivery.NextAttemptAt), zap.Error(err))
+	}
+
+	if delivery.Status == StatusPending {
+		err = writeDelivery(d.queuePath, delivery)
+	} else {
+		err = writeDelivery(d.logPath, delivery)
+		if err == nil {
+			err = os.Remove(path.Join(d.queuePath, delivery.ID+".json"))
+		}
+	}
+	if err != nil {
+		logger.Error("Failed to save webhook delivery.", zap.Error(err))
+	}
+	return delivery.Status == StatusDelivered
+}
+
+func (d *Dispatcher) post(delivery *Delivery, secret string) (int, error) {
+	body, err := json.Marshal(&payload{ID: delivery.ID, Event: delivery.Event})
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequest("POST", delivery.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "hyper-cas-webhooks")
+	req.Header.Set(EventHeader, delivery.Event.Type)
+	req.Header.Set(DeliveryHeader, delivery.ID)
+	if secret != "" {
+		req.Header.Set(SignatureHeader, Sign(secret, body))
+	}
+
+	res, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	ioutil.ReadAll(res.Body)
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, fmt.Errorf("endpoint answered with status code %d", res.StatusCode)
+	}
+	return res.StatusCode, nil
+}
+
+func (d *Dispatcher) pruneLog() {
+	names, err := deliveryNames(d.logPath)
+	if err != nil || len(names) <= d.logSize {
+		return
+	}
+	for _, name := range names[:len(names)-d.logSize] {
+		os.Remove(path.Join(d.logPath, name))
+	}
+}
+
+// Deliveries in the queue and in the log, most recent first. If status is
+// not empty, only deliveries with that status are returned.
+func (d *Dispatcher) Deliveries(status string, limit int) ([]*Delivery, error) {
+	deliveries := []*Delivery{}
+	for _, dir := range []string{d.queuePath, d.logPath} {
+		names, err := deliveryNames(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			delivery, err := readDelivery(path.Join(dir, name))
+			if err != nil {
+				// The delivery was attempted and moved while listing
+				continue
+			}
+			if status == "" || delivery.Status == status {
+				deliveries = append(deliveries, delivery)
+			}
+		}
+	}
+	sort.Slice(deliveries, func(i, j int) bool { return deliveries[i].ID > deliveries[j].ID })
+	if limit > 0 && len(deliveries) > limit {
+		deliveries = deliveries[:limit]
+	}
+	return deliveries, nil
+}
+
+func deliveryNames(dir string) ([]string, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, file := range files {
+		if file.Mode().IsRegular() && strings.HasSuffix(file.Name(), ".json") {
+			names = append(names, file.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func readDelivery(filePath string) (*Delivery, error) {
+	dat, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	var delivery Delivery
+	err = json.Unmarshal(dat, &delivery)
+	if err != nil {
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+// writeDelivery atomically, so a delivery is never read half written
+func writeDelivery(dir string, delivery *Delivery) error {
+	dat, err := json.Marshal(delivery)
+	if err != nil {
+		return err
+	}
+	return utils.WriteFileAtomically(path.Join(dir, delivery.ID+".json"), dat)
+}
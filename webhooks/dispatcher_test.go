@@ -0,0 +1,192 @@
+package webhooks
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/vtex/hyper-cas/storage"
+)
+
+// stub records the requests it gets, answering with status
+type stub struct {
+	*httptest.Server
+	lock     sync.Mutex
+	status   int
+	headers  []http.Header
+	bodies   [][]byte
+	received chan struct{}
+}
+
+func newStub(t *testing.T, status int, handle func()) *stub {
+	s := &stub{status: status, received: make(chan struct{}, 100)}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		s.lock.Lock()
+		s.headers = append(s.headers, r.Header)
+		s.bodies = append(s.bodies, body)
+		s.lock.Unlock()
+		s.received <- struct{}{}
+		if handle != nil {
+			handle()
+		}
+		w.WriteHeader(s.status)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *stub) requests() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.bodies)
+}
+
+func newTestDispatcher(t *testing.T, endpoints ...map[string]interface{}) *Dispatcher {
+	dir, err := ioutil.TempDir("", "hyper-cas-webhooks")
+	assert.NoError(t, err)
+	viper.Set("webhooks.path", dir)
+	viper.Set("webhooks.endpoints", endpoints)
+	t.Cleanup(func() {
+		os.RemoveAll(dir)
+		for _, key := range []string{"webhooks.path", "webhooks.endpoints", "webhooks.maxAttempts", "webhooks.initialBackoffMs", "webhooks.maxBackoffMs"} {
+			viper.Set(key, nil)
+		}
+	})
+	d, err := NewDispatcher()
+	assert.NoError(t, err)
+	return d
+}
+
+func TestSign(t *testing.T) {
+	signature := Sign("key", []byte("The quick brown fox jumps over the lazy dog"))
+
+	assert.Equal(t, "sha256=f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8", signature)
+}
+
+func TestDispatcherDeliversSignedEvents(t *testing.T) {
+	s := newStub(t, 200, nil)
+	d := newTestDispatcher(t,
+		map[string]interface{}{"name": "signed", "url": s.URL, "secret": "s3cr3t", "events": []string{storage.EventLabelUpdated}},
+	)
+
+	assert.NoError(t, d.Enqueue(storage.Event{Type: storage.EventDistroCreated, Hash: "ignored"}))
+	assert.NoError(t, d.Enqueue(storage.Event{Type: storage.EventLabelUpdated, Label: "master", Hash: "new"}))
+	assert.Equal(t, 1, d.ProcessQueue())
+
+	assert.Equal(t, 1, s.requests())
+	header, body := s.headers[0], s.bodies[0]
+	assert.Equal(t, storage.EventLabelUpdated, header.Get(EventHeader))
+	assert.Equal(t, Sign("s3cr3t", body), header.Get(SignatureHeader))
+	var sent payload
+	assert.NoError(t, json.Unmarshal(body, &sent))
+	assert.Equal(t, header.Get(DeliveryHeader), sent.ID)
+	assert.Equal(t, "master", sent.Label)
+	deliveries, err := d.Deliveries(StatusDelivered, 0)
+	assert.NoError(t, err)
+	assert.Len(t, deliveries, 1)
+	assert.Equal(t, sent.ID, deliveries[0].ID)
+	assert.Equal(t, 0, d.ProcessQueue())
+}
+
+func TestDispatcherBackoff(t *testing.T) {
+	viper.Set("webhooks.initialBackoffMs", 1000)
+	viper.Set("webhooks.maxBackoffMs", 5000)
+	d := newTestDispatcher(t)
+
+	for attempts, backoff := range []time.Duration{time.Second, time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		assert.Equal(t, backoff, d.backoff(attempts), attempts)
+	}
+}
+
+func TestDispatcherRetriesFailedDeliveries(t *testing.T) {
+	viper.Set("webhooks.maxAttempts", 2)
+	viper.Set("webhooks.initialBackoffMs", 60000)
+	s := newStub(t, 502, nil)
+	d := newTestDispatcher(t, map[string]interface{}{"name": "failing", "url": s.URL})
+	assert.NoError(t, d.Enqueue(storage.Event{Type: storage.EventLabelDeleted, Label: "master"}))
+
+	start := time.Now()
+	assert.Equal(t, 1, d.ProcessQueue())
+	deliveries, err := d.Deliveries(StatusPending, 0)
+	assert.NoError(t, err)
+	assert.Len(t, deliveries, 1)
+	assert.Equal(t, 502, deliveries[0].LastStatusCode)
+	assert.WithinDuration(t, start.Add(time.Minute), deliveries[0].NextAttemptAt, 5*time.Second)
+	// It isn't due before its backoff
+	assert.Equal(t, 0, d.ProcessQueue())
+
+	delivery := deliveries[0]
+	delivery.NextAttemptAt = time.Now()
+	assert.NoError(t, writeDelivery(d.queuePath, delivery))
+	assert.Equal(t, 1, d.ProcessQueue())
+	deliveries, err = d.Deliveries(StatusFailed, 0)
+	assert.NoError(t, err)
+	assert.Len(t, deliveries, 1)
+	assert.Equal(t, 2, deliveries[0].Attempts)
+	assert.Equal(t, 0, d.ProcessQueue())
+}
+
+func TestDispatcherKeepsQueueAcrossRestarts(t *testing.T) {
+	s := newStub(t, 200, nil)
+	d := newTestDispatcher(t, map[string]interface{}{"name": "restarted", "url": s.URL})
+	assert.NoError(t, d.Enqueue(storage.Event{Type: storage.EventLabelUpdated, Label: "master"}))
+
+	restarted, err := NewDispatcher()
+	assert.NoError(t, err)
+
+	assert.Equal(t, 1, restarted.ProcessQueue())
+	assert.Equal(t, 1, s.requests())
+	assert.Equal(t, 0, d.ProcessQueue())
+}
+
+func TestDispatcherDeliversToEndpointsConcurrently(t *testing.T) {
+	release := make(chan struct{})
+	slow := newStub(t, 200, func() { <-release })
+	down := newStub(t, 503, nil)
+	fast := newStub(t, 200, nil)
+	d := newTestDispatcher(t,
+		map[string]interface{}{"name": "slow", "url": slow.URL},
+		map[string]interface{}{"name": "down", "url": down.URL},
+		map[string]interface{}{"name": "fast", "url": fast.URL},
+	)
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, d.Enqueue(storage.Event{Type: storage.EventLabelUpdated, Label: "master"}))
+	}
+
+	processed := make(chan int, 1)
+	go func() { processed <- d.ProcessQueue() }()
+	for i := 0; i < 3; i++ {
+		select {
+		case <-fast.received:
+		case <-time.After(5 * time.Second):
+			t.Fatal("The fast endpoint waited for the slow one.")
+		}
+	}
+	close(release)
+
+	// The endpoint that is down only gets the first of its deliveries
+	assert.Equal(t, 3+1+3, <-processed)
+	assert.Equal(t, 3, slow.requests())
+	assert.Equal(t, 1, down.requests())
+}
+
+func TestNewDispatcherWithInvalidEndpoints(t *testing.T) {
+	for _, endpoints := range [][]map[string]interface{}{
+		{{"url": "http://localhost"}},
+		{{"name": "twice", "url": "http://localhost/a"}, {"name": "twice", "url": "http://localhost/b"}},
+		{{"name": "no-url"}},
+	} {
+		viper.Set("webhooks.endpoints", endpoints)
+		_, err := NewDispatcher()
+		assert.Error(t, err)
+	}
+	viper.Set("webhooks.endpoints", nil)
+}